@@ -0,0 +1,64 @@
+package pusu
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// Capability names an optional feature of the pub/sub protocol. The pub/sub
+// server reports the capabilities it supports on a connection in its reply
+// to the Start message and a client should not use any feature that the
+// server has not reported.
+type Capability string
+
+const (
+	// CapFragments means that messages having payloads larger than
+	// MaxMessagePayload may be sent, split into fragments
+	CapFragments Capability = "fragments"
+	// CapWildcards means that topics containing wildcards may be subscribed
+	// to
+	CapWildcards Capability = "wildcards"
+	// CapRetain means that publications may be retained by the server and
+	// sent to later subscribers
+	CapRetain Capability = "retain"
+	// CapHeaders means that publications may carry headers and that the
+	// server will record the publisher's client ID in them
+	CapHeaders Capability = "headers"
+)
+
+// Capabilities is a collection of Capability values
+type Capabilities []Capability
+
+// Has returns true if the Capabilities include the Capability
+func (caps Capabilities) Has(c Capability) bool {
+	return slices.Contains(caps, c)
+}
+
+// Strings returns the Capabilities as a slice of strings, suitable for
+// including in a StartAckMsgPayload
+func (caps Capabilities) Strings() []string {
+	strs := make([]string, 0, len(caps))
+	for _, c := range caps {
+		strs = append(strs, string(c))
+	}
+
+	return strs
+}
+
+// CapabilitiesFromStrings converts the strings, as found in a
+// StartAckMsgPayload, into Capabilities
+func CapabilitiesFromStrings(strs []string) Capabilities {
+	caps := make(Capabilities, 0, len(strs))
+	for _, s := range strs {
+		caps = append(caps, Capability(s))
+	}
+
+	return caps
+}
+
+// Attr returns a slog.Attr describing the Capabilities
+func (caps Capabilities) Attr() slog.Attr {
+	return slog.String(AttrPfx+"Capabilities",
+		strings.Join(caps.Strings(), ","))
+}
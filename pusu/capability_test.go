@@ -0,0 +1,29 @@
+package pusu
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestCapabilities(t *testing.T) {
+	caps := CapabilitiesFromStrings([]string{"fragments", "retain"})
+
+	testhelper.DiffBool(t, "Capabilities", "has fragments",
+		caps.Has(CapFragments), true)
+	testhelper.DiffBool(t, "Capabilities", "has retain",
+		caps.Has(CapRetain), true)
+	testhelper.DiffBool(t, "Capabilities", "has wildcards",
+		caps.Has(CapWildcards), false)
+	testhelper.DiffStringSlice(t, "Capabilities", "strings",
+		caps.Strings(), []string{"fragments", "retain"})
+
+	expAttr := slog.String(AttrPfx+"Capabilities", "fragments,retain")
+	if attr := caps.Attr(); !attr.Equal(expAttr) {
+		t.Log("Capabilities")
+		t.Log("\t: expected Attr:", expAttr)
+		t.Log("\t:   actual Attr:", attr)
+		t.Error("\t: bad attr")
+	}
+}
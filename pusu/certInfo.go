@@ -82,3 +82,37 @@ func (ci *CertInfo) PopulateCert() error {
 
 	return nil
 }
+
+// SetCert sets the program's certificate directly rather than loading it
+// from the CertFilename and KeyFilename files.
+func (ci *CertInfo) SetCert(cert tls.Certificate) {
+	ci.cert = cert
+	ci.certPopulated = true
+}
+
+// SetCertPool sets the certificate pool directly rather than constructing
+// it from the CACertFilename file.
+func (ci *CertInfo) SetCertPool(certPool *x509.CertPool) {
+	ci.certPool = certPool
+	ci.certPoolPopulated = true
+}
+
+// Populate will populate the program's certificate and the certificate pool
+// from their files unless they have already been populated, either by a
+// previous call or by setting them directly. It will return a non-nil error
+// if either cannot be populated.
+func (ci *CertInfo) Populate() error {
+	if !ci.certPopulated {
+		if err := ci.PopulateCert(); err != nil {
+			return err
+		}
+	}
+
+	if !ci.certPoolPopulated {
+		if err := ci.PopulateCertPool(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package pusu
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"testing"
 
 	"github.com/nickwells/testhelper.mod/v2/testhelper"
@@ -146,3 +148,79 @@ func TestCert(t *testing.T) {
 		})
 	}
 }
+
+func TestCertInfoPopulate(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		ci          CertInfo
+		setCert     bool
+		setCertPool bool
+	}{
+		{
+			ID: testhelper.MkID("nothing set - no such files"),
+			ExpErr: testhelper.MkExpErr(
+				`couldn't load x509 keypair:`,
+				`certFile: "nonesuch"`),
+			ci: CertInfo{
+				CACertFilename: "nonesuch",
+				CertFilename:   "nonesuch",
+				KeyFilename:    "nonesuch",
+			},
+		},
+		{
+			ID: testhelper.MkID("cert set - no such CA cert file"),
+			ExpErr: testhelper.MkExpErr(
+				`couldn't read the CA certificate file: "nonesuch":`),
+			ci: CertInfo{
+				CACertFilename: "nonesuch",
+				CertFilename:   "nonesuch",
+				KeyFilename:    "nonesuch",
+			},
+			setCert: true,
+		},
+		{
+			ID: testhelper.MkID("both set - files not read"),
+			ci: CertInfo{
+				CACertFilename: "nonesuch",
+				CertFilename:   "nonesuch",
+				KeyFilename:    "nonesuch",
+			},
+			setCert:     true,
+			setCertPool: true,
+		},
+		{
+			ID: testhelper.MkID("cert pool set - good files"),
+			ci: CertInfo{
+				CACertFilename: "nonesuch",
+				CertFilename:   "testdata/goodCertfile",
+				KeyFilename:    "testdata/goodKeyfile",
+			},
+			setCertPool: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ci := tc.ci
+
+			if tc.setCert {
+				ci.SetCert(tls.Certificate{})
+			}
+
+			if tc.setCertPool {
+				ci.SetCertPool(x509.NewCertPool())
+			}
+
+			err := ci.Populate()
+			testhelper.CheckExpErr(t, err, tc)
+
+			if err == nil {
+				panicked, panicVal := testhelper.PanicSafe(
+					func() { ci.Cert(); ci.CertPool() })
+				testhelper.ReportUnexpectedPanic(t, tc.IDStr(),
+					panicked, panicVal, nil)
+			}
+		})
+	}
+}
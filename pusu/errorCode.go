@@ -0,0 +1,176 @@
+package pusu
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// ErrorCode classifies the error reported in an Error message so that the
+// client can decide how to respond to it without examining the error text
+type ErrorCode uint32
+
+const (
+	// ErrCodeUnknown is the code for an unclassified error. It is the code
+	// reported for errors from servers which do not give a code.
+	ErrCodeUnknown ErrorCode = iota
+	// ErrCodeBadMessage is the code for a message that is malformed or has
+	// inconsistent contents
+	ErrCodeBadMessage
+	// ErrCodeProtocol is the code for a message that is not expected, such
+	// as a second Start message, or which uses an unsupported protocol
+	ErrCodeProtocol
+	// ErrCodeBadTopic is the code for a message with an invalid topic
+	ErrCodeBadTopic
+	// ErrCodeNamespace is the code for a namespace that is not permitted
+	ErrCodeNamespace
+	// ErrCodeSubscription is the code for a subscription that is already
+	// made or an unsubscription from a topic that is not subscribed to
+	ErrCodeSubscription
+	// ErrCodeUnsupported is the code for a request for a feature that is
+	// not supported
+	ErrCodeUnsupported
+	// ErrCodeTooBig is the code for a message that is too big
+	ErrCodeTooBig
+	// ErrCodeOverloaded is the code for a message that could not be handled
+	// because the server is too busy
+	ErrCodeOverloaded
+)
+
+// These are the errors corresponding to each ErrorCode. A CodedError
+// unwraps to the error for its code so they can be tested for using
+// errors.Is.
+var (
+	ErrUnknown      = errors.New("unknown error")
+	ErrBadMessage   = errors.New("bad message")
+	ErrProtocol     = errors.New("protocol error")
+	ErrBadTopic     = errors.New("bad topic")
+	ErrNamespace    = errors.New("namespace not permitted")
+	ErrSubscription = errors.New("bad subscription")
+	ErrUnsupported  = errors.New("not supported")
+	ErrTooBig       = errors.New("too big")
+	ErrOverloaded   = errors.New("server overloaded")
+)
+
+// codeErrs maps each ErrorCode to its corresponding error
+var codeErrs = map[ErrorCode]error{
+	ErrCodeUnknown:      ErrUnknown,
+	ErrCodeBadMessage:   ErrBadMessage,
+	ErrCodeProtocol:     ErrProtocol,
+	ErrCodeBadTopic:     ErrBadTopic,
+	ErrCodeNamespace:    ErrNamespace,
+	ErrCodeSubscription: ErrSubscription,
+	ErrCodeUnsupported:  ErrUnsupported,
+	ErrCodeTooBig:       ErrTooBig,
+	ErrCodeOverloaded:   ErrOverloaded,
+}
+
+// Err returns the error corresponding to the ErrorCode. An unrecognised
+// code, possibly from a newer server, is treated as ErrUnknown.
+func (ec ErrorCode) Err() error {
+	if err, ok := codeErrs[ec]; ok {
+		return err
+	}
+
+	return ErrUnknown
+}
+
+// Attr returns a slog Attr representing the ErrorCode
+func (ec ErrorCode) Attr() slog.Attr {
+	return slog.String(AttrPfx+"ErrorCode", fmt.Sprintf("%d(%s)", ec, ec))
+}
+
+// CodedError is an error having an ErrorCode and, optionally, some further
+// details. It is the type of the error reported to the client by the
+// pub/sub server. Use errors.As to retrieve it or errors.Is with the error
+// for the code (for instance, ErrBadTopic) to test for a particular kind of
+// error. Fatal is set if the server closed the connection after reporting
+// the error.
+type CodedError struct {
+	Code    ErrorCode
+	Text    string
+	Details map[string]string
+	Fatal   bool
+
+	cause error // the error formed by NewCodedError, nil if not set
+}
+
+// NewCodedError returns a CodedError with the given code and with the text
+// formed from the format and args as for fmt.Errorf. Any errors wrapped
+// with %w can be found with errors.Is and errors.As.
+func NewCodedError(code ErrorCode, format string, args ...any) *CodedError {
+	cause := fmt.Errorf(format, args...)
+
+	return &CodedError{
+		Code:  code,
+		Text:  cause.Error(),
+		cause: cause,
+	}
+}
+
+// WithDetail adds the detail to the CodedError and returns it
+func (e *CodedError) WithDetail(name, value string) *CodedError {
+	if e.Details == nil {
+		e.Details = make(map[string]string)
+	}
+
+	e.Details[name] = value
+
+	return e
+}
+
+// Error returns the error text
+func (e *CodedError) Error() string {
+	return e.Text
+}
+
+// Unwrap returns the error corresponding to the ErrorCode and the error
+// formed by NewCodedError, if any
+func (e *CodedError) Unwrap() []error {
+	if e.cause == nil {
+		return []error{e.Code.Err()}
+	}
+
+	return []error{e.Code.Err(), e.cause}
+}
+
+// ErrorCodeOf returns the ErrorCode of the first CodedError in the error's
+// chain, or ErrCodeUnknown if there is none
+func ErrorCodeOf(err error) ErrorCode {
+	var ce *CodedError
+	if errors.As(err, &ce) {
+		return ce.Code
+	}
+
+	return ErrCodeUnknown
+}
+
+// MakeErrorMsgPayload returns the payload for an Error message reporting
+// the error. The code and details are taken from the first CodedError in
+// the error's chain, if any. The fatal flag should be set if the connection
+// will be closed after the Error message is sent.
+func MakeErrorMsgPayload(err error, fatal bool) *ErrorMsgPayload {
+	emp := &ErrorMsgPayload{
+		Error: err.Error(),
+		Fatal: fatal,
+	}
+
+	var ce *CodedError
+	if errors.As(err, &ce) {
+		emp.Code = uint32(ce.Code)
+		emp.Details = ce.Details
+	}
+
+	return emp
+}
+
+// ErrorFromPayload returns the CodedError reported in the Error message
+// payload
+func ErrorFromPayload(emp *ErrorMsgPayload) *CodedError {
+	return &CodedError{
+		Code:    ErrorCode(emp.Code),
+		Text:    emp.Error,
+		Details: emp.Details,
+		Fatal:   emp.Fatal,
+	}
+}
@@ -0,0 +1,98 @@
+package pusu
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestCodedError(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		err       error
+		expCode   ErrorCode
+		expIs     error
+		expCause  error
+		expText   string
+		expDetail string
+	}{
+		{
+			ID:      testhelper.MkID("coded error"),
+			err:     NewCodedError(ErrCodeTooBig, "too big: %d", 42),
+			expCode: ErrCodeTooBig,
+			expIs:   ErrTooBig,
+			expText: "too big: 42",
+		},
+		{
+			ID: testhelper.MkID("coded error wrapping a cause"),
+			err: NewCodedError(ErrCodeBadMessage,
+				"could not read: %w", io.ErrUnexpectedEOF),
+			expCode:  ErrCodeBadMessage,
+			expIs:    ErrBadMessage,
+			expCause: io.ErrUnexpectedEOF,
+			expText:  "could not read: unexpected EOF",
+		},
+		{
+			ID:        testhelper.MkID("wrapped topic error"),
+			err:       fmt.Errorf("subscribe: %w", Topic("bad").Check()),
+			expCode:   ErrCodeBadTopic,
+			expIs:     ErrBadTopic,
+			expText:   `subscribe: bad topic "bad" - it must start with a '/'`,
+			expDetail: "bad",
+		},
+		{
+			ID:      testhelper.MkID("unrecognised code"),
+			err:     NewCodedError(ErrCodeOverloaded+1, "from the future"),
+			expCode: ErrCodeOverloaded + 1,
+			expIs:   ErrUnknown,
+			expText: "from the future",
+		},
+		{
+			ID:      testhelper.MkID("plain error"),
+			err:     errors.New("plain"),
+			expCode: ErrCodeUnknown,
+			expText: "plain",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			testhelper.DiffString(t, tc.IDStr(), "error text",
+				tc.err.Error(), tc.expText)
+			testhelper.DiffInt(t, tc.IDStr(), "error code",
+				ErrorCodeOf(tc.err), tc.expCode)
+
+			if tc.expIs != nil && !errors.Is(tc.err, tc.expIs) {
+				t.Log(tc.IDStr())
+				t.Errorf("\t: the error should match: %q\n", tc.expIs)
+			}
+
+			if tc.expCause != nil && !errors.Is(tc.err, tc.expCause) {
+				t.Log(tc.IDStr())
+				t.Errorf("\t: the error should wrap: %q\n", tc.expCause)
+			}
+
+			emp := MakeErrorMsgPayload(tc.err, true)
+			ce := ErrorFromPayload(emp)
+
+			testhelper.DiffString(t, tc.IDStr(), "round-trip error text",
+				ce.Error(), tc.expText)
+			testhelper.DiffInt(t, tc.IDStr(), "round-trip error code",
+				ce.Code, tc.expCode)
+			testhelper.DiffString(t, tc.IDStr(), "round-trip topic detail",
+				ce.Details["topic"], tc.expDetail)
+			testhelper.DiffBool(t, tc.IDStr(), "round-trip fatal",
+				ce.Fatal, true)
+		})
+	}
+}
+
+func TestErrorCodeString(t *testing.T) {
+	testhelper.DiffString(t, "ErrorCode", "known code",
+		ErrCodeBadTopic.String(), "ErrCodeBadTopic")
+	testhelper.DiffString(t, "ErrorCode", "unknown code",
+		ErrorCode(99).String(), "ErrorCode(99)")
+}
@@ -3,5 +3,6 @@
 package pusu
 
 //go:generate stringer -type MsgType
+//go:generate stringer -type ErrorCode
 
 //go:generate protoc --go_out=. --go_opt=paths=source_relative pusu.proto
@@ -28,9 +28,11 @@ func (m *Message) Unmarshal(protoM proto.Message, logger *slog.Logger,
 	defer logNonNilErr(logger, "Unmarshalling failure")(err)
 
 	if len(m.Payload) == 0 {
-		err = fmt.Errorf("nothing to Unmarshal(...): MT: %s", m.MT)
+		err = NewCodedError(ErrCodeBadMessage,
+			"nothing to Unmarshal(...): MT: %s", m.MT)
 	} else if err = proto.Unmarshal(m.Payload, protoM); err != nil {
-		err = fmt.Errorf("could not Unmarshal(...): MT: %s: %w", m.MT, err)
+		err = NewCodedError(ErrCodeBadMessage,
+			"could not Unmarshal(...): MT: %s: %w", m.MT, err)
 	}
 
 	return err
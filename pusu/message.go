@@ -2,14 +2,26 @@ package pusu
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 )
 
 const (
-	// MaxMessagePayload is the maximum allowed size of the payload in a Message
+	// MaxMessagePayload is the maximum size of the payload that can be
+	// carried in a single frame on the connection. A Message having a larger
+	// payload is split into fragments, each carrying no more than this, and
+	// reassembled by ReadMsg. Note that fragmented messages are only
+	// understood from protocol version ProtoVsnFragments onwards.
 	MaxMessagePayload = math.MaxUint16
+	// DfltMaxPayload is the default maximum size of the (reassembled) payload
+	// of a Message read by ReadMsg. It guards against a peer exhausting the
+	// available memory.
+	DfltMaxPayload = 16 * 1024 * 1024
+	// moreFragments is set in the message type of every fragment of a
+	// Message except the last
+	moreFragments = MsgType(0x80)
 	// magicID is the introductory value at the start of every message. It is
 	// used to check for corrupted messages
 	magicID = uint32(0xB0AD1CEA)
@@ -27,7 +39,8 @@ type Message struct {
 }
 
 // msgHdr holds the common fixed part of a message, as written to or read
-// from the connection
+// from the connection. If the Message has been split into fragments each
+// fragment has its own header.
 type msgHdr struct {
 	Magic       uint32
 	MT          MsgType
@@ -42,25 +55,44 @@ func writeLE(w io.Writer, data any) error {
 }
 
 // Write will write the message to the writer. It will return an error if the
-// payload is greater than the MaxMessagePayload limit, if the message type
-// is invalid, or if any of the writes fail
+// message type is invalid or if any of the writes fail. If the payload is
+// greater than the MaxMessagePayload limit the message is written as a
+// sequence of fragments.
 func (m *Message) Write(w io.Writer) error {
-	if len(m.Payload) > MaxMessagePayload {
-		return fmt.Errorf(writeErrIntro+"bad payload - too big: %d (max: %d)",
-			len(m.Payload), MaxMessagePayload)
-	}
-
 	if err := m.MT.Check(); err != nil {
 		return fmt.Errorf(writeErrIntro+"%w", err)
 	}
 
-	hdr := msgHdr{
-		Magic:       magicID,
-		MT:          m.MT,
-		MsgID:       m.MsgID,
-		PayloadSize: uint16(len(m.Payload)), //nolint:gosec
+	payload := m.Payload
+
+	for {
+		frag := payload[:min(len(payload), MaxMessagePayload)]
+		payload = payload[len(frag):]
+
+		hdr := msgHdr{
+			Magic:       magicID,
+			MT:          m.MT,
+			MsgID:       m.MsgID,
+			PayloadSize: uint16(len(frag)), //nolint:gosec
+		}
+
+		if len(payload) > 0 {
+			hdr.MT |= moreFragments
+		}
+
+		if err := writeFrame(w, hdr, frag); err != nil {
+			return err
+		}
+
+		if len(payload) == 0 {
+			return nil
+		}
 	}
+}
 
+// writeFrame writes the header and the fragment of the payload to the
+// writer
+func writeFrame(w io.Writer, hdr msgHdr, frag []byte) error {
 	if err := writeLE(w, hdr); err != nil {
 		return fmt.Errorf(
 			writeErrIntro+"could not write the message header: %w", err)
@@ -70,7 +102,7 @@ func (m *Message) Write(w io.Writer) error {
 		return nil
 	}
 
-	if err := writeLE(w, m.Payload); err != nil {
+	if err := writeLE(w, frag); err != nil {
 		return fmt.Errorf(writeErrIntro+"could not write the payload: %w", err)
 	}
 
@@ -97,7 +129,7 @@ func readMsgHdr(r io.Reader) (msgHdr, error) {
 				magicID, hdr.Magic)
 	}
 
-	if err := hdr.MT.Check(); err != nil {
+	if err := (hdr.MT &^ moreFragments).Check(); err != nil {
 		return hdr,
 			fmt.Errorf(readErrIntro+"bad message type: %w", err)
 	}
@@ -121,23 +153,67 @@ func readMsgPayload(r io.Reader, buf []byte) error {
 // object from the reader. If any part of the reading returns an error this
 // will fail and the error returned will be non-nil; the returned message
 // will not be meaningful and the reader is no longer usable.
+//
+// The size of the payload is limited to DfltMaxPayload; use
+// ReadMsgWithLimit to set a different limit.
 func ReadMsg(r io.Reader) (Message, error) {
-	var hdr msgHdr
+	return ReadMsgWithLimit(r, DfltMaxPayload)
+}
 
+// ReadMsgWithLimit behaves as ReadMsg but the message is rejected (and an
+// error returned) if the size of its payload, after any fragments have been
+// reassembled, would exceed the maxPayload limit.
+func ReadMsgWithLimit(r io.Reader, maxPayload int) (Message, error) {
 	var msg Message
 
-	var err error
-	if hdr, err = readMsgHdr(r); err != nil {
-		return msg, err
+	for {
+		hdr, err := readMsgHdr(r)
+		if err != nil {
+			return msg, err
+		}
+
+		more := hdr.MT&moreFragments != 0
+		hdr.MT &^= moreFragments
+
+		if msg.MT == Invalid {
+			msg.MT = hdr.MT
+			msg.MsgID = hdr.MsgID
+		} else if hdr.MT != msg.MT || hdr.MsgID != msg.MsgID {
+			return msg,
+				fmt.Errorf(readErrIntro+
+					"bad message fragment: expected: %s (MsgID: %d),"+
+					" got: %s (MsgID: %d)",
+					msg.MT, msg.MsgID, hdr.MT, hdr.MsgID)
+		}
+
+		size := len(msg.Payload) + int(hdr.PayloadSize)
+		if size > maxPayload {
+			return msg,
+				fmt.Errorf(readErrIntro+
+					"bad payload - too big: at least %d bytes (max: %d)",
+					size, maxPayload)
+		}
+
+		if more && hdr.PayloadSize == 0 {
+			return msg,
+				errors.New(readErrIntro + "bad message fragment: empty")
+		}
+
+		if hdr.PayloadSize > 0 {
+			frag := make([]byte, hdr.PayloadSize)
+			if err = readMsgPayload(r, frag); err != nil {
+				return msg, err
+			}
+
+			if msg.Payload == nil {
+				msg.Payload = frag
+			} else {
+				msg.Payload = append(msg.Payload, frag...)
+			}
+		}
+
+		if !more {
+			return msg, nil
+		}
 	}
-
-	msg.MT = hdr.MT
-	msg.MsgID = hdr.MsgID
-
-	if hdr.PayloadSize > 0 {
-		msg.Payload = make([]byte, hdr.PayloadSize)
-		err = readMsgPayload(r, msg.Payload)
-	}
-
-	return msg, err
 }
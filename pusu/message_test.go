@@ -21,13 +21,24 @@ func TestMessageWrite(t *testing.T) {
 			},
 		},
 		{
-			ID: testhelper.MkID("bad Write - payload too big"),
-			ExpErr: testhelper.MkExpErr(
-				writeErrIntro,
-				"bad payload - too big: 65536 (max: 65535)"),
+			ID: testhelper.MkID("good Write - maximum unfragmented payload"),
 			msg: Message{
-				MT:      Start,
-				Payload: make([]byte, MaxMessagePayload+1),
+				MT:      Publish,
+				Payload: bytes.Repeat([]byte("a"), MaxMessagePayload),
+			},
+		},
+		{
+			ID: testhelper.MkID("good Write - 2 fragments"),
+			msg: Message{
+				MT:      Publish,
+				Payload: bytes.Repeat([]byte("ab"), MaxMessagePayload/2+1),
+			},
+		},
+		{
+			ID: testhelper.MkID("good Write - 3 fragments, last full"),
+			msg: Message{
+				MT:      Publish,
+				Payload: bytes.Repeat([]byte("abc"), MaxMessagePayload),
 			},
 		},
 		{
@@ -156,6 +167,51 @@ func TestMessageRead(t *testing.T) {
 			},
 		})
 
+	fragmentedMsgBuf := &bytes.Buffer{}
+	fragmentedMsg := &Message{
+		MT:      Publish,
+		MsgID:   42,
+		Payload: make([]byte, MaxMessagePayload+1),
+	}
+
+	if err := fragmentedMsg.Write(fragmentedMsgBuf); err != nil {
+		t.Fatal("couldn't write the fragmented message to buffer:", err)
+	}
+
+	badFragmentBuf := makeBadBuf(t,
+		testMsgPart{
+			name: "first fragment",
+			data: msgHdr{
+				Magic:       magicID,
+				MT:          Publish | moreFragments,
+				MsgID:       42,
+				PayloadSize: 1,
+			},
+		},
+		testMsgPart{name: "first fragment payload", data: []byte("a")},
+		testMsgPart{
+			name: "bad fragment - wrong MsgID",
+			data: msgHdr{
+				Magic:       magicID,
+				MT:          Publish,
+				MsgID:       43,
+				PayloadSize: 1,
+			},
+		},
+		testMsgPart{name: "bad fragment payload", data: []byte("b")},
+	)
+
+	emptyFragmentBuf := makeBadBuf(t,
+		testMsgPart{
+			name: "empty fragment",
+			data: msgHdr{
+				Magic:       magicID,
+				MT:          Publish | moreFragments,
+				MsgID:       42,
+				PayloadSize: 0,
+			},
+		})
+
 	missingPayloadBuf := makeBadBuf(t,
 		testMsgPart{
 			name: "bad header - non-zero payload size, no payload",
@@ -209,6 +265,25 @@ func TestMessageRead(t *testing.T) {
 			ID:      testhelper.MkID("good message"),
 			readBuf: goodMsgBuf,
 		},
+		{
+			ID:      testhelper.MkID("good message - fragmented"),
+			readBuf: fragmentedMsgBuf,
+		},
+		{
+			ID: testhelper.MkID("bad message - mismatched fragment"),
+			ExpErr: testhelper.MkExpErr(
+				readErrIntro,
+				"bad message fragment: expected: Publish (MsgID: 42),"+
+					" got: Publish (MsgID: 43)"),
+			readBuf: badFragmentBuf,
+		},
+		{
+			ID: testhelper.MkID("bad message - empty fragment"),
+			ExpErr: testhelper.MkExpErr(
+				readErrIntro,
+				"bad message fragment: empty"),
+			readBuf: emptyFragmentBuf,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -222,3 +297,59 @@ func TestMessageRead(t *testing.T) {
 		})
 	}
 }
+
+func TestMessageReadWithLimit(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		payloadSize int
+		maxPayload  int
+	}{
+		{
+			ID:          testhelper.MkID("small payload, at the limit"),
+			payloadSize: 10,
+			maxPayload:  10,
+		},
+		{
+			ID: testhelper.MkID("small payload, over the limit"),
+			ExpErr: testhelper.MkExpErr(
+				readErrIntro,
+				"bad payload - too big: at least 11 bytes (max: 10)"),
+			payloadSize: 11,
+			maxPayload:  10,
+		},
+		{
+			ID:          testhelper.MkID("fragmented payload, at the limit"),
+			payloadSize: 3 * MaxMessagePayload,
+			maxPayload:  3 * MaxMessagePayload,
+		},
+		{
+			ID: testhelper.MkID("fragmented payload, over the limit"),
+			ExpErr: testhelper.MkExpErr(
+				readErrIntro,
+				"bad payload - too big: at least 131070 bytes (max: 100000)"),
+			payloadSize: 3 * MaxMessagePayload,
+			maxPayload:  100000,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var b bytes.Buffer
+
+			msg := Message{
+				MT:      Publish,
+				Payload: make([]byte, tc.payloadSize),
+			}
+			if err := msg.Write(&b); err != nil {
+				t.Fatal("couldn't write the message:", err)
+			}
+
+			readMsg, err := ReadMsgWithLimit(&b, tc.maxPayload)
+			if testhelper.CheckExpErr(t, err, tc) && err == nil {
+				testhelper.DiffInt(t, tc.IDStr(), "payload size",
+					len(readMsg.Payload), tc.payloadSize)
+			}
+		})
+	}
+}
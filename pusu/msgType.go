@@ -26,7 +26,9 @@ const (
 	// Ping is a test-of-life/proof-of-life message
 	Ping
 	// Error is a message from the server indicating that some error has
-	// occurred. The client will disconnect if this message is received.
+	// occurred. From protocol version ProtoVsnNonFatalErrors the connection
+	// is only closed if the error is marked as fatal, otherwise the message
+	// in error is rejected and the connection is kept open.
 	Error
 	// Ack is a message from the server to acknowledge that a message has
 	// been received and processed. Every message (except Pings) sent to the
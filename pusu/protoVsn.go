@@ -6,7 +6,22 @@ import "log/slog"
 // package. It is passed in the Start message to let the server know what
 // protocol to expect. A server may choose to support more than the latest
 // protocol version.
-const CurrentProtoVsn = 1
+const CurrentProtoVsn = 4
+
+// ProtoVsnFragments is the first protocol version in which messages with
+// payloads larger than MaxMessagePayload can be sent, split into fragments.
+const ProtoVsnFragments = 2
+
+// ProtoVsnNonFatalErrors is the first protocol version in which the server
+// keeps the connection open after rejecting a message, unless the Error
+// message is marked as fatal.
+const ProtoVsnNonFatalErrors = 3
+
+// ProtoVsnRetainedValues is the first protocol version in which the server
+// marks the retained values sent after a Subscribe message with the
+// subscriptions they are for and tells the subscribers when a retained
+// value is cleared.
+const ProtoVsnRetainedValues = 4
 
 // The ProtoVsn records the version of the pub/sub protocol being used
 type ProtoVsn int32
@@ -15,3 +30,30 @@ type ProtoVsn int32
 func (pv ProtoVsn) Attr() slog.Attr {
 	return slog.Int(AttrPfx+"ProtoVsn", int(pv))
 }
+
+// Check returns a non-nil error if the protocol version is not one that is
+// implemented by this package.
+func (pv ProtoVsn) Check() error {
+	if pv < 1 {
+		return NewCodedError(ErrCodeProtocol,
+			"bad protocol version: %d - too small", pv)
+	}
+
+	if pv > CurrentProtoVsn {
+		return NewCodedError(ErrCodeProtocol,
+			"bad protocol version: %d - too big (max: %d)",
+			pv, CurrentProtoVsn)
+	}
+
+	return nil
+}
+
+// Negotiate returns the protocol version to be used with a peer offering
+// this version. This is the lower of the offered version and
+// CurrentProtoVsn. It returns a non-nil error if the resulting version is
+// not one that is implemented by this package.
+func (pv ProtoVsn) Negotiate() (ProtoVsn, error) {
+	npv := min(pv, CurrentProtoVsn)
+
+	return npv, npv.Check()
+}
@@ -0,0 +1,48 @@
+package pusu
+
+import (
+	"testing"
+
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestProtoVsnNegotiate(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		offered ProtoVsn
+		expPV   ProtoVsn
+	}{
+		{
+			ID:      testhelper.MkID("oldest version"),
+			offered: 1,
+			expPV:   1,
+		},
+		{
+			ID:      testhelper.MkID("current version"),
+			offered: CurrentProtoVsn,
+			expPV:   CurrentProtoVsn,
+		},
+		{
+			ID:      testhelper.MkID("newer version"),
+			offered: CurrentProtoVsn + 1,
+			expPV:   CurrentProtoVsn,
+		},
+		{
+			ID: testhelper.MkID("bad version"),
+			ExpErr: testhelper.MkExpErr(
+				"bad protocol version: 0 - too small"),
+			offered: 0,
+			expPV:   0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			pv, err := tc.offered.Negotiate()
+			testhelper.CheckExpErr(t, err, tc)
+			testhelper.DiffInt(t, tc.IDStr(), "negotiated version",
+				pv, tc.expPV)
+		})
+	}
+}
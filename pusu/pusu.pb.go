@@ -88,6 +88,74 @@ func (x *StartMsgPayload) GetNamespace() string {
 	return ""
 }
 
+// StartAckMsgPayload is the payload of the Ack message sent by the pub/sub
+// server in reply to the Start message. It describes the server and the
+// features it will support on the connection.
+type StartAckMsgPayload struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the protocol version to be used on the connection. This is the lower
+	// of the version given in the Start message and the latest version the
+	// server supports
+	ProtocolVersion int32 `protobuf:"varint,1,opt,name=protocolVersion,proto3" json:"protocolVersion,omitempty"`
+	// the serverId provides some identifying text for the server
+	ServerId string `protobuf:"bytes,2,opt,name=serverId,proto3" json:"serverId,omitempty"`
+	// the optional features supported by the server on the connection
+	Capabilities  []string `protobuf:"bytes,3,rep,name=capabilities,proto3" json:"capabilities,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StartAckMsgPayload) Reset() {
+	*x = StartAckMsgPayload{}
+	mi := &file_pusu_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StartAckMsgPayload) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartAckMsgPayload) ProtoMessage() {}
+
+func (x *StartAckMsgPayload) ProtoReflect() protoreflect.Message {
+	mi := &file_pusu_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartAckMsgPayload.ProtoReflect.Descriptor instead.
+func (*StartAckMsgPayload) Descriptor() ([]byte, []int) {
+	return file_pusu_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *StartAckMsgPayload) GetProtocolVersion() int32 {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return 0
+}
+
+func (x *StartAckMsgPayload) GetServerId() string {
+	if x != nil {
+		return x.ServerId
+	}
+	return ""
+}
+
+func (x *StartAckMsgPayload) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
 // SubscriptionMsgPayload is the message used to send subscriptions and
 // unsubscriptions to the pub/sub server.
 type SubscriptionMsgPayload struct {
@@ -100,7 +168,7 @@ type SubscriptionMsgPayload struct {
 
 func (x *SubscriptionMsgPayload) Reset() {
 	*x = SubscriptionMsgPayload{}
-	mi := &file_pusu_proto_msgTypes[1]
+	mi := &file_pusu_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -112,7 +180,7 @@ func (x *SubscriptionMsgPayload) String() string {
 func (*SubscriptionMsgPayload) ProtoMessage() {}
 
 func (x *SubscriptionMsgPayload) ProtoReflect() protoreflect.Message {
-	mi := &file_pusu_proto_msgTypes[1]
+	mi := &file_pusu_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -125,7 +193,7 @@ func (x *SubscriptionMsgPayload) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SubscriptionMsgPayload.ProtoReflect.Descriptor instead.
 func (*SubscriptionMsgPayload) Descriptor() ([]byte, []int) {
-	return file_pusu_proto_rawDescGZIP(), []int{1}
+	return file_pusu_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *SubscriptionMsgPayload) GetSubs() []*SubscriptionMsgPayload_Sub {
@@ -141,14 +209,40 @@ type PublishMsgPayload struct {
 	// the topic on which to publish
 	Topic string `protobuf:"bytes,1,opt,name=topic,proto3" json:"topic,omitempty"`
 	// the data being published
-	Payload       []byte `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	Payload []byte `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	// if retain is set the server keeps the payload as the last value for
+	// the topic in the namespace and sends it to any client subsequently
+	// subscribing to the topic
+	Retain bool `protobuf:"varint,3,opt,name=retain,proto3" json:"retain,omitempty"`
+	// if clearRetained is set the server discards any value retained for the
+	// topic in the namespace. The payload must be empty and the message is
+	// only sent to those subscribers using protocol version 4 or later, to
+	// let them know that the retained value has gone.
+	ClearRetained bool `protobuf:"varint,4,opt,name=clearRetained,proto3" json:"clearRetained,omitempty"`
+	// the headers give metadata describing the payload, such as its content
+	// type or a correlation ID
+	Headers map[string]string `protobuf:"bytes,5,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// the time the message was published
+	PublishTime *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=publishTime,proto3" json:"publishTime,omitempty"`
+	// the clientId of the publisher. This is set by the server from the
+	// clientId in the publisher's Start message, any value given by the
+	// publisher is replaced.
+	PublisherId string `protobuf:"bytes,7,opt,name=publisherId,proto3" json:"publisherId,omitempty"`
+	// retained is set by the server on the Publish messages giving the
+	// values retained for the topics in a Subscribe message, it is not set
+	// on new publications. Any value given by the publisher is discarded.
+	Retained bool `protobuf:"varint,8,opt,name=retained,proto3" json:"retained,omitempty"`
+	// subscriptions gives, for a retained value, the topics in the Subscribe
+	// message which it matches. The value is only for the handlers of these
+	// topics.
+	Subscriptions []string `protobuf:"bytes,9,rep,name=subscriptions,proto3" json:"subscriptions,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *PublishMsgPayload) Reset() {
 	*x = PublishMsgPayload{}
-	mi := &file_pusu_proto_msgTypes[2]
+	mi := &file_pusu_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -160,7 +254,7 @@ func (x *PublishMsgPayload) String() string {
 func (*PublishMsgPayload) ProtoMessage() {}
 
 func (x *PublishMsgPayload) ProtoReflect() protoreflect.Message {
-	mi := &file_pusu_proto_msgTypes[2]
+	mi := &file_pusu_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -173,7 +267,7 @@ func (x *PublishMsgPayload) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PublishMsgPayload.ProtoReflect.Descriptor instead.
 func (*PublishMsgPayload) Descriptor() ([]byte, []int) {
-	return file_pusu_proto_rawDescGZIP(), []int{2}
+	return file_pusu_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *PublishMsgPayload) GetTopic() string {
@@ -190,19 +284,79 @@ func (x *PublishMsgPayload) GetPayload() []byte {
 	return nil
 }
 
+func (x *PublishMsgPayload) GetRetain() bool {
+	if x != nil {
+		return x.Retain
+	}
+	return false
+}
+
+func (x *PublishMsgPayload) GetClearRetained() bool {
+	if x != nil {
+		return x.ClearRetained
+	}
+	return false
+}
+
+func (x *PublishMsgPayload) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *PublishMsgPayload) GetPublishTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.PublishTime
+	}
+	return nil
+}
+
+func (x *PublishMsgPayload) GetPublisherId() string {
+	if x != nil {
+		return x.PublisherId
+	}
+	return ""
+}
+
+func (x *PublishMsgPayload) GetRetained() bool {
+	if x != nil {
+		return x.Retained
+	}
+	return false
+}
+
+func (x *PublishMsgPayload) GetSubscriptions() []string {
+	if x != nil {
+		return x.Subscriptions
+	}
+	return nil
+}
+
 // ErrorMsgPayload is the message send by the server to indicate an error
 // with the message
 type ErrorMsgPayload struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// the error text describes the problem with the message
-	Error         string `protobuf:"bytes,1,opt,name=error,proto3" json:"error,omitempty"`
+	Error string `protobuf:"bytes,1,opt,name=error,proto3" json:"error,omitempty"`
+	// the code classifies the error, see the ErrorCode type for the values.
+	// A zero value means that the error is unclassified
+	Code uint32 `protobuf:"varint,2,opt,name=code,proto3" json:"code,omitempty"`
+	// the details give any further information about the error, such as the
+	// topic or namespace involved
+	Details map[string]string `protobuf:"bytes,3,rep,name=details,proto3" json:"details,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// if fatal is set the server will close the connection after sending
+	// the Error. Otherwise the error relates only to the message with the
+	// MsgID of the Error and the connection can still be used. Before
+	// protocol version 3 every error is fatal.
+	Fatal         bool `protobuf:"varint,4,opt,name=fatal,proto3" json:"fatal,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *ErrorMsgPayload) Reset() {
 	*x = ErrorMsgPayload{}
-	mi := &file_pusu_proto_msgTypes[3]
+	mi := &file_pusu_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -214,7 +368,7 @@ func (x *ErrorMsgPayload) String() string {
 func (*ErrorMsgPayload) ProtoMessage() {}
 
 func (x *ErrorMsgPayload) ProtoReflect() protoreflect.Message {
-	mi := &file_pusu_proto_msgTypes[3]
+	mi := &file_pusu_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -227,7 +381,7 @@ func (x *ErrorMsgPayload) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ErrorMsgPayload.ProtoReflect.Descriptor instead.
 func (*ErrorMsgPayload) Descriptor() ([]byte, []int) {
-	return file_pusu_proto_rawDescGZIP(), []int{3}
+	return file_pusu_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *ErrorMsgPayload) GetError() string {
@@ -237,6 +391,27 @@ func (x *ErrorMsgPayload) GetError() string {
 	return ""
 }
 
+func (x *ErrorMsgPayload) GetCode() uint32 {
+	if x != nil {
+		return x.Code
+	}
+	return 0
+}
+
+func (x *ErrorMsgPayload) GetDetails() map[string]string {
+	if x != nil {
+		return x.Details
+	}
+	return nil
+}
+
+func (x *ErrorMsgPayload) GetFatal() bool {
+	if x != nil {
+		return x.Fatal
+	}
+	return false
+}
+
 // PingMsgPayload is the message used to request a Ping response from the
 // server. It is returned, unchanged, to the client. It is not acknowledged
 // by the server.
@@ -250,7 +425,7 @@ type PingMsgPayload struct {
 
 func (x *PingMsgPayload) Reset() {
 	*x = PingMsgPayload{}
-	mi := &file_pusu_proto_msgTypes[4]
+	mi := &file_pusu_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -262,7 +437,7 @@ func (x *PingMsgPayload) String() string {
 func (*PingMsgPayload) ProtoMessage() {}
 
 func (x *PingMsgPayload) ProtoReflect() protoreflect.Message {
-	mi := &file_pusu_proto_msgTypes[4]
+	mi := &file_pusu_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -275,7 +450,7 @@ func (x *PingMsgPayload) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PingMsgPayload.ProtoReflect.Descriptor instead.
 func (*PingMsgPayload) Descriptor() ([]byte, []int) {
-	return file_pusu_proto_rawDescGZIP(), []int{4}
+	return file_pusu_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *PingMsgPayload) GetPingTime() *timestamppb.Timestamp {
@@ -295,7 +470,7 @@ type SubscriptionMsgPayload_Sub struct {
 
 func (x *SubscriptionMsgPayload_Sub) Reset() {
 	*x = SubscriptionMsgPayload_Sub{}
-	mi := &file_pusu_proto_msgTypes[5]
+	mi := &file_pusu_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -307,7 +482,7 @@ func (x *SubscriptionMsgPayload_Sub) String() string {
 func (*SubscriptionMsgPayload_Sub) ProtoMessage() {}
 
 func (x *SubscriptionMsgPayload_Sub) ProtoReflect() protoreflect.Message {
-	mi := &file_pusu_proto_msgTypes[5]
+	mi := &file_pusu_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -320,7 +495,7 @@ func (x *SubscriptionMsgPayload_Sub) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SubscriptionMsgPayload_Sub.ProtoReflect.Descriptor instead.
 func (*SubscriptionMsgPayload_Sub) Descriptor() ([]byte, []int) {
-	return file_pusu_proto_rawDescGZIP(), []int{1, 0}
+	return file_pusu_proto_rawDescGZIP(), []int{2, 0}
 }
 
 func (x *SubscriptionMsgPayload_Sub) GetTopic() string {
@@ -339,16 +514,36 @@ const file_pusu_proto_rawDesc = "" +
 	"\x0fStartMsgPayload\x12(\n" +
 	"\x0fprotocolVersion\x18\x01 \x01(\x05R\x0fprotocolVersion\x12\x1a\n" +
 	"\bclientId\x18\x02 \x01(\tR\bclientId\x12\x1c\n" +
-	"\tnamespace\x18\x03 \x01(\tR\tnamespace\"k\n" +
+	"\tnamespace\x18\x03 \x01(\tR\tnamespace\"~\n" +
+	"\x12StartAckMsgPayload\x12(\n" +
+	"\x0fprotocolVersion\x18\x01 \x01(\x05R\x0fprotocolVersion\x12\x1a\n" +
+	"\bserverId\x18\x02 \x01(\tR\bserverId\x12\"\n" +
+	"\fcapabilities\x18\x03 \x03(\tR\fcapabilities\"k\n" +
 	"\x16SubscriptionMsgPayload\x124\n" +
 	"\x04subs\x18\x01 \x03(\v2 .pusu.SubscriptionMsgPayload.SubR\x04subs\x1a\x1b\n" +
 	"\x03Sub\x12\x14\n" +
-	"\x05topic\x18\x01 \x01(\tR\x05topic\"C\n" +
+	"\x05topic\x18\x01 \x01(\tR\x05topic\"\x9f\x03\n" +
 	"\x11PublishMsgPayload\x12\x14\n" +
 	"\x05topic\x18\x01 \x01(\tR\x05topic\x12\x18\n" +
-	"\apayload\x18\x02 \x01(\fR\apayload\"'\n" +
+	"\apayload\x18\x02 \x01(\fR\apayload\x12\x16\n" +
+	"\x06retain\x18\x03 \x01(\bR\x06retain\x12$\n" +
+	"\rclearRetained\x18\x04 \x01(\bR\rclearRetained\x12>\n" +
+	"\aheaders\x18\x05 \x03(\v2$.pusu.PublishMsgPayload.HeadersEntryR\aheaders\x12<\n" +
+	"\vpublishTime\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\vpublishTime\x12 \n" +
+	"\vpublisherId\x18\a \x01(\tR\vpublisherId\x12\x1a\n" +
+	"\bretained\x18\b \x01(\bR\bretained\x12$\n" +
+	"\rsubscriptions\x18\t \x03(\tR\rsubscriptions\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xcb\x01\n" +
 	"\x0fErrorMsgPayload\x12\x14\n" +
-	"\x05error\x18\x01 \x01(\tR\x05error\"H\n" +
+	"\x05error\x18\x01 \x01(\tR\x05error\x12\x12\n" +
+	"\x04code\x18\x02 \x01(\rR\x04code\x12<\n" +
+	"\adetails\x18\x03 \x03(\v2\".pusu.ErrorMsgPayload.DetailsEntryR\adetails\x12\x14\n" +
+	"\x05fatal\x18\x04 \x01(\bR\x05fatal\x1a:\n" +
+	"\fDetailsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"H\n" +
 	"\x0ePingMsgPayload\x126\n" +
 	"\bpingTime\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\bpingTimeB$Z\"github.com/nickwells/pusu.mod/pusub\x06proto3"
 
@@ -364,24 +559,30 @@ func file_pusu_proto_rawDescGZIP() []byte {
 	return file_pusu_proto_rawDescData
 }
 
-var file_pusu_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_pusu_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_pusu_proto_goTypes = []any{
 	(*StartMsgPayload)(nil),            // 0: pusu.StartMsgPayload
-	(*SubscriptionMsgPayload)(nil),     // 1: pusu.SubscriptionMsgPayload
-	(*PublishMsgPayload)(nil),          // 2: pusu.PublishMsgPayload
-	(*ErrorMsgPayload)(nil),            // 3: pusu.ErrorMsgPayload
-	(*PingMsgPayload)(nil),             // 4: pusu.PingMsgPayload
-	(*SubscriptionMsgPayload_Sub)(nil), // 5: pusu.SubscriptionMsgPayload.Sub
-	(*timestamppb.Timestamp)(nil),      // 6: google.protobuf.Timestamp
+	(*StartAckMsgPayload)(nil),         // 1: pusu.StartAckMsgPayload
+	(*SubscriptionMsgPayload)(nil),     // 2: pusu.SubscriptionMsgPayload
+	(*PublishMsgPayload)(nil),          // 3: pusu.PublishMsgPayload
+	(*ErrorMsgPayload)(nil),            // 4: pusu.ErrorMsgPayload
+	(*PingMsgPayload)(nil),             // 5: pusu.PingMsgPayload
+	(*SubscriptionMsgPayload_Sub)(nil), // 6: pusu.SubscriptionMsgPayload.Sub
+	nil,                                // 7: pusu.PublishMsgPayload.HeadersEntry
+	nil,                                // 8: pusu.ErrorMsgPayload.DetailsEntry
+	(*timestamppb.Timestamp)(nil),      // 9: google.protobuf.Timestamp
 }
 var file_pusu_proto_depIdxs = []int32{
-	5, // 0: pusu.SubscriptionMsgPayload.subs:type_name -> pusu.SubscriptionMsgPayload.Sub
-	6, // 1: pusu.PingMsgPayload.pingTime:type_name -> google.protobuf.Timestamp
-	2, // [2:2] is the sub-list for method output_type
-	2, // [2:2] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	6, // 0: pusu.SubscriptionMsgPayload.subs:type_name -> pusu.SubscriptionMsgPayload.Sub
+	7, // 1: pusu.PublishMsgPayload.headers:type_name -> pusu.PublishMsgPayload.HeadersEntry
+	9, // 2: pusu.PublishMsgPayload.publishTime:type_name -> google.protobuf.Timestamp
+	8, // 3: pusu.ErrorMsgPayload.details:type_name -> pusu.ErrorMsgPayload.DetailsEntry
+	9, // 4: pusu.PingMsgPayload.pingTime:type_name -> google.protobuf.Timestamp
+	5, // [5:5] is the sub-list for method output_type
+	5, // [5:5] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_pusu_proto_init() }
@@ -395,7 +596,7 @@ func file_pusu_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pusu_proto_rawDesc), len(file_pusu_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
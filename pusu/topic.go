@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log/slog"
 	"path"
+	"slices"
+	"strings"
 )
 
 // NoteTextTopic provides a narrative description of what a Topic is.
@@ -18,13 +20,37 @@ const NoteTextTopic = "A publish/subscribe topic provides routing information" +
 	"\n\n" +
 	"A topic name must be a well-formed path, starting with a '/' and" +
 	" having one or more parts following this, each part separated from its" +
-	" predecessor by a single '/'."
+	" predecessor by a single '/'." +
+	"\n\n" +
+	"A topic used in a subscription may contain wildcard parts. A part" +
+	" consisting of just '" + TopicWildcard + "' matches any single part" +
+	" so '/a/" + TopicWildcard + "/c' will receive messages published on" +
+	" '/a/b/c' or '/a/x/c' but not on '/a/c' or '/a/b/x/c'. A final part" +
+	" consisting of just '" + TopicPrefixWildcard + "' matches the topic" +
+	" before it and any topic below that so '/a/" + TopicPrefixWildcard +
+	"' will receive messages published on '/a', '/a/b' or '/a/b/c'." +
+	" Messages cannot be published on a topic containing wildcards."
+
+const (
+	// TopicWildcard is a topic part which matches any single part of a
+	// published topic.
+	TopicWildcard = "*"
+	// TopicPrefixWildcard is a topic part which matches any number of
+	// parts, including none, at the end of a published topic. It may only
+	// be used as the last part of a topic.
+	TopicPrefixWildcard = "**"
+)
 
 // Topic represents a pub/sub topic. Clients subscribe to topics and publish
 // messages on the topics and the pub/sub server distributes those messages
 // to any clients subscribed to the topic. Note that a valid topic must be a
 // 'Clean', 'Absolute' path, for instance '/a/b/c' is valid but '//a' is not,
 // nor is 'a/b'; see the [path] package for details.
+//
+// A topic used in a subscription may be a pattern containing wildcard
+// parts (see TopicWildcard and TopicPrefixWildcard) in which case the
+// subscriber will receive the messages published on any topic matching the
+// pattern.
 type Topic string
 
 // Attr creates a standard slog Attr representing the topic
@@ -45,9 +71,78 @@ func (t Topic) Check() error {
 		return t.stdErr(fmt.Sprintf("unclean - replace with %q", cleanTopic))
 	}
 
+	parts := t.parts()
+	if i := slices.Index(parts, TopicPrefixWildcard); i >= 0 &&
+		i != len(parts)-1 {
+		return t.stdErr(fmt.Sprintf("%q may only be the last part",
+			TopicPrefixWildcard))
+	}
+
 	return nil
 }
 
+// CheckConcrete returns a non-nil error if the topic is invalid or if it is
+// a pattern. Messages can only be published on a concrete topic.
+func (t Topic) CheckConcrete() error {
+	if err := t.Check(); err != nil {
+		return err
+	}
+
+	if t.IsPattern() {
+		return t.stdErr("messages cannot be published on a wildcard topic")
+	}
+
+	return nil
+}
+
+// parts returns the parts of the topic. The root topic, '/', has no parts.
+func (t Topic) parts() []string {
+	s := strings.TrimPrefix(string(t), "/")
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, "/")
+}
+
+// IsPattern returns true if any part of the topic is a wildcard
+func (t Topic) IsPattern() bool {
+	for _, p := range t.parts() {
+		if p == TopicWildcard || p == TopicPrefixWildcard {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Matches returns true if the concrete topic, ct, matches the topic. If the
+// topic is not a pattern it only matches an identical topic.
+func (t Topic) Matches(ct Topic) bool {
+	if t == ct {
+		return true
+	}
+
+	tParts := t.parts()
+	ctParts := ct.parts()
+
+	for i, p := range tParts {
+		if p == TopicPrefixWildcard {
+			return true
+		}
+
+		if i >= len(ctParts) {
+			return false
+		}
+
+		if p != TopicWildcard && p != ctParts[i] {
+			return false
+		}
+	}
+
+	return len(tParts) == len(ctParts)
+}
+
 // SubTopics progressively strips the last part of the topic path and returns
 // a slice of the resultant topics. So if you pass it a topic '/a/b/c' it
 // will return a slice: [ '/a/b/c', '/a/b', '/a', '/' ].
@@ -75,5 +170,6 @@ TopicLoop:
 
 // stdErr returns a standardised error representing a problem with the topic
 func (t Topic) stdErr(text string) error {
-	return fmt.Errorf("bad topic %q - %s", t, text)
+	return NewCodedError(ErrCodeBadTopic, "bad topic %q - %s", t, text).
+		WithDetail("topic", string(t))
 }
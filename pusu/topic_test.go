@@ -38,6 +38,18 @@ func TestTopic(t *testing.T) {
 			topic:         "/a/b/c",
 			expectedSlice: []Topic{"/a/b/c", "/a/b", "/a", "/"},
 		},
+		{
+			ID:            testhelper.MkID("wildcard topic"),
+			topic:         "/a/*/**",
+			expectedSlice: []Topic{"/a/*/**", "/a/*", "/a", "/"},
+		},
+		{
+			ID: testhelper.MkID("bad wildcard topic"),
+			ExpErr: testhelper.MkExpErr(`bad topic "/a/**/c" - `,
+				`"**" may only be the last part`),
+			topic:         "/a/**/c",
+			expectedSlice: []Topic{"/a/**/c"},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -53,6 +65,89 @@ func TestTopic(t *testing.T) {
 	}
 }
 
+func TestTopicMatches(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		pattern    Topic
+		expPattern bool
+		matches    []Topic
+		nonMatches []Topic
+	}{
+		{
+			ID:         testhelper.MkID("plain topic"),
+			pattern:    "/a/b",
+			matches:    []Topic{"/a/b"},
+			nonMatches: []Topic{"/", "/a", "/a/b/c", "/a/c"},
+		},
+		{
+			ID:         testhelper.MkID("root topic"),
+			pattern:    "/",
+			matches:    []Topic{"/"},
+			nonMatches: []Topic{"/a"},
+		},
+		{
+			ID: testhelper.MkID("single-level wildcard"),
+			ExpErr: testhelper.MkExpErr(`bad topic "/a/*/c" - `,
+				"messages cannot be published on a wildcard topic"),
+			pattern:    "/a/*/c",
+			expPattern: true,
+			matches:    []Topic{"/a/b/c", "/a/x/c"},
+			nonMatches: []Topic{"/a/c", "/a/b", "/a/b/x/c", "/a/b/c/d"},
+		},
+		{
+			ID: testhelper.MkID("prefix wildcard"),
+			ExpErr: testhelper.MkExpErr(`bad topic "/a/**" - `,
+				"messages cannot be published on a wildcard topic"),
+			pattern:    "/a/**",
+			expPattern: true,
+			matches:    []Topic{"/a", "/a/b", "/a/b/c"},
+			nonMatches: []Topic{"/", "/b", "/ab"},
+		},
+		{
+			ID: testhelper.MkID("root prefix wildcard"),
+			ExpErr: testhelper.MkExpErr(`bad topic "/**" - `,
+				"messages cannot be published on a wildcard topic"),
+			pattern:    "/**",
+			expPattern: true,
+			matches:    []Topic{"/", "/a", "/a/b/c"},
+		},
+		{
+			ID: testhelper.MkID("both wildcards"),
+			ExpErr: testhelper.MkExpErr(`bad topic "/*/b/**" - `,
+				"messages cannot be published on a wildcard topic"),
+			pattern:    "/*/b/**",
+			expPattern: true,
+			matches:    []Topic{"/a/b", "/x/b/c/d"},
+			nonMatches: []Topic{"/a", "/a/c", "/b"},
+		},
+		{
+			ID:         testhelper.MkID("wildcard characters within a part"),
+			pattern:    "/a*/b**",
+			matches:    []Topic{"/a*/b**"},
+			nonMatches: []Topic{"/ab/bc"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			testhelper.DiffBool(t, tc.IDStr(), "is pattern",
+				tc.pattern.IsPattern(), tc.expPattern)
+			testhelper.CheckExpErr(t, tc.pattern.CheckConcrete(), tc)
+
+			for _, ct := range tc.matches {
+				testhelper.DiffBool(t, tc.IDStr(), "matches "+string(ct),
+					tc.pattern.Matches(ct), true)
+			}
+
+			for _, ct := range tc.nonMatches {
+				testhelper.DiffBool(t, tc.IDStr(), "matches "+string(ct),
+					tc.pattern.Matches(ct), false)
+			}
+		})
+	}
+}
+
 func TestTopicAttr(t *testing.T) {
 	testCases := []struct {
 		testhelper.ID
@@ -0,0 +1,70 @@
+package pusuclt
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+// minSweepInterval is the shortest time between checks for overdue replies
+const minSweepInterval = 10 * time.Millisecond
+
+var (
+	// ErrAckTimeout is passed to a Callback if the reply to its message
+	// has not arrived within the ConnInfo AckTimeout
+	ErrAckTimeout = errors.New("no reply from the pub/sub server in time")
+	// ErrConnLost is passed to any Callbacks still waiting for a reply when
+	// the connection to the pub/sub server is lost
+	ErrConnLost = errors.New("the connection to the pub/sub server was lost")
+)
+
+// pendingCallback records a Callback waiting for the reply to its message
+// and the time by which the reply must arrive. The deadline is zero if
+// there is no AckTimeout.
+type pendingCallback struct {
+	cb       Callback
+	deadline time.Time
+}
+
+// sweepInterval returns the time between checks for overdue replies. It is
+// zero if there is no AckTimeout.
+func (ci *ConnInfo) sweepInterval() time.Duration {
+	if ci.AckTimeout <= 0 {
+		return 0
+	}
+
+	return max(ci.AckTimeout/2, minSweepInterval)
+}
+
+// sweepCallbacks calls each Callback whose reply is overdue with
+// ErrAckTimeout. A reply arriving later is ignored.
+func (c *Client) sweepCallbacks(now time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for id, pc := range c.callbacks {
+		if pc.deadline.IsZero() || now.Before(pc.deadline) {
+			continue
+		}
+
+		c.log().Error("the reply to the message is overdue",
+			id.Attr(),
+			slog.Time(pusu.AttrPfx+"Deadline", pc.deadline))
+
+		if cb := c.getCallback(id); cb != nil {
+			go cb(ErrAckTimeout)
+		}
+	}
+}
+
+// failCallbacks calls every Callback still waiting for a reply with the
+// error. The Client mutex must be held when this is called.
+func (c *Client) failCallbacks(err error) {
+	for id := range c.callbacks {
+		if cb := c.getCallback(id); cb != nil {
+			go cb(err)
+		}
+	}
+}
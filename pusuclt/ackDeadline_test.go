@@ -0,0 +1,88 @@
+package pusuclt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+// awaitCallbacks waits for each of the Callbacks to be called, returning
+// the errors they were passed, or nil for those that were not called
+func awaitCallbacks(chans []chan error) []error {
+	errs := make([]error, len(chans))
+
+	for i, ch := range chans {
+		select {
+		case errs[i] = <-ch:
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	return errs
+}
+
+func TestAckDeadlines(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		f       func(cc *Client)
+		expErrs []error
+	}{
+		{
+			ID: testhelper.MkID("sweep - one overdue"),
+			f: func(cc *Client) {
+				cc.sweepCallbacks(time.Now().Add(30 * time.Second))
+			},
+			expErrs: []error{ErrAckTimeout, nil},
+		},
+		{
+			ID: testhelper.MkID("sweep - none overdue"),
+			f: func(cc *Client) {
+				cc.sweepCallbacks(time.Now())
+			},
+			expErrs: []error{nil, nil},
+		},
+		{
+			ID: testhelper.MkID("connection lost"),
+			f: func(cc *Client) {
+				cc.close(errors.New("read failure"))
+			},
+			expErrs: []error{ErrConnLost, ErrConnLost},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			cc := makeTestClient(&bytes.Buffer{}, &bytes.Buffer{},
+				&bytes.Buffer{}, nil)
+			cc.cci.AckTimeout = time.Minute
+
+			chans := []chan error{make(chan error, 1), make(chan error, 1)}
+
+			for i, ch := range chans {
+				cc.addCallback(pusu.MsgID(i+1), func(err error) { ch <- err })
+			}
+
+			cc.callbacks[1] = pendingCallback{
+				cb:       cc.callbacks[1].cb,
+				deadline: time.Now().Add(time.Second),
+			}
+
+			tc.f(cc)
+
+			errs := awaitCallbacks(chans)
+			for i, err := range errs {
+				if !errors.Is(err, tc.expErrs[i]) {
+					t.Log(tc.IDStr())
+					t.Logf("\t: Callback %d: expected error: %v",
+						i+1, tc.expErrs[i])
+					t.Logf("\t: Callback %d:   actual error: %v", i+1, err)
+					t.Error("\t: unexpected Callback error")
+				}
+			}
+		})
+	}
+}
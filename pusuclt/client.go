@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net"
-	reflect "reflect"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nickwells/pusu.mod/pusu"
@@ -16,32 +18,45 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-var errNoConn = errors.New("the client is not connected to the server")
+var (
+	errNoConn       = errors.New("the client is not connected to the server")
+	errDisconnected = errors.New("the client has been disconnected")
+)
+
+// ErrNoPingReply is the cause given when the connection is closed because
+// the pub/sub server has stopped replying to Pings (see
+// ConnInfo.MaxMissedPings and ConnInfo.PingTimeout)
+var ErrNoPingReply = errors.New("the pub/sub server is not replying to Pings")
 
 // MsgHandler is a function that will be called when a Publish message is
 // received over the connection.
 //
-// Note that the handler is called by the message reading goroutine. You are
-// advised to pass any work off to a separate goroutine as soon as feasible
-// so as to not delay the message reading goroutine.
+// Note that the handler is called by one of the dispatch workers (see
+// ConnInfo.DispatchWorkers) or, if there are none, by the message reading
+// goroutine. A handler that is slow to return delays the delivery of later
+// messages on the same topic and, if it falls too far behind, messages will
+// not be delivered to it (see ConnInfo.HandlerQueueSize).
 type MsgHandler func(topic pusu.Topic, payload []byte)
 
 // id returns the id of the MsgHandler
 func (mh MsgHandler) id() uintptr {
-	if mh == nil {
-		return 0
-	}
-
-	return uintptr(reflect.ValueOf(mh).Pointer())
+	return funcValueAddr(mh)
 }
 
 // topicHandlerMap maps between a Topic and the collection of MsgHandlers
 // associated with it
 type topicHandlerMap map[pusu.Topic]*handlerSet
 
+// topicList records a collection of Topics in the order they were added
+type topicList []pusu.Topic
+
+// thSubscriptionMap maps between a TopicHandler, identified by its topic
+// and handler, and the Subscription made for it by Subscribe
+type thSubscriptionMap map[thKey]*Subscription
+
 // callbackMap maps between a message id and the Callback requested for that
 // message
-type callbackMap map[pusu.MsgID]Callback
+type callbackMap map[pusu.MsgID]pendingCallback
 
 // Client contains the client connection to a pub/sub server and the details
 // needed to establish it.
@@ -57,16 +72,57 @@ type Client struct {
 
 	conn      io.ReadWriteCloser // the network connection
 	connected bool               // flag set after connection is established
+	started   bool               // flag set after the Start message is Acked
+	stopping  bool               // flag set after run is told to stop
+
+	disconnecting bool          // flag set after Disconnect is called
+	closing       bool          // flag set after Close is called
+	drained       chan struct{} // closed when no Callbacks remain
+	reconnecting  bool          // flag set while trying to reconnect
+	reconnectStop chan struct{} // closed to abandon reconnecting
 
 	handlers     topicHandlerMap    // the handler funcs for Publish messages
+	patterns     topicList          // the handler topics having wildcards
 	sendChan     chan *pusu.Message // channel to send messages to the server
+	ctrlChan     chan *pusu.Message // as sendChan but read first, not Publish
 	stopChan     chan struct{}      // channel to disconnect from the server
+	runExit      chan struct{}      // closed when the run loop finishes
+	runDone      chan struct{}      // closed when the connection is closed
 	msgID        pusu.MsgID         // the next message id to use
+	subID        uint64             // the last Subscription id used
+	thSubs       thSubscriptionMap  // the TopicHandler Subscriptions
+	retained     retainedMap        // the retained values received
+	replays      []dispatchJob      // retained values for new handlers
+	reqID        uint64             // the last Request id used
+	replyPfx     pusu.Topic         // the root of the Request reply topics
 	callbacks    callbackMap        // the callback for the message
+	startMsgID   pusu.MsgID         // the ID of the latest Start message
+	svrInfo      ServerInfo         // the server details from the Start Ack
 	startTimeout time.Duration      // wait this long before aborting Startup
 
-	tlsConfig *tls.Config
-	logger    *slog.Logger
+	pingsUnanswered int       // Pings sent since the last Ping reply
+	lastPingReply   time.Time // when the last Ping reply was received
+
+	state    ConnState      // the state of the connection
+	notifier *stateNotifier // passes changes of state to the observers
+
+	dispatcher *dispatcher // delivers the messages received to the handlers
+
+	outbox    *outbox // holds Publish messages while reconnecting
+	replaying bool    // flag set while the outbox is being sent
+
+	svrAddrs []string // the pub/sub server addresses in the order tried
+	svrIdx   int      // the index of the server in use or last tried
+
+	tlsConfig  *tls.Config
+	baseLogger *slog.Logger                // the logger given to NewClient
+	logger     atomic.Pointer[slog.Logger] // adds the server in use
+}
+
+// log returns the logger to use. It records the address of the pub/sub
+// server in use.
+func (c *Client) log() *slog.Logger {
+	return c.logger.Load()
 }
 
 // nextMsgID increments and returns the message id
@@ -118,84 +174,176 @@ func makeClient(
 	logger *slog.Logger,
 	info *ConnInfo,
 ) *Client {
-	return &Client{
-		namespace: namespace,
-		clientID:  makeClientID(progName),
-		progName:  progName,
-		logger: logger.With(
-			pusu.NetAddressAttr(info.SvrAddress),
-			namespace.Attr()),
+	c := &Client{
+		namespace:    namespace,
+		clientID:     makeClientID(progName),
+		progName:     progName,
 		cci:          info,
 		startTimeout: time.Second,
+		startMsgID:   pusu.NoMsgID,
+		replyPfx:     makeReplyPfx(),
 		handlers:     make(topicHandlerMap),
+		thSubs:       make(thSubscriptionMap),
+		retained:     make(retainedMap),
 		callbacks:    make(callbackMap),
+		notifier:     newStateNotifier(info.StateObserver),
+		outbox:       newOutbox(info.Outbox),
+		svrAddrs:     info.svrAddresses(),
+		baseLogger:   logger,
 	}
+
+	c.setServer(0)
+	c.dispatcher = newDispatcher(info, c.log)
+
+	return c
 }
 
 // serverDetails returns a string giving a standard description of the
 // pub/sub server the client is connecting to.
 func (c *Client) serverDetails() string {
-	return fmt.Sprintf("pub/sub server (%q)", c.cci.SvrAddress)
+	return fmt.Sprintf("pub/sub server (%q)", c.svrAddrs[c.svrIdx])
 }
 
 // connect connects to the addressed server. It returns an error if anything
 // goes wrong. If it returns a nil error the connection was correctly
 // established.
 func (c *Client) connect() error {
-	c.logger.Info("Connecting")
+	c.log().Info("Connecting")
 
-	if err := c.cci.CertInfo.PopulateCert(); err != nil {
-		return err
+	c.mtx.Lock()
+	c.setState(StateConnecting, nil)
+	c.mtx.Unlock()
+
+	var err error
+
+	if c.cci.dialer().UsesTLS() {
+		err = c.cci.CertInfo.Populate()
+		if err == nil {
+			c.tlsConfig = c.cci.tlsConfig()
+		}
 	}
 
-	if err := c.cci.CertInfo.PopulateCertPool(); err != nil {
-		return err
+	if err == nil {
+		err = c.startAnyConn(0)
+	}
+
+	if err != nil {
+		c.mtx.Lock()
+		c.setClosed(err)
+		c.mtx.Unlock()
 	}
 
-	c.tlsConfig = &tls.Config{
-		RootCAs:      c.cci.CertInfo.CertPool(),
-		Certificates: []tls.Certificate{c.cci.CertInfo.Cert()},
-		MinVersion:   tls.VersionTLS13,
+	return err
+}
+
+// dial makes the network connection to the pub/sub server using the
+// ConnInfo Dialer.
+func (c *Client) dial() (io.ReadWriteCloser, error) {
+	conn, err := c.cci.dialer().Dial(
+		c.svrAddrs[c.svrIdx], c.cci.ConnTimeout, c.tlsConfig)
+	if err != nil {
+		return nil,
+			fmt.Errorf("couldn't connect to %s: %w", c.serverDetails(), err)
 	}
 
-	var err error
+	return conn, nil
+}
 
-	c.conn, err = tls.DialWithDialer(
-		&net.Dialer{
-			Timeout: c.cci.ConnTimeout,
-		}, "tcp", c.cci.SvrAddress, c.tlsConfig)
+// startConn makes a new connection to the pub/sub server, sends the Start
+// message and starts the goroutines which read from and write to the
+// connection. It then waits for the Start message to be acknowledged and,
+// once it has been, replays the subscriptions for any topics having
+// handlers (there will only be any if this is a reconnection). It returns a
+// non-nil error if the connection could not be established, in which case
+// the connection will have been closed.
+func (c *Client) startConn() error {
+	conn, err := c.dial()
 	if err != nil {
-		return fmt.Errorf("couldn't connect to %s: %w", c.serverDetails(), err)
+		return err
 	}
 
-	c.sendChan = make(chan *pusu.Message)
-	c.stopChan = make(chan struct{})
+	readDone := make(chan error, 1)
+	runDone := make(chan struct{})
 
-	c.connected = true
+	go c.readConn(conn, readDone)
+
+	c.mtx.Lock()
 
-	go c.run()
-	go c.readConn()
+	c.conn = conn
 
 	var startAckChan chan error
 	if startAckChan, err = c.writeStartMsg(); err != nil {
-		_ = c.conn.Close()
+		c.mtx.Unlock()
+
+		_ = conn.Close()
 
 		return fmt.Errorf("client startup failed: %s: %w",
 			c.serverDetails(), err)
 	}
 
+	c.sendChan = make(chan *pusu.Message, c.cci.sendQueueSize())
+	c.ctrlChan = make(chan *pusu.Message, c.cci.sendQueueSize())
+	c.stopChan = make(chan struct{})
+	c.runExit = make(chan struct{})
+	c.runDone = runDone
+	c.stopping = false
+	c.connected = true
+	c.pingsUnanswered = 0
+	c.lastPingReply = time.Now()
+
+	go c.run(readDone, runDone)
+
+	c.mtx.Unlock()
+
 	err = c.startCheck(startAckChan)
 
-	c.logger.Info("Connected", pusu.ErrorAttr(err))
+	c.log().Info("Connected",
+		append(c.ServerInfo().attrs(), pusu.ErrorAttr(err))...)
 
-	return err
+	c.mtx.Lock()
+
+	if err == nil {
+		if c.disconnecting {
+			err = errDisconnected
+		} else if !c.connected {
+			err = fmt.Errorf("client startup failed: %s: %w",
+				c.serverDetails(), errNoConn)
+		}
+	}
+
+	if err != nil {
+		c.stopRun()
+		c.mtx.Unlock()
+
+		<-runDone
+
+		return err
+	}
+
+	c.started = true
+	c.reconnecting = false
+	c.replaying = c.outbox != nil && c.outbox.len() > 0
+	c.setState(StateConnected, nil)
+	om := c.resubscribeMsg()
+
+	c.mtx.Unlock()
+
+	if om != nil {
+		if _, err := c.enqueue(*om, SendBlock); err != nil {
+			c.log().Error("resubscription failed", pusu.ErrorAttr(err))
+		}
+	}
+
+	c.replayOutbox()
+
+	return nil
 }
 
 // writeStartMsg writes the identifying message to the connection. This
 // should only be called once on the connection where it should be the first
 // message sent.
 func (c *Client) writeStartMsg() (chan error, error) {
-	c.logger.Info("sending the start message")
+	c.log().Info("sending the start message")
 
 	payload, err := proto.Marshal(&pusu.StartMsgPayload{
 		ProtocolVersion: pusu.CurrentProtoVsn,
@@ -207,7 +355,8 @@ func (c *Client) writeStartMsg() (chan error, error) {
 	}
 
 	msgID := c.nextMsgID()
-	startAckChan := make(chan error)
+	c.startMsgID = msgID
+	startAckChan := make(chan error, 1)
 
 	c.addCallback(msgID,
 		func(err error) {
@@ -242,24 +391,50 @@ func (c *Client) startCheck(startAckChan chan error) error {
 	return err
 }
 
-// Disconnect will cause the client to disconnect from the server. It returns
-// a non-nil error if the client is not connected.
+// Disconnect will cause the client to disconnect from the server. If the
+// client is trying to reconnect it will stop trying. It returns a non-nil
+// error if the client is neither connected nor reconnecting.
 //
-// Note that it is not possible to reconnect to the publish/subscribe server;
-// a new client should be created.
+// Note that once disconnected the client will not reconnect to the
+// publish/subscribe server; a new client should be created.
 func (c *Client) Disconnect() error {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
-	if !c.connected {
+	return c.disconnect()
+}
+
+// disconnect does the work of Disconnect. The Client mutex must be held
+// when this is called.
+func (c *Client) disconnect() error {
+	if c.reconnecting {
+		c.disconnecting = true
+		c.reconnecting = false
+		close(c.reconnectStop)
+		c.setClosed(nil)
+
+		return nil
+	}
+
+	if !c.connected || c.disconnecting {
 		return errNoConn
 	}
 
-	c.stopChan <- struct{}{}
+	c.disconnecting = true
+	c.stopRun()
 
 	return nil
 }
 
+// stopRun tells the run goroutine to stop, it will close the connection as
+// it finishes. The Client mutex must be held when this is called.
+func (c *Client) stopRun() {
+	if c.connected && !c.stopping {
+		c.stopping = true
+		close(c.stopChan)
+	}
+}
+
 // writePingMsg writes the ping message to the connection
 func (c *Client) writePingMsg(t time.Time) error {
 	payload, err := proto.Marshal(
@@ -283,29 +458,60 @@ func (c *Client) writePingMsg(t time.Time) error {
 	return nil
 }
 
-// addHandler adds the TopicHandler to the client. it checks the TopicHandler
-// first and reports any errors found. It returns true if this is the first
-// handler for the topic.
+// addHandler adds the TopicHandler to the client with a new Subscription
+// ID. See addKeyedHandler for details.
 func (c *Client) addHandler(th TopicHandler) (bool, error) {
-	if err := th.check(); err != nil {
+	return c.addKeyedHandler(th.keyed())
+}
+
+// addKeyedHandler adds the TopicHandler to the client. it checks the
+// TopicHandler first and reports any errors found. If the TopicHandler was
+// given to Subscribe a Subscription is made and recorded for it; it is an
+// error if the same handler has already been subscribed to the topic. It
+// returns true if this is the first handler for the topic.
+func (c *Client) addKeyedHandler(kh keyedHandler) (bool, error) {
+	if err := kh.check(); err != nil {
 		return false, err
 	}
 
+	var s *Subscription
+
+	if kh.byValue {
+		if _, ok := c.thSubs[kh.TopicHandler.key()]; ok {
+			return false, errHandlerAlreadyAdded
+		}
+
+		s = c.nextSubscription(kh.TopicHandler)
+		kh = s.keyed()
+	}
+
 	var hs *handlerSet
 
 	var ok, newTopic bool
 
-	if hs, ok = c.handlers[th.Topic]; !ok {
+	if hs, ok = c.handlers[kh.Topic]; !ok {
 		newTopic = true
 		hs = newHandlerSet()
 	}
 
-	if err := hs.addHandler(th.Handler); err != nil {
+	if err := hs.add(kh.Topic, kh.key, kh.deliveryHandler()); err != nil {
 		return false, err
 	}
 
+	if !newTopic {
+		c.queueRetainedReplays(kh.Topic, hs.entry(kh.key))
+	}
+
+	if s != nil {
+		c.thSubs[s.th.key()] = s
+	}
+
 	if newTopic {
-		c.handlers[th.Topic] = hs
+		c.handlers[kh.Topic] = hs
+
+		if kh.Topic.IsPattern() {
+			c.patterns = append(c.patterns, kh.Topic)
+		}
 	}
 
 	return newTopic, nil
@@ -317,30 +523,75 @@ func (c *Client) addHandler(th TopicHandler) (bool, error) {
 // that the Handler is not nil. If either check fails then an error is
 // returned and none of the subscriptions are made.
 //
-// Note that the message function associated with each topic will be called
-// in a separate goroutine when a Publish message is received.
+// A Topic may contain wildcards (see [pusu.Topic]) in which case the
+// handler will be called for messages published on any matching topic.
+//
+// Note that the handler associated with each topic is called when a
+// Publish message is received; see the notes on MsgHandler for which
+// goroutine calls it and what happens if it is slow to return.
 //
 // Note that the Callback argument can be nil in which case it will be
 // ignored. See the documentation for the Callback type to understand how it
 // should be used.
 func (c *Client) Subscribe(cb Callback, handlers ...TopicHandler) error {
+	_, err := c.subscribe(cb, keyedHandlers(handlers)...)
+
+	return err
+}
+
+// subscribe adds the handlers and sends a Subscribe message for any topics
+// not previously subscribed to. It returns the ID of the message sent or
+// pusu.NoMsgID if no message was sent.
+func (c *Client) subscribe(cb Callback, handlers ...keyedHandler,
+) (pusu.MsgID, error) {
 	if len(handlers) == 0 {
-		return nil
+		return pusu.NoMsgID, nil
 	}
 
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
+	om, err := c.subscribeMsg(cb, handlers...)
+	c.mtx.Unlock()
 
-	if !c.connected {
-		return errNoConn
+	c.replayRetained()
+
+	if om == nil {
+		return pusu.NoMsgID, err
+	}
+
+	return c.enqueue(*om, SendBlock)
+}
+
+// subscribeMsg adds the handlers and prepares a Subscribe message for any
+// topics not previously subscribed to. It returns nil if no message needs
+// to be sent. The Client mutex must be held when this is called.
+func (c *Client) subscribeMsg(cb Callback, handlers ...keyedHandler,
+) (*outMsg, error) {
+	if err := c.checkConnected(); err != nil {
+		return nil, err
+	}
+
+	for i, th := range handlers {
+		if !th.Topic.IsPattern() {
+			continue
+		}
+
+		if err := c.checkCapability(pusu.CapWildcards,
+			"subscribing to a wildcard topic"); err != nil {
+			return nil,
+				fmt.Errorf("cannot add the handler for Topic %q (%d): %w",
+					th.Topic, i, err)
+		}
 	}
 
 	smp := pusu.SubscriptionMsgPayload{}
 
 	for i, th := range handlers {
-		if newTopic, err := c.addHandler(th); err != nil {
-			return fmt.Errorf("cannot add the handler for Topic %q (%d): %w",
-				th.Topic, i, err)
+		if newTopic, err := c.addKeyedHandler(th); err != nil {
+			_, _ = c.removeKeyedHandlers(handlers[:i]...)
+
+			return nil,
+				fmt.Errorf("cannot add the handler for Topic %q (%d): %w",
+					th.Topic, i, err)
 		} else if newTopic {
 			smp.Subs = append(smp.Subs,
 				&pusu.SubscriptionMsgPayload_Sub{Topic: string(th.Topic)})
@@ -350,28 +601,43 @@ func (c *Client) Subscribe(cb Callback, handlers ...TopicHandler) error {
 	if len(smp.Subs) == 0 {
 		// all the subscriptions previously existed - we are just adding new
 		// handlers so don't send a message to the pub/sub server
-		return nil
+		return nil, nil
 	}
 
-	payload, err := proto.Marshal(&smp)
-	if err != nil {
-		c.logger.Error("could not marshal the Subscribe message",
-			pusu.ErrorAttr(err))
+	return c.prepareSubscription(pusu.Subscribe, &smp,
+		c.undoRejectedSubscribe(cb, handlers))
+}
 
-		return fmt.Errorf("could not marshal the Subscribe message: %w", err)
-	}
+// isRejection returns true if the error was reported by the pub/sub server
+// in reply to a message
+func isRejection(err error) bool {
+	var ce *pusu.CodedError
 
-	msgID := c.nextMsgID()
+	return errors.As(err, &ce)
+}
 
-	c.addCallback(msgID, cb)
+// undoRejectedSubscribe returns a Callback which, if the pub/sub server
+// rejects the Subscribe message, removes the handlers added with it before
+// calling the Callback, if any. Otherwise a later Subscribe for the same
+// topics would find the handlers and not send a message.
+func (c *Client) undoRejectedSubscribe(cb Callback, handlers []keyedHandler,
+) Callback {
+	return func(err error) {
+		if isRejection(err) {
+			c.mtx.Lock()
+
+			for _, kh := range handlers {
+				// the handler may have been removed already
+				_, _ = c.removeKeyedHandlers(kh)
+			}
 
-	c.sendChan <- &pusu.Message{
-		MT:      pusu.Subscribe,
-		MsgID:   msgID,
-		Payload: payload,
-	}
+			c.mtx.Unlock()
+		}
 
-	return nil
+		if cb != nil {
+			cb(err)
+		}
+	}
 }
 
 // Unsubscribe causes an unsubscription message to be sent to the pub/sub
@@ -379,154 +645,512 @@ func (c *Client) Subscribe(cb Callback, handlers ...TopicHandler) error {
 // Callback argument can be nil in which case it will be ignored. See the
 // documentation for the Callback type to understand how it should be used.
 func (c *Client) Unsubscribe(cb Callback, handlers ...TopicHandler) error {
+	_, err := c.unsubscribe(cb, keyedHandlers(handlers)...)
+
+	return err
+}
+
+// unsubscribe removes the handlers and sends an Unsubscribe message for any
+// topics left with no handlers. It returns the ID of the message sent or
+// pusu.NoMsgID if no message was sent.
+func (c *Client) unsubscribe(cb Callback, handlers ...keyedHandler,
+) (pusu.MsgID, error) {
 	if len(handlers) == 0 {
-		return nil
+		return pusu.NoMsgID, nil
 	}
 
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
+	om, err := c.unsubscribeMsg(cb, handlers...)
+	c.mtx.Unlock()
 
-	if !c.connected {
-		return errNoConn
+	if om == nil {
+		return pusu.NoMsgID, err
+	}
+
+	return c.enqueue(*om, SendBlock)
+}
+
+// unsubscribeMsg removes the handlers and prepares an Unsubscribe message
+// for any topics left with no handlers. It returns nil if no message needs
+// to be sent. The Client mutex must be held when this is called.
+func (c *Client) unsubscribeMsg(cb Callback, handlers ...keyedHandler,
+) (*outMsg, error) {
+	if err := c.checkConnected(); err != nil {
+		return nil, err
+	}
+
+	topics, err := c.removeKeyedHandlers(handlers...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(topics) == 0 {
+		// none of the subscriptions have had their last handler removed so
+		// don't send a message to the pub/sub server
+		return nil, nil
 	}
 
 	smp := pusu.SubscriptionMsgPayload{}
 
+	for _, t := range topics {
+		smp.Subs = append(smp.Subs,
+			&pusu.SubscriptionMsgPayload_Sub{Topic: string(t)})
+	}
+
+	return c.prepareSubscription(pusu.Unsubscribe, &smp, cb)
+}
+
+// removeKeyedHandlers removes the handlers, returning the topics left with
+// no handlers. The Client mutex must be held when this is called.
+func (c *Client) removeKeyedHandlers(handlers ...keyedHandler,
+) ([]pusu.Topic, error) {
+	var topics []pusu.Topic
+
 	for i, th := range handlers {
 		var hs *handlerSet
 
 		var ok bool
 
 		if hs, ok = c.handlers[th.Topic]; !ok {
-			return fmt.Errorf(
-				"there is no existing subscription for Topic %q (%d)",
-				th.Topic, i)
+			return nil,
+				fmt.Errorf(
+					"there is no existing subscription for Topic %q (%d)",
+					th.Topic, i)
+		}
+
+		k := th.key
+
+		if th.byValue {
+			s, ok := c.thSubs[th.TopicHandler.key()]
+			if !ok {
+				return nil,
+					fmt.Errorf(
+						"cannot remove the handler for Topic %q (%d): %w",
+						th.Topic, i, errHandlerNotInSet)
+			}
+
+			k = s.keyed().key
 		}
 
-		if err := hs.removeHandler(th.Handler); err != nil {
-			return fmt.Errorf("cannot remove the handler for Topic %q (%d): %w",
-				th.Topic, i, err)
+		if err := hs.remove(k); err != nil {
+			return nil,
+				fmt.Errorf("cannot remove the handler for Topic %q (%d): %w",
+					th.Topic, i, err)
+		}
+
+		if th.byValue {
+			delete(c.thSubs, th.TopicHandler.key())
 		}
 
 		if hs.handlerCount() == 0 {
 			delete(c.handlers, th.Topic)
-			smp.Subs = append(smp.Subs,
-				&pusu.SubscriptionMsgPayload_Sub{Topic: string(th.Topic)})
+			c.patterns = slices.DeleteFunc(c.patterns,
+				func(t pusu.Topic) bool { return t == th.Topic })
+			c.forgetUnsubscribedRetained()
+			topics = append(topics, th.Topic)
 		}
 	}
 
-	if len(smp.Subs) == 0 {
-		// none of the subscriptions have had their last handler removed so
-		// don't send a message to the pub/sub server
-		return nil
-	}
+	return topics, nil
+}
 
-	payload, err := proto.Marshal(&smp)
+// prepareSubscription marshals the (un)subscription payload and prepares
+// a message of the given type to be sent to the pub/sub server. The Client
+// mutex must be held when this is called.
+func (c *Client) prepareSubscription(
+	mt pusu.MsgType,
+	smp *pusu.SubscriptionMsgPayload,
+	cb Callback,
+) (*outMsg, error) {
+	payload, err := proto.Marshal(smp)
 	if err != nil {
-		c.logger.Error("could not marshal the Unsubscribe message",
+		c.log().Error("could not marshal the "+mt.String()+" message",
 			pusu.ErrorAttr(err))
 
-		return fmt.Errorf("could not marshal the Unsubscribe message: %w", err)
+		return nil,
+			fmt.Errorf("could not marshal the %s message: %w", mt, err)
 	}
 
-	msgID := c.nextMsgID()
+	om := c.prepare(
+		&pusu.Message{
+			MT:      mt,
+			Payload: payload,
+		}, cb)
 
-	c.addCallback(msgID, cb)
+	return &om, nil
+}
 
-	c.sendChan <- &pusu.Message{
-		MT:      pusu.Unsubscribe,
-		MsgID:   msgID,
-		Payload: payload,
+// checkConnected returns a non-nil error if messages cannot be sent to the
+// pub/sub server; ErrClosed if Close has been called or errNoConn if the
+// client is not connected. The Client mutex must be held when this is
+// called.
+func (c *Client) checkConnected() error {
+	if c.closing {
+		return ErrClosed
+	}
+
+	if !c.connected {
+		return errNoConn
 	}
 
 	return nil
 }
 
+// resubscribeMsg prepares a Subscribe message for every topic having
+// handlers. This is used after reconnecting to restore the subscriptions
+// made over the previous connection. It returns nil if there are no
+// handlers. The Client mutex must be held when this is called.
+func (c *Client) resubscribeMsg() *outMsg {
+	if len(c.handlers) == 0 {
+		return nil
+	}
+
+	smp := pusu.SubscriptionMsgPayload{}
+
+	for _, t := range slices.Sorted(maps.Keys(c.handlers)) {
+		smp.Subs = append(smp.Subs,
+			&pusu.SubscriptionMsgPayload_Sub{Topic: string(t)})
+	}
+
+	c.log().Info("resubscribing", slog.Int("topics", len(smp.Subs)))
+
+	om, err := c.prepareSubscription(pusu.Subscribe, &smp,
+		func(err error) {
+			if err != nil {
+				c.log().Error("resubscription failed", pusu.ErrorAttr(err))
+			}
+		})
+	if err != nil {
+		c.log().Error("resubscription failed", pusu.ErrorAttr(err))
+	}
+
+	return om
+}
+
 // Publish causes a publication message to be sent to the pub/sub server. The
-// topic is checked before being added and if it does not pass then an error
-// is returned.
+// topic is checked before being added and if it does not pass, or if it
+// contains wildcards, then an error is returned. Any PublishOpts are
+// applied to the message before it is sent. The message is added to the
+// send queue and, if the queue is full, the ConnInfo SendPolicy is applied.
+//
+// If the ConnInfo has an Outbox then, while the client is reconnecting,
+// the message is held in the outbox and sent once the connection has been
+// restored (see OutboxConfig).
 func (c *Client) Publish(
 	cb Callback,
 	topic pusu.Topic,
 	payload []byte,
+	opts ...PublishOpt,
 ) error {
-	if err := topic.Check(); err != nil {
-		return err
+	_, err := c.publish(cb, c.cci.SendPolicy, topic, payload, opts...)
+
+	return err
+}
+
+// TryPublish behaves like Publish except that it never waits for room in
+// the send queue. If the queue is full it returns ErrSendQueueFull,
+// whatever the SendPolicy.
+func (c *Client) TryPublish(
+	cb Callback,
+	topic pusu.Topic,
+	payload []byte,
+	opts ...PublishOpt,
+) error {
+	_, err := c.publish(cb, SendError, topic, payload, opts...)
+
+	return err
+}
+
+// ClearRetained causes a message to be sent to the pub/sub server
+// discarding any value retained for the topic (see WithRetain). The topic is
+// checked before being added and if it does not pass then an error is
+// returned.
+func (c *Client) ClearRetained(cb Callback, topic pusu.Topic) error {
+	_, err := c.publish(cb, c.cci.SendPolicy, topic, nil, clearRetained)
+
+	return err
+}
+
+// clearRetained is a PublishOpt that makes the message clear the retained
+// value for the topic
+func clearRetained(pmp *pusu.PublishMsgPayload) error {
+	pmp.ClearRetained = true
+
+	return nil
+}
+
+// publish sends the Publish message returning the ID of the message
+// sent. The SendPolicy is applied if the send queue is full.
+func (c *Client) publish(
+	cb Callback,
+	policy SendPolicy,
+	topic pusu.Topic,
+	payload []byte,
+	opts ...PublishOpt,
+) (pusu.MsgID, error) {
+	if err := topic.CheckConcrete(); err != nil {
+		return pusu.NoMsgID, err
+	}
+
+	pmp := pusu.PublishMsgPayload{
+		Topic:       string(topic),
+		Payload:     payload,
+		PublishTime: timestamppb.Now(),
+	}
+
+	for _, o := range opts {
+		if err := o(&pmp); err != nil {
+			return pusu.NoMsgID, err
+		}
 	}
 
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
+	om, err := c.preparePublish(cb, &pmp)
+	c.mtx.Unlock()
 
-	pmp := pusu.PublishMsgPayload{
-		Topic:   string(topic),
-		Payload: payload,
+	if err != nil {
+		return pusu.NoMsgID, err
+	}
+
+	if om.held {
+		return om.msg.MsgID, nil
 	}
 
-	msgPayload, err := proto.Marshal(&pmp)
+	return c.enqueue(om, policy)
+}
+
+// preparePublish marshals the Publish message payload, checks it and
+// prepares the message to be sent. The Client mutex must be held when this
+// is called.
+func (c *Client) preparePublish(cb Callback, pmp *pusu.PublishMsgPayload,
+) (outMsg, error) {
+	msgPayload, err := proto.Marshal(pmp)
 	if err != nil {
-		c.logger.Error("could not marshal the Publish message",
+		c.log().Error("could not marshal the Publish message",
 			pusu.ErrorAttr(err))
 
-		return fmt.Errorf("could not marshal the Publish message: %w", err)
+		return outMsg{},
+			fmt.Errorf("could not marshal the Publish message: %w", err)
 	}
 
-	if !c.connected {
-		return errNoConn
+	if maxPayload := c.cci.maxPayload(); len(msgPayload) > maxPayload {
+		return outMsg{},
+			pusu.NewCodedError(pusu.ErrCodeTooBig,
+				"the Publish message is too big: %d bytes (max: %d)",
+				len(msgPayload), maxPayload)
 	}
 
-	msgID := c.nextMsgID()
+	if err := c.checkPublishCapabilities(pmp, len(msgPayload)); err != nil {
+		return outMsg{}, err
+	}
 
-	c.addCallback(msgID, cb)
+	if c.outboxAccepts() {
+		return c.hold(msgPayload, cb)
+	}
 
-	c.sendChan <- &pusu.Message{
-		MT:      pusu.Publish,
-		MsgID:   msgID,
-		Payload: msgPayload,
+	if err := c.checkConnected(); err != nil {
+		return outMsg{}, err
+	}
+
+	return c.prepare(
+		&pusu.Message{
+			MT:      pusu.Publish,
+			Payload: msgPayload,
+		}, cb), nil
+}
+
+// checkPublishCapabilities returns a non-nil error if the Publish message
+// uses any feature that the pub/sub server does not support. The Client
+// mutex must be held when this is called.
+func (c *Client) checkPublishCapabilities(
+	pmp *pusu.PublishMsgPayload,
+	size int,
+) error {
+	if size > pusu.MaxMessagePayload {
+		if err := c.checkCapability(pusu.CapFragments,
+			"publishing a large message"); err != nil {
+			return err
+		}
+	}
+
+	if pmp.Retain || pmp.ClearRetained {
+		if err := c.checkCapability(pusu.CapRetain,
+			"retaining a publication"); err != nil {
+			return err
+		}
+	}
+
+	if len(pmp.Headers) > 0 {
+		if err := c.checkCapability(pusu.CapHeaders,
+			"publishing with headers"); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// close closes the connection to the pub/sub server
-func (c *Client) close() {
+// close closes the connection to the pub/sub server. If the connection had
+// been started and Disconnect has not been called then the ConnLost func
+// is called with the cause and, if there is a ReconnectPolicy, a goroutine
+// is started to reconnect.
+func (c *Client) close(cause error) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
 	if !c.connected {
-		c.logger.Error("cannot close connection", pusu.ErrorAttr(errNoConn))
+		c.log().Error("cannot close connection", pusu.ErrorAttr(errNoConn))
 
 		return
 	}
 
 	c.connected = false
-	close(c.sendChan)
-	close(c.stopChan)
+	c.replaying = false
+	clear(c.retained)
+	c.discardQueue(c.outbox != nil && c.started && !c.disconnecting &&
+		c.cci.Reconnect != nil)
+
+	if !c.disconnecting {
+		c.failCallbacks(ErrConnLost)
+	}
 
-	c.logger.Info("closing the pub/sub server connection")
+	c.log().Info("closing the pub/sub server connection")
 
 	if err := c.conn.Close(); err != nil {
-		c.logger.Error("problem closing the pub/sub server connection",
+		c.log().Error("problem closing the pub/sub server connection",
 			pusu.ErrorAttr(err))
 	} else {
-		c.logger.Info("pub/sub server connection closed")
+		c.log().Info("pub/sub server connection closed")
+	}
+
+	switch {
+	case c.disconnecting:
+		c.setClosed(nil)
+	case c.started:
+		c.setState(StateDisconnected, cause)
+
+		if c.cci.ConnLost != nil {
+			go c.cci.ConnLost(cause)
+		}
+
+		if c.cci.Reconnect != nil {
+			c.startReconnecting()
+		} else {
+			c.setClosed(cause)
+		}
+	}
+
+	c.started = false
+}
+
+// startReconnecting starts a goroutine to reconnect to the pub/sub
+// server. The Client mutex must be held when this is called.
+func (c *Client) startReconnecting() {
+	c.reconnecting = true
+	c.reconnectStop = make(chan struct{})
+	c.setState(StateReconnecting, nil)
+
+	go c.reconnect(*c.cci.Reconnect, c.reconnectStop)
+}
+
+// reconnect repeatedly tries to reconnect to the pub/sub server, waiting
+// between attempts as given by the ReconnectPolicy, until it succeeds, the
+// policy's attempts are exhausted or the stop channel is closed.
+func (c *Client) reconnect(rp ReconnectPolicy, stop <-chan struct{}) {
+	var err error
+
+	for attempt := 1; rp.moreAttempts(attempt); attempt++ {
+		wait := rp.backoff(attempt)
+
+		c.log().Info("reconnecting",
+			slog.Int("attempt", attempt),
+			slog.Duration("wait", wait))
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-stop:
+			timer.Stop()
+			c.log().Info("reconnection abandoned")
+
+			return
+		case <-timer.C:
+		}
+
+		c.mtx.Lock()
+		next := c.svrIdx + 1
+		c.mtx.Unlock()
+
+		err = c.startAnyConn(next)
+		if err == nil || errors.Is(err, errDisconnected) {
+			return
+		}
+
+		c.log().Error("reconnection failed",
+			slog.Int("attempt", attempt),
+			pusu.ErrorAttr(err))
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.reconnecting && c.reconnectStop == stop {
+		c.reconnecting = false
+		c.setClosed(
+			fmt.Errorf("reconnection abandoned after %d attempts: %w",
+				rp.MaxAttempts, err))
 	}
+
+	c.log().Error("reconnection abandoned - too many attempts",
+		slog.Int("attempts", rp.MaxAttempts))
 }
 
 // isPingable returns true if the client is pingable. This is true if the
-// client has a ping handler function and the ping interval has been set to
-// some value greater than 0.
+// ping interval has been set to some value greater than 0 and the client
+// has either a ping handler function or a liveness check.
 func (c *Client) isPingable() bool {
-	return c.cci.pingHandler != nil &&
+	return (c.cci.pingHandler != nil || c.cci.checksLiveness()) &&
 		c.cci.PingInterval > 0
 }
 
+// checkLiveness returns a non-nil error if the pub/sub server has failed the
+// liveness checks; if it has missed too many Pings or if it has not replied
+// to a Ping for too long. Otherwise it counts the Ping about to be sent as
+// unanswered.
+func (c *Client) checkLiveness(now time.Time) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if mp := c.cci.MaxMissedPings; mp > 0 && c.pingsUnanswered >= mp {
+		return fmt.Errorf("%w: %d Pings unanswered",
+			ErrNoPingReply, c.pingsUnanswered)
+	}
+
+	if pt := c.cci.PingTimeout; pt > 0 && now.Sub(c.lastPingReply) > pt {
+		return fmt.Errorf("%w: no reply for %s",
+			ErrNoPingReply, now.Sub(c.lastPingReply).Round(time.Millisecond))
+	}
+
+	c.pingsUnanswered++
+
+	return nil
+}
+
 // run runs the message loop. Ping messages are generated only if the client
-// connection has a Ping handler function and the Ping interval is greater
-// than zero.
-func (c *Client) run() {
-	defer c.close()
+// is pingable (see isPingable). The loop finishes when the client is told
+// to stop, when a message cannot be written, when the server fails the
+// liveness checks or when the readDone channel is closed; the connection is
+// then closed, giving the cause, and the runDone channel is closed. If there
+// is an AckTimeout the Callbacks are periodically checked for overdue
+// replies.
+func (c *Client) run(readDone <-chan error, runDone chan<- struct{}) {
+	var cause error
+
+	defer close(runDone)
+	defer func() { c.close(cause) }()
+	defer close(c.runExit)
 
-	c.logger.Info("connection running")
+	c.log().Info("connection running")
 
 	pingTicker := &time.Ticker{}
 
@@ -535,56 +1159,126 @@ func (c *Client) run() {
 		defer pingTicker.Stop()
 	}
 
+	sweepTicker := &time.Ticker{}
+
+	if si := c.cci.sweepInterval(); si > 0 {
+		sweepTicker = time.NewTicker(si)
+		defer sweepTicker.Stop()
+	}
+
 Loop:
 	for {
+		// the control messages are sent ahead of any queued Publish messages
+		select {
+		case msg := <-c.ctrlChan:
+			if cause = c.writeQueued(msg); cause != nil {
+				break Loop
+			}
+
+			continue
+		default:
+		}
+
 		select {
 		case <-c.stopChan:
-			c.logger.Info("disconnecting")
+			c.log().Info("disconnecting")
 
-			break Loop
-		case msg := <-c.sendChan:
-			if err := msg.Write(c.conn); err != nil {
-				c.logger.Error(
-					"couldn't write the message to the pub/sub server",
-					msg.MT.Attr(),
+			cause = errDisconnected
+
+			if err := c.flushQueue(); err != nil {
+				c.log().Error(
+					"couldn't write the queued messages to the pub/sub server",
 					pusu.ErrorAttr(err))
+			}
+
+			break Loop
+		case cause = <-readDone:
+			c.log().Info("connection reading has finished")
 
+			break Loop
+		case msg := <-c.ctrlChan:
+			if cause = c.writeQueued(msg); cause != nil {
+				break Loop
+			}
+		case msg := <-c.sendChan:
+			if cause = c.writeQueued(msg); cause != nil {
 				break Loop
 			}
 
 		case now := <-pingTicker.C:
-			if c.cci.pingHandler == nil { // should never happen but ...
-				c.logger.Error("unexpected Ping ticker event")
-				continue Loop
+			if err := c.checkLiveness(now); err != nil {
+				c.log().Error("the pub/sub server connection is dead",
+					pusu.ErrorAttr(err))
+
+				cause = err
+
+				break Loop
 			}
 
 			if err := c.writePingMsg(now); err != nil {
-				c.logger.Error(
+				c.log().Error(
 					"couldn't ping the pub/sub server",
 					pusu.ErrorAttr(err))
 
+				cause = err
+
 				break Loop
 			}
+
+		case now := <-sweepTicker.C:
+			c.sweepCallbacks(now)
 		}
 	}
 }
 
+// writeQueued writes the message taken from the control or send queue to
+// the connection. It returns a non-nil error if the message could not be
+// written.
+func (c *Client) writeQueued(msg *pusu.Message) error {
+	err := msg.Write(c.conn)
+	if err != nil {
+		c.log().Error("couldn't write the message to the pub/sub server",
+			msg.MT.Attr(),
+			pusu.ErrorAttr(err))
+	}
+
+	return err
+}
+
 // addCallback adds the passed Callback to the Conn's callbacks map if it is
-// non-nil.
+// non-nil. If there is an AckTimeout the deadline for the reply is recorded
+// with it.
 func (c *Client) addCallback(id pusu.MsgID, cb Callback) {
-	if cb != nil {
-		c.callbacks[id] = cb
+	if cb == nil {
+		return
+	}
+
+	pc := pendingCallback{cb: cb}
+	if c.cci.AckTimeout > 0 {
+		pc.deadline = time.Now().Add(c.cci.AckTimeout)
 	}
+
+	c.callbacks[id] = pc
 }
 
 // getCallback reads the Callback from the callbacks map and if it was
 // present if will remove the entry from callbacks and return it. Otherwise
-// it will return nil.
+// it will return nil. If the client is closing and this was the last
+// Callback then the drained channel is closed.
 func (c *Client) getCallback(id pusu.MsgID) Callback {
-	if cb, ok := c.callbacks[id]; ok {
+	if pc, ok := c.callbacks[id]; ok {
 		delete(c.callbacks, id)
 
-		return cb
+		if c.drained != nil && len(c.callbacks) == 0 {
+			close(c.drained)
+			c.drained = nil
+		}
+
+		return pc.cb
+	}
+
+	if c.outbox != nil {
+		return c.outbox.takeCallback(id)
 	}
 
 	return nil
@@ -594,12 +1288,17 @@ func (c *Client) getCallback(id pusu.MsgID) Callback {
 // map it removes the map entry and calls the callback function in a new
 // goroutine.
 func (c *Client) callback(id pusu.MsgID, err error) {
-	if cb := c.getCallback(id); cb != nil {
+	c.mtx.Lock()
+	cb := c.getCallback(id)
+	c.mtx.Unlock()
+
+	if cb != nil {
 		go cb(err)
 	}
 }
 
-// unMarshalErr constructs the error from the error message
+// unMarshalErr constructs the error from the error message. The error
+// reported by the server is returned as a *pusu.CodedError.
 func (c *Client) unMarshalErr(msg pusu.Message) error {
 	if msg.MT != pusu.Error {
 		return fmt.Errorf("cannot create an error from a message of type %s",
@@ -615,30 +1314,35 @@ func (c *Client) unMarshalErr(msg pusu.Message) error {
 		return fmt.Errorf("could not unmarshal the Error message: %w", err)
 	}
 
-	return errors.New(emp.Error)
+	return pusu.ErrorFromPayload(&emp)
 }
 
 // readConn repeatedly reads from the connection and calls the message
-// handler for each message read.
-func (c *Client) readConn() {
-	c.logger.Info("connection reading started")
+// handler for each message read. When it finishes the error which stopped
+// it is sent on the readDone channel which is then closed.
+func (c *Client) readConn(conn io.Reader, readDone chan<- error) {
+	var err error
+
+	c.log().Info("connection reading started")
 
 Loop:
 	for {
-		msg, err := pusu.ReadMsg(c.conn)
+		var msg pusu.Message
+
+		msg, err = pusu.ReadMsgWithLimit(conn, c.cci.maxPayload())
 		if err != nil {
 			if !errors.Is(err, net.ErrClosed) {
-				c.logger.Error("read failure on the connection",
+				c.log().Error("read failure on the connection",
 					pusu.ErrorAttr(err))
 			}
 
 			break Loop
 		}
 
-		c.logger.Info("received", msg.MT.Attr())
+		c.log().Info("received", msg.MT.Attr())
 
 		if err = c.handleMessageByType(msg); err != nil {
-			c.logger.Error("message handling error",
+			c.log().Error("message handling error",
 				msg.MT.Attr(),
 				pusu.ErrorAttr((err)))
 
@@ -646,7 +1350,10 @@ Loop:
 		}
 	}
 
-	c.logger.Info("connection reading finished")
+	c.log().Info("connection reading finished")
+
+	readDone <- err
+	close(readDone)
 }
 
 // handleMessageByType switches on the message type handling each type
@@ -659,8 +1366,12 @@ func (c *Client) handleMessageByType(msg pusu.Message) error {
 	case pusu.Error:
 		err = c.handleError(msg)
 		c.callback(msg.MsgID, err)
+
+		if !c.isFatal(msg.MsgID, err) {
+			err = nil
+		}
 	case pusu.Ack:
-		c.callback(msg.MsgID, nil)
+		err = c.handleAck(msg)
 	case pusu.Publish:
 		err = c.handlePublish(msg)
 	case pusu.Ping:
@@ -672,11 +1383,55 @@ func (c *Client) handleMessageByType(msg pusu.Message) error {
 	return err
 }
 
+// handleAck calls the Callback for the acknowledged message. If the message
+// is the Start message the server details are recorded first; if they
+// cannot be read the error is passed to the Callback and returned.
+func (c *Client) handleAck(msg pusu.Message) error {
+	c.mtx.Lock()
+	isStartAck := msg.MsgID == c.startMsgID
+	c.mtx.Unlock()
+
+	if isStartAck {
+		si, err := makeServerInfo(msg.Payload)
+		if err != nil {
+			c.callback(msg.MsgID, err)
+
+			return err
+		}
+
+		c.mtx.Lock()
+		c.svrInfo = si
+		c.mtx.Unlock()
+	}
+
+	c.callback(msg.MsgID, nil)
+
+	return nil
+}
+
+// isFatal reports whether the error received in an Error message means that
+// the server will close the connection. Only an error which the server has
+// reported as not fatal, on a connection using a protocol version which
+// allows that, and which is not a reply to the Start message, leaves the
+// connection open.
+func (c *Client) isFatal(msgID pusu.MsgID, err error) bool {
+	var ce *pusu.CodedError
+	if !errors.As(err, &ce) || ce.Fatal {
+		return true
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return msgID == c.startMsgID ||
+		c.svrInfo.ProtoVsn < pusu.ProtoVsnNonFatalErrors
+}
+
 // handleError extracts the error from the message, logs it and returns it.
 func (c *Client) handleError(msg pusu.Message) error {
 	err := c.unMarshalErr(msg)
 
-	c.logger.Error("a server error was received",
+	c.log().Error("a server error was received",
 		msg.MsgID.Attr(),
 		pusu.ErrorAttr(err))
 
@@ -688,44 +1443,86 @@ func (c *Client) handleError(msg pusu.Message) error {
 func (c *Client) handlePublish(msg pusu.Message) error {
 	var pubMsg pusu.PublishMsgPayload
 
-	if err := msg.Unmarshal(&pubMsg, c.logger); err != nil {
+	if err := msg.Unmarshal(&pubMsg, c.log()); err != nil {
 		return err
 	}
 
-	c.callMsgHandlers(pusu.Topic(pubMsg.Topic), pubMsg.Payload)
+	d := makeDelivery(&pubMsg)
+
+	c.mtx.Lock()
+	tracksRetained := c.tracksRetained()
+
+	if tracksRetained {
+		c.noteRetained(&pubMsg, d)
+	}
+	c.mtx.Unlock()
+
+	switch {
+	case pubMsg.ClearRetained:
+	case pubMsg.Retained && tracksRetained:
+		c.deliverRetained(d, pubMsg.Subscriptions)
+	default:
+		c.deliver(d)
+	}
 
 	return nil
 }
 
 // handlePing tries to handle the Ping message returning an error if it could
-// not do so.
+// not do so. The reply to the Ping resets the liveness checks and the
+// round-trip time is passed to the ping handler, if there is one.
 func (c *Client) handlePing(msg pusu.Message) error {
-	if c.cci.pingHandler == nil { // should never happen but ...
-		c.logger.Error("unexpected Ping message received")
-		return nil
-	}
-
 	pmp := pusu.PingMsgPayload{}
-	if err := msg.Unmarshal(&pmp, c.logger); err != nil {
+	if err := msg.Unmarshal(&pmp, c.log()); err != nil {
 		return err
 	}
 
-	go c.cci.pingHandler(time.Since(pmp.PingTime.AsTime()))
+	c.mtx.Lock()
+	c.pingsUnanswered = 0
+	c.lastPingReply = time.Now()
+	c.mtx.Unlock()
+
+	if c.cci.pingHandler != nil {
+		go c.cci.pingHandler(time.Since(pmp.PingTime.AsTime()))
+	}
 
 	return nil
 }
 
-// callMsgHandlers will look up the message handlers for the topic and call
-// them in the order they were registered.
+// callMsgHandlers calls the handlers for the topic with a Delivery having
+// just the topic and payload set. See deliver for details.
 func (c *Client) callMsgHandlers(t pusu.Topic, payload []byte) {
+	c.deliver(Delivery{Topic: t, Payload: payload})
+}
+
+// deliver will look up the message handlers for the Delivery topic and
+// pass them to the dispatcher to be called in the order they were
+// registered. The handlers for the topic itself are called first followed
+// by those for any wildcard topics matching it, taking the wildcard topics
+// in the order they were first subscribed to. If there are dispatch
+// workers, the handlers for different subscribed topics may be called by
+// different workers and so this order only holds between the handlers
+// sharing a worker (see ConnInfo.DispatchWorkers). Each handler is passed the
+// topic the message was published on. The handlers are not called with the
+// Client mutex held and so they may Publish or Subscribe.
+func (c *Client) deliver(d Delivery) {
+	var entries []*handlerEntry
+
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
 
-	if hs, ok := c.handlers[t]; ok {
-		for _, h := range hs.handlersInOrder {
-			if h != nil {
-				h(t, payload)
-			}
+	if hs, ok := c.handlers[d.Topic]; ok {
+		entries = hs.appendEntries(entries)
+	}
+
+	for _, pt := range c.patterns {
+		if pt != d.Topic && pt.Matches(d.Topic) {
+			entries = c.handlers[pt].appendEntries(entries)
 		}
 	}
+
+	c.mtx.Unlock()
+
+	if len(entries) > 0 {
+		c.dispatcher.dispatch(d, entries)
+	}
 }
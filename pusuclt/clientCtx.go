@@ -0,0 +1,111 @@
+package pusuclt
+
+import (
+	"context"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+// SubscribeCtx behaves like Subscribe but, rather than taking a Callback,
+// it waits until the pub/sub server has replied to the Subscribe message and
+// returns the error, if any, from the reply. If no message needed to be sent
+// (because all the topics were already subscribed to) it returns
+// immediately.
+//
+// If the context is cancelled or its deadline passes before the reply
+// arrives then the context's error is returned and any later reply is
+// ignored. Note that the handlers will still have been added.
+func (c *Client) SubscribeCtx(ctx context.Context, handlers ...TopicHandler,
+) error {
+	return c.await(ctx, func(cb Callback) (pusu.MsgID, error) {
+		return c.subscribe(cb, keyedHandlers(handlers)...)
+	})
+}
+
+// UnsubscribeCtx behaves like Unsubscribe but, rather than taking a
+// Callback, it waits until the pub/sub server has replied to the Unsubscribe
+// message and returns the error, if any, from the reply. If no message
+// needed to be sent (because other handlers remain for all the topics) it
+// returns immediately.
+//
+// If the context is cancelled or its deadline passes before the reply
+// arrives then the context's error is returned and any later reply is
+// ignored. Note that the handlers will still have been removed.
+func (c *Client) UnsubscribeCtx(ctx context.Context, handlers ...TopicHandler,
+) error {
+	return c.await(ctx, func(cb Callback) (pusu.MsgID, error) {
+		return c.unsubscribe(cb, keyedHandlers(handlers)...)
+	})
+}
+
+// PublishCtx behaves like Publish but, rather than taking a Callback, it
+// waits until the pub/sub server has replied to the Publish message and
+// returns the error, if any, from the reply.
+//
+// If the context is cancelled or its deadline passes before the reply
+// arrives then the context's error is returned and any later reply is
+// ignored. Note that the message may still be delivered to subscribers.
+func (c *Client) PublishCtx(
+	ctx context.Context,
+	topic pusu.Topic,
+	payload []byte,
+	opts ...PublishOpt,
+) error {
+	return c.await(ctx, func(cb Callback) (pusu.MsgID, error) {
+		return c.publish(cb, c.cci.SendPolicy, topic, payload, opts...)
+	})
+}
+
+// ClearRetainedCtx behaves like ClearRetained but, rather than taking a
+// Callback, it waits until the pub/sub server has replied to the message
+// and returns the error, if any, from the reply.
+//
+// If the context is cancelled or its deadline passes before the reply
+// arrives then the context's error is returned and any later reply is
+// ignored.
+func (c *Client) ClearRetainedCtx(ctx context.Context, topic pusu.Topic,
+) error {
+	return c.await(ctx, func(cb Callback) (pusu.MsgID, error) {
+		return c.publish(cb, c.cci.SendPolicy, topic, nil, clearRetained)
+	})
+}
+
+// await calls sendMsg passing it a Callback which will receive the reply to
+// the message sent and then waits for the reply or for the context to be
+// done. If the context is done first the Callback is removed from the
+// callbacks map. The sendMsg func should return the ID of the message sent
+// or pusu.NoMsgID if no message was sent, in which case await returns
+// immediately.
+func (c *Client) await(
+	ctx context.Context,
+	sendMsg func(Callback) (pusu.MsgID, error),
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	replyChan := make(chan error, 1)
+
+	msgID, err := sendMsg(func(err error) { replyChan <- err })
+	if err != nil || msgID == pusu.NoMsgID {
+		return err
+	}
+
+	select {
+	case err := <-replyChan:
+		return err
+	case <-ctx.Done():
+	}
+
+	c.mtx.Lock()
+	cb := c.getCallback(msgID)
+	c.mtx.Unlock()
+
+	if cb == nil {
+		// the reply arrived just as the context finished, the Callback
+		// has been taken from the map and so the reply will be sent.
+		return <-replyChan
+	}
+
+	return ctx.Err()
+}
@@ -0,0 +1,139 @@
+package pusuclt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+// fakeServer reads the messages sent by the client and replies to each of
+// them by calling the reply func. It finishes when the send channel is
+// closed.
+func fakeServer(cc *Client, reply func(cc *Client, msg *pusu.Message)) {
+	for {
+		select {
+		case msg := <-cc.ctrlChan:
+			reply(cc, msg)
+		case msg, ok := <-cc.sendChan:
+			if !ok {
+				return
+			}
+
+			reply(cc, msg)
+		}
+	}
+}
+
+func TestClientCtx(t *testing.T) {
+	const errText = "test server error"
+
+	ackReply := func(cc *Client, msg *pusu.Message) {
+		cc.callback(msg.MsgID, nil)
+	}
+	errReply := func(cc *Client, msg *pusu.Message) {
+		cc.callback(msg.MsgID, errors.New(errText))
+	}
+	noReply := func(_ *Client, _ *pusu.Message) {}
+
+	handler := func(_ pusu.Topic, _ []byte) {}
+
+	type ctxFunc func(context.Context, *Client) error
+
+	publish := func(ctx context.Context, cc *Client) error {
+		return cc.PublishCtx(ctx, "/topic", []byte("payload"))
+	}
+	subscribe := func(ctx context.Context, cc *Client) error {
+		return cc.SubscribeCtx(ctx,
+			TopicHandler{Topic: "/topic", Handler: handler})
+	}
+	unsubscribe := func(ctx context.Context, cc *Client) error {
+		th := TopicHandler{Topic: "/topic", Handler: handler}
+		if _, err := cc.addHandler(th); err != nil {
+			return err
+		}
+
+		return cc.UnsubscribeCtx(ctx, th)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		reply   func(*Client, *pusu.Message)
+		f       ctxFunc
+		timeout time.Duration
+	}{
+		{
+			ID:      testhelper.MkID("PublishCtx - Ack"),
+			reply:   ackReply,
+			f:       publish,
+			timeout: time.Second,
+		},
+		{
+			ID:      testhelper.MkID("PublishCtx - Error"),
+			ExpErr:  testhelper.MkExpErr(errText),
+			reply:   errReply,
+			f:       publish,
+			timeout: time.Second,
+		},
+		{
+			ID:      testhelper.MkID("PublishCtx - no reply"),
+			ExpErr:  testhelper.MkExpErr(context.DeadlineExceeded.Error()),
+			reply:   noReply,
+			f:       publish,
+			timeout: 10 * time.Millisecond,
+		},
+		{
+			ID:      testhelper.MkID("SubscribeCtx - Ack"),
+			reply:   ackReply,
+			f:       subscribe,
+			timeout: time.Second,
+		},
+		{
+			ID:      testhelper.MkID("SubscribeCtx - no reply"),
+			ExpErr:  testhelper.MkExpErr(context.DeadlineExceeded.Error()),
+			reply:   noReply,
+			f:       subscribe,
+			timeout: 10 * time.Millisecond,
+		},
+		{
+			ID:      testhelper.MkID("UnsubscribeCtx - Error"),
+			ExpErr:  testhelper.MkExpErr(errText),
+			reply:   errReply,
+			f:       unsubscribe,
+			timeout: time.Second,
+		},
+		{
+			ID:      testhelper.MkID("PublishCtx - already cancelled"),
+			ExpErr:  testhelper.MkExpErr(context.DeadlineExceeded.Error()),
+			reply:   ackReply,
+			f:       publish,
+			timeout: -1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			cc := makeTestClient(&bytes.Buffer{}, &bytes.Buffer{}, nil, nil)
+			cc.runExit = make(chan struct{})
+
+			go fakeServer(cc, tc.reply)
+			defer close(cc.sendChan)
+
+			ctx, cancel := context.WithTimeout(t.Context(), tc.timeout)
+			defer cancel()
+
+			err := tc.f(ctx, cc)
+			testhelper.CheckExpErr(t, err, tc)
+
+			cc.mtx.Lock()
+			testhelper.DiffInt(t, tc.IDStr(), "pending callbacks",
+				len(cc.callbacks), 0)
+			cc.mtx.Unlock()
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/nickwells/pusu.mod/pusu"
 	"github.com/nickwells/testhelper.mod/v2/testhelper"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
@@ -42,12 +43,25 @@ func (trwc testReadWriteCloser) Close() error {
 	return trwc.cErr
 }
 
+// testServerInfo describes a server supporting all the capabilities
+var testServerInfo = ServerInfo{
+	ProtoVsn: pusu.CurrentProtoVsn,
+	ServerID: "test-server",
+	Capabilities: pusu.Capabilities{
+		pusu.CapFragments,
+		pusu.CapWildcards,
+		pusu.CapRetain,
+		pusu.CapHeaders,
+	},
+}
+
 func makeTestClient(
 	loggerBuf, connBuf, closerBuf *bytes.Buffer, cErr error,
 ) *Client {
 	testLogger := slog.New(slog.NewTextHandler(loggerBuf, nil))
 	info := NewConnInfo(nil)
 	info.SvrAddress = testSvrAddr
+	info.DispatchWorkers = 0 // call the handlers before deliver returns
 	cc := makeClient(testNamespaceName, testProgName, testLogger, info)
 
 	if connBuf == nil {
@@ -59,10 +73,12 @@ func makeTestClient(
 			cErr:       cErr,
 		}
 		cc.sendChan = make(chan *pusu.Message)
+		cc.ctrlChan = make(chan *pusu.Message)
 		cc.stopChan = make(chan struct{})
 
 		cc.handlers = make(topicHandlerMap)
 		cc.callbacks = make(callbackMap)
+		cc.svrInfo = testServerInfo
 
 		cc.connected = true
 	}
@@ -95,7 +111,7 @@ func TestMakeClientID(t *testing.T) {
 		cc.serverDetails(),
 		`pub/sub server ("`+testSvrAddr+`")`)
 
-	cc.logger.Info("test-message")
+	cc.log().Info("test-message")
 
 	testhelper.CheckExpSlogMessages(t, loggerBuf.String(), tc)
 }
@@ -247,7 +263,7 @@ func TestConnClose(t *testing.T) {
 		t.Run(tc.Name, func(t *testing.T) {
 			cc := makeTestClient(
 				tc.loggerBuf, tc.connBuf, tc.closerBuf, tc.closerErr)
-			cc.close()
+			cc.close(nil)
 			testhelper.DiffBool(t, tc.IDStr(), "connected flag",
 				cc.connected, false)
 			testhelper.CheckExpSlogMessages(t, tc.loggerBuf.String(), tc)
@@ -589,6 +605,40 @@ func TestConnHandleMessage(t *testing.T) {
 				"/topicA": "/topicA:0=1\n/topicA:0=3\n/topicA:2=3\n",
 			},
 		},
+		{
+			ID:        testhelper.MkID("wildcard subs"),
+			loggerBuf: &bytes.Buffer{},
+			topicActions: []TopicAction{
+				{t: "/a/b", action: "sub"},
+				{t: "/a/**", action: "sub"},
+				{t: "/a/b", action: "pub"},
+				{t: "/x/*/z", action: "sub"},
+				{t: "/x/y/z", action: "sub"},
+				{t: "/x/y/z", action: "pub"},
+			},
+			expectedResults: map[pusu.Topic]string{
+				"/a/b":   "/a/b:0=2\n/a/b:1=2\n",
+				"/a/**":  "",
+				"/x/*/z": "",
+				"/x/y/z": "/x/y/z:4=5\n/x/y/z:3=5\n",
+			},
+		},
+		{
+			ID:        testhelper.MkID("several matching wildcard subs"),
+			loggerBuf: &bytes.Buffer{},
+			topicActions: []TopicAction{
+				{t: "/a/*", action: "sub"},
+				{t: "/a/**", action: "sub"},
+				{t: "/*/b", action: "sub"},
+				{t: "/a/b", action: "pub"},
+				{t: "/*/*", action: "sub"},
+				{t: "/a/b", action: "pub"},
+			},
+			expectedResults: map[pusu.Topic]string{
+				"/a/b": "/a/b:0=3\n/a/b:1=3\n/a/b:2=3\n" +
+					"/a/b:0=5\n/a/b:1=5\n/a/b:2=5\n/a/b:4=5\n",
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -605,11 +655,11 @@ func TestConnHandleMessage(t *testing.T) {
 			topicBuf = make(map[pusu.Topic]*bytes.Buffer)
 
 			for i, ta := range tc.topicActions {
-				if ta.action == "sub" {
-					if _, ok := topicBuf[ta.t]; !ok {
-						topicBuf[ta.t] = &bytes.Buffer{}
-					}
+				if _, ok := topicBuf[ta.t]; !ok {
+					topicBuf[ta.t] = &bytes.Buffer{}
+				}
 
+				if ta.action == "sub" {
 					th := TopicHandler{
 						Topic:   ta.t,
 						Handler: handlerFuncs[i],
@@ -630,3 +680,141 @@ func TestConnHandleMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestConnHandleError(t *testing.T) {
+	const msgID = pusu.MsgID(42)
+
+	testCases := []struct {
+		testhelper.ID
+		protoVsn pusu.ProtoVsn
+		fatal    bool
+		expFatal bool
+	}{
+		{
+			ID:       testhelper.MkID("not fatal"),
+			protoVsn: pusu.ProtoVsnNonFatalErrors,
+		},
+		{
+			ID:       testhelper.MkID("fatal"),
+			protoVsn: pusu.ProtoVsnNonFatalErrors,
+			fatal:    true,
+			expFatal: true,
+		},
+		{
+			ID:       testhelper.MkID("not fatal - old protocol version"),
+			protoVsn: pusu.ProtoVsnNonFatalErrors - 1,
+			expFatal: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			cc := makeTestClient(&bytes.Buffer{}, &bytes.Buffer{}, nil, nil)
+			cc.svrInfo.ProtoVsn = tc.protoVsn
+
+			cbErr := make(chan error, 1)
+			cc.addCallback(msgID, func(err error) { cbErr <- err })
+
+			msg := pusu.Message{MT: pusu.Error, MsgID: msgID}
+			if err := msg.Marshal(pusu.MakeErrorMsgPayload(
+				pusu.NewCodedError(pusu.ErrCodeBadTopic, "bad topic"),
+				tc.fatal), cc.log()); err != nil {
+				t.Fatal("couldn't marshal the Error message:", err)
+			}
+
+			err := cc.handleMessageByType(msg)
+			testhelper.DiffBool(t, tc.IDStr(), "fatal", err != nil, tc.expFatal)
+
+			select {
+			case err := <-cbErr:
+				testhelper.DiffBool(t, tc.IDStr(), "callback ErrBadTopic",
+					errors.Is(err, pusu.ErrBadTopic), true)
+			case <-time.After(time.Second):
+				t.Log(tc.IDStr())
+				t.Error("\t: the Callback was not called")
+			}
+		})
+	}
+}
+
+func TestCheckLiveness(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		maxMissedPings     int
+		pingTimeout        time.Duration
+		pingsUnanswered    int
+		sinceLastPingReply time.Duration
+	}{
+		{
+			ID:                 testhelper.MkID("no checks"),
+			pingsUnanswered:    100,
+			sinceLastPingReply: time.Hour,
+		},
+		{
+			ID:              testhelper.MkID("max missed pings - alive"),
+			maxMissedPings:  3,
+			pingsUnanswered: 2,
+		},
+		{
+			ID: testhelper.MkID("max missed pings - dead"),
+			ExpErr: testhelper.MkExpErr(ErrNoPingReply.Error(),
+				"3 Pings unanswered"),
+			maxMissedPings:  3,
+			pingsUnanswered: 3,
+		},
+		{
+			ID:                 testhelper.MkID("ping timeout - alive"),
+			pingTimeout:        time.Second,
+			sinceLastPingReply: time.Second,
+		},
+		{
+			ID: testhelper.MkID("ping timeout - dead"),
+			ExpErr: testhelper.MkExpErr(ErrNoPingReply.Error(),
+				"no reply for 1.5s"),
+			pingTimeout:        time.Second,
+			sinceLastPingReply: 1500 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			cc := makeTestClient(&bytes.Buffer{}, nil, nil, nil)
+			cc.cci.MaxMissedPings = tc.maxMissedPings
+			cc.cci.PingTimeout = tc.pingTimeout
+			cc.pingsUnanswered = tc.pingsUnanswered
+			cc.lastPingReply = now.Add(-tc.sinceLastPingReply)
+
+			err := cc.checkLiveness(now)
+			if !testhelper.CheckExpErr(t, err, tc) {
+				return
+			}
+
+			if err == nil {
+				testhelper.DiffInt(t, tc.IDStr(), "pings unanswered",
+					cc.pingsUnanswered, tc.pingsUnanswered+1)
+			}
+
+			msg := pusu.Message{MT: pusu.Ping}
+			if err := msg.Marshal(&pusu.PingMsgPayload{
+				PingTime: timestamppb.New(now),
+			}, cc.log()); err != nil {
+				t.Fatal("couldn't marshal the Ping message:", err)
+			}
+
+			if err := cc.handlePing(msg); err != nil {
+				t.Fatal("couldn't handle the Ping message:", err)
+			}
+
+			testhelper.DiffInt(t, tc.IDStr(), "pings unanswered after reply",
+				cc.pingsUnanswered, 0)
+
+			if cc.lastPingReply.Before(now) {
+				t.Log(tc.IDStr())
+				t.Error("\t: the last Ping reply time should have been reset")
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package pusuclt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrClosed is the error passed to any Callbacks still waiting for a reply
+// from the pub/sub server when the Client is closed. It is also returned
+// when trying to send a message after Close has been called.
+var ErrClosed = errors.New("the client connection has been closed")
+
+// Close gracefully disconnects the client from the server. New messages are
+// refused (with ErrClosed) from when it is called. It then waits for the
+// replies to the messages already sent, until the context is done, before
+// disconnecting. Any Callbacks still waiting for a reply are then called
+// with ErrClosed. Close returns once the connection has been closed.
+//
+// It returns a non-nil error if the client is neither connected nor
+// reconnecting or if the context was done before all the replies had
+// arrived. As with Disconnect, once closed the client will not reconnect;
+// a new client should be created.
+func (c *Client) Close(ctx context.Context) error {
+	c.mtx.Lock()
+
+	if c.closing || c.disconnecting || (!c.connected && !c.reconnecting) {
+		c.mtx.Unlock()
+
+		return errNoConn
+	}
+
+	c.closing = true
+
+	var drained chan struct{}
+	if c.connected && len(c.callbacks) > 0 {
+		drained = make(chan struct{})
+		c.drained = drained
+	}
+
+	c.mtx.Unlock()
+
+	var err error
+
+	if drained != nil {
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			err = fmt.Errorf("not all the replies were received: %w",
+				ctx.Err())
+		}
+	}
+
+	c.mtx.Lock()
+	c.drained = nil
+	runDone := c.runDone
+	connected := c.connected
+	_ = c.disconnect()
+	c.mtx.Unlock()
+
+	if connected {
+		<-runDone
+	}
+
+	return err
+}
+
+// setClosed moves the client to StateClosed and calls any Callbacks still
+// waiting for a reply, and those of any messages held in the outbox, with
+// ErrClosed. The Client mutex must be held when this is called.
+func (c *Client) setClosed(cause error) {
+	c.setState(StateClosed, cause)
+	c.dispatcher.stop()
+	c.failCallbacks(ErrClosed)
+
+	if c.outbox != nil {
+		for _, cb := range c.outbox.discard() {
+			go cb(ErrClosed)
+		}
+	}
+}
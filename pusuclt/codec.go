@@ -0,0 +1,71 @@
+package pusuclt
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec converts between values of type T and the bytes of a message
+// payload. It is used by the typed publish and subscribe functions (see
+// PublishTyped and SubscribeTyped).
+type Codec[T any] interface {
+	Marshal(v T) ([]byte, error)
+	Unmarshal(payload []byte) (T, error)
+}
+
+// JSONCodec is a Codec which encodes values as JSON
+type JSONCodec[T any] struct{}
+
+// Marshal returns the JSON encoding of the value
+func (JSONCodec[T]) Marshal(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal returns the value decoded from the JSON payload
+func (JSONCodec[T]) Unmarshal(payload []byte) (T, error) {
+	var v T
+
+	err := json.Unmarshal(payload, &v)
+
+	return v, err
+}
+
+// ProtoCodec is a Codec which encodes values in the protobuf wire
+// format. T should be a pointer to a generated protobuf message type.
+type ProtoCodec[T proto.Message] struct{}
+
+// Marshal returns the protobuf encoding of the value
+func (ProtoCodec[T]) Marshal(v T) ([]byte, error) {
+	return proto.Marshal(v)
+}
+
+// Unmarshal returns a new value decoded from the protobuf payload
+func (ProtoCodec[T]) Unmarshal(payload []byte) (T, error) {
+	var zero T
+
+	v, ok := zero.ProtoReflect().New().Interface().(T)
+	if !ok {
+		return zero, fmt.Errorf("cannot make a new %T", zero)
+	}
+
+	if err := proto.Unmarshal(payload, v); err != nil {
+		return zero, err
+	}
+
+	return v, nil
+}
+
+// BytesCodec is a Codec which passes the payload unchanged
+type BytesCodec struct{}
+
+// Marshal returns the bytes unchanged
+func (BytesCodec) Marshal(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+// Unmarshal returns the payload unchanged
+func (BytesCodec) Unmarshal(payload []byte) ([]byte, error) {
+	return payload, nil
+}
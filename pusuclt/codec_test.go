@@ -0,0 +1,69 @@
+package pusuclt
+
+import (
+	"testing"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+	"google.golang.org/protobuf/proto"
+)
+
+type testCodecVal struct {
+	Name  string
+	Count int
+}
+
+func TestJSONCodec(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		payload string
+		expVal  testCodecVal
+	}{
+		{
+			ID:      testhelper.MkID("good"),
+			payload: `{"Name":"a","Count":2}`,
+			expVal:  testCodecVal{Name: "a", Count: 2},
+		},
+		{
+			ID:      testhelper.MkID("bad"),
+			ExpErr:  testhelper.MkExpErr("unexpected end of JSON input"),
+			payload: `{"Name":`,
+		},
+	}
+
+	codec := JSONCodec[testCodecVal]{}
+
+	for _, tc := range testCases {
+		v, err := codec.Unmarshal([]byte(tc.payload))
+		if !testhelper.CheckExpErr(t, err, tc) || err != nil {
+			continue
+		}
+
+		testhelper.DiffString(t, tc.IDStr(), "name", v.Name, tc.expVal.Name)
+		testhelper.DiffInt(t, tc.IDStr(), "count", v.Count, tc.expVal.Count)
+
+		payload, err := codec.Marshal(v)
+		testhelper.CheckError(t, tc.IDStr()+": Marshal", err, false, nil)
+		testhelper.DiffString(t, tc.IDStr(), "payload",
+			string(payload), tc.payload)
+	}
+}
+
+func TestProtoCodec(t *testing.T) {
+	codec := ProtoCodec[*pusu.PingMsgPayload]{}
+
+	payload, err := codec.Marshal(&pusu.PingMsgPayload{})
+	testhelper.CheckError(t, "Marshal", err, false, nil)
+
+	v, err := codec.Unmarshal(payload)
+	testhelper.CheckError(t, "Unmarshal", err, false, nil)
+
+	if v == nil || !proto.Equal(v, &pusu.PingMsgPayload{}) {
+		t.Errorf("Unmarshal: unexpected value: %v", v)
+	}
+
+	_, err = codec.Unmarshal([]byte{0xff})
+	testhelper.CheckError(t, "Unmarshal bad payload", err, true,
+		[]string{"cannot parse invalid wire-format data"})
+}
@@ -16,15 +16,126 @@ type ConnInfo struct {
 	CertInfo    pusu.CertInfo // certificate information for the connection
 	ConnTimeout time.Duration // the connection dialler timeout
 
+	// SvrAddresses, if not empty, gives the network addresses of a group
+	// of pub/sub servers, any of which can be used, and SvrAddress is
+	// ignored. They are tried in turn until a connection is made. After
+	// the connection has been lost they are tried again starting with the
+	// server after the one last used.
+	SvrAddresses []string
+
+	// ShuffleSvrAddresses, if set, causes the SvrAddresses to be tried in
+	// a random order, chosen when the Client is created, rather than the
+	// order given. This spreads the clients across the servers.
+	ShuffleSvrAddresses bool
+
+	// Dialer gives how the connection to the pub/sub server is made. If it
+	// is nil (the default) a TLSTCPDialer is used. The CertInfo is only
+	// needed if the Dialer uses TLS.
+	Dialer Dialer
+
+	// TLS, if not nil, gives further settings for connections made with a
+	// Dialer using TLS.
+	TLS *TLSOptions
+
 	PingInterval time.Duration       // how long to wait between Pings
 	pingHandler  func(time.Duration) // a func to handle ping messages
+
+	// MaxMissedPings gives the number of consecutive Pings which can go
+	// unanswered before the connection is declared dead and closed. If it
+	// is not greater than zero the number of missed Pings is not checked.
+	MaxMissedPings int
+
+	// PingTimeout gives the longest time to wait for a reply to a Ping
+	// before the connection is declared dead and closed. It is checked
+	// each PingInterval. If it is not greater than zero the time since the
+	// last reply is not checked.
+	PingTimeout time.Duration
+
+	// ConnLost, if not nil, is called in a new goroutine with the cause
+	// whenever the connection to the pub/sub server is lost other than by
+	// calling Disconnect. It is called before any reconnection is tried.
+	ConnLost func(cause error)
+
+	// StateObserver, if not nil, is notified of every change to the state
+	// of the connection, including those made while the Client first
+	// connects. See Client.AddStateObserver for adding observers later.
+	StateObserver StateObserver
+
+	// MaxPayload gives the largest message payload that the client will
+	// send or accept. Messages with payloads larger than
+	// pusu.MaxMessagePayload are sent as fragments and reassembled on
+	// receipt. If it is not greater than zero pusu.DfltMaxPayload is used.
+	MaxPayload int
+
+	// AckTimeout gives the longest time to wait for the reply to a message
+	// sent to the pub/sub server. A Callback whose reply has not arrived in
+	// time is called with ErrAckTimeout soon afterwards. If it is not
+	// greater than zero, as in the ConnInfo returned by NewConnInfo, the
+	// Callbacks wait until the reply arrives or the connection is lost.
+	AckTimeout time.Duration
+
+	// SendQueueSize gives the number of messages that can be waiting to be
+	// sent to the pub/sub server. Publish messages and other messages, such
+	// as Subscribe messages, are queued separately and each queue has this
+	// size. If it is not greater than zero DfltSendQueueSize is used.
+	SendQueueSize int
+
+	// SendPolicy gives what is done with a Publish message when the send
+	// queue is full. The default is to wait until there is room.
+	SendPolicy SendPolicy
+
+	// SendTimeout gives the longest time to wait for room in the send
+	// queue with the SendBlockTimeout policy. If it is not greater than
+	// zero DfltSendTimeout is used.
+	SendTimeout time.Duration
+
+	// DispatchWorkers gives the number of goroutines delivering the
+	// messages received to the handlers. The worker is chosen by the topic
+	// subscribed to, so the handlers for any one topic, including a
+	// wildcard topic, are always called by the same worker and get the
+	// messages in the order they were received; a slow handler will only
+	// delay the messages for topics sharing its worker. Handlers for
+	// different topics matching the same message may be called at the same
+	// time by different workers. If it is not greater than zero the
+	// handlers are called by the message reading goroutine, delaying the
+	// reading of later messages.
+	DispatchWorkers int
+
+	// HandlerQueueSize gives the number of messages that can be waiting to
+	// be delivered to each handler by the dispatch workers. Later messages
+	// are not delivered to the handler until it has caught up. If it is not
+	// greater than zero DfltHandlerQueueSize is used.
+	HandlerQueueSize int
+
+	// Overflow, if not nil, is called for each message not delivered to a
+	// handler because its queue is full. It is called by the message
+	// reading goroutine and so should return promptly.
+	Overflow func(o Overflow)
+
+	// DecodeError, if not nil, is called with each message whose payload
+	// could not be decoded for a handler subscribed with SubscribeTyped,
+	// and the error. The message is not passed to the handler. It is
+	// called by the goroutine delivering the message.
+	DecodeError func(d Delivery, err error)
+
+	// Reconnect gives the policy for reconnecting to the pub/sub server
+	// after the connection has been lost. If it is nil (the default) the
+	// client will not try to reconnect.
+	Reconnect *ReconnectPolicy
+
+	// Outbox, if not nil, gives the details of the outbox in which Publish
+	// messages are held while the client is reconnecting. It has no effect
+	// unless there is a Reconnect policy. If it is nil (the default)
+	// Publish returns an error while the client is not connected.
+	Outbox *OutboxConfig
 }
 
 // NewConnInfo returns a default ConnInfo
 //
 // The ping handler is a function to be called with the ping round-trip time,
-// pass nil to ignore ping times and suppress the pinging of the
-// server. Otherwise this will be called every PingInterval.
+// pass nil to ignore ping times. The server is pinged every PingInterval if
+// there is a ping handler or if either of MaxMissedPings or PingTimeout is
+// set, otherwise pinging is suppressed.
 func NewConnInfo(pingHandler func(time.Duration)) *ConnInfo {
 	const (
 		dfltConnTimeoutSecs  = 5
@@ -32,8 +143,66 @@ func NewConnInfo(pingHandler func(time.Duration)) *ConnInfo {
 	)
 
 	return &ConnInfo{
-		ConnTimeout:  dfltConnTimeoutSecs * time.Second,
-		PingInterval: dfltPingIntervalSecs * time.Second,
-		pingHandler:  pingHandler,
+		ConnTimeout:      dfltConnTimeoutSecs * time.Second,
+		PingInterval:     dfltPingIntervalSecs * time.Second,
+		MaxPayload:       pusu.DfltMaxPayload,
+		SendQueueSize:    DfltSendQueueSize,
+		SendTimeout:      DfltSendTimeout,
+		DispatchWorkers:  DfltDispatchWorkers,
+		HandlerQueueSize: DfltHandlerQueueSize,
+		pingHandler:      pingHandler,
 	}
 }
+
+// checksLiveness returns true if either of the Ping based liveness checks is
+// set
+func (ci *ConnInfo) checksLiveness() bool {
+	return ci.MaxMissedPings > 0 || ci.PingTimeout > 0
+}
+
+// dialer returns the Dialer to use to connect to the pub/sub server
+func (ci *ConnInfo) dialer() Dialer {
+	if ci.Dialer == nil {
+		return TLSTCPDialer{}
+	}
+
+	return ci.Dialer
+}
+
+// maxPayload returns the largest message payload to be sent or accepted
+func (ci *ConnInfo) maxPayload() int {
+	if ci.MaxPayload <= 0 {
+		return pusu.DfltMaxPayload
+	}
+
+	return ci.MaxPayload
+}
+
+// sendQueueSize returns the number of messages that can be waiting to be
+// sent
+func (ci *ConnInfo) sendQueueSize() int {
+	if ci.SendQueueSize <= 0 {
+		return DfltSendQueueSize
+	}
+
+	return ci.SendQueueSize
+}
+
+// sendTimeout returns the longest time to wait for room in the send queue
+func (ci *ConnInfo) sendTimeout() time.Duration {
+	if ci.SendTimeout <= 0 {
+		return DfltSendTimeout
+	}
+
+	return ci.SendTimeout
+}
+
+// handlerQueueSize returns the number of messages that can be waiting to be
+// delivered to each handler
+func (ci *ConnInfo) handlerQueueSize() int {
+	if ci.HandlerQueueSize <= 0 {
+		return DfltHandlerQueueSize
+	}
+
+	return ci.HandlerQueueSize
+}
@@ -0,0 +1,217 @@
+package pusuclt
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+// ConnState represents the state of the Client's connection to the pub/sub
+// server
+type ConnState int
+
+const (
+	// StateDisconnected is the state of a Client whose connection has been
+	// lost. It is also the state of a Client before it first connects.
+	StateDisconnected ConnState = iota
+	// StateConnecting is the state of a Client making its first connection
+	StateConnecting
+	// StateConnected is the state of a Client whose connection has been
+	// established and whose Start message has been acknowledged
+	StateConnected
+	// StateReconnecting is the state of a Client trying to reconnect after
+	// its connection has been lost
+	StateReconnecting
+	// StateClosed is the final state of a Client. It will not connect
+	// again; a new Client should be created.
+	StateClosed
+)
+
+// String returns the name of the ConnState
+func (cs ConnState) String() string {
+	switch cs {
+	case StateDisconnected:
+		return "Disconnected"
+	case StateConnecting:
+		return "Connecting"
+	case StateConnected:
+		return "Connected"
+	case StateReconnecting:
+		return "Reconnecting"
+	case StateClosed:
+		return "Closed"
+	}
+
+	return fmt.Sprintf("ConnState(%d)", int(cs))
+}
+
+// Attr returns a slog Attr representing the ConnState
+func (cs ConnState) Attr() slog.Attr {
+	return slog.String(pusu.AttrPfx+"ConnState", cs.String())
+}
+
+// StateChange describes a transition of the Client's connection from one
+// state to another. The Cause is set when the connection is lost or the
+// Client is closed because of an error.
+type StateChange struct {
+	From  ConnState
+	To    ConnState
+	Cause error
+	Time  time.Time
+}
+
+// StateObserver is a function that will be called with each change in the
+// state of the Client's connection. The observers are called in turn, in the
+// order that the changes were made, by a goroutine dedicated to that
+// purpose. An observer which takes a long time to return will delay the
+// notification of later changes but will not delay the Client.
+type StateObserver func(sc StateChange)
+
+// pendingChange records a state change and the observers to be notified of
+// it, being those present when the change was made
+type pendingChange struct {
+	sc        StateChange
+	observers []StateObserver
+}
+
+// stateNotifier queues the state changes and passes them to the
+// observers. The notifying goroutine is started when the first change is
+// queued and it finishes after notifying a change to StateClosed.
+type stateNotifier struct {
+	mtx       sync.Mutex
+	startOnce sync.Once
+	signal    chan struct{}
+	pending   []pendingChange
+	observers []StateObserver
+}
+
+// newStateNotifier returns a stateNotifier which will notify the observer,
+// if it is not nil
+func newStateNotifier(obs StateObserver) *stateNotifier {
+	sn := &stateNotifier{signal: make(chan struct{}, 1)}
+
+	if obs != nil {
+		sn.observers = append(sn.observers, obs)
+	}
+
+	return sn
+}
+
+// addObserver adds the observer to be notified of later state changes
+func (sn *stateNotifier) addObserver(obs StateObserver) {
+	sn.mtx.Lock()
+	defer sn.mtx.Unlock()
+
+	sn.observers = append(sn.observers, obs)
+}
+
+// notify queues the state change to be passed to the observers
+func (sn *stateNotifier) notify(sc StateChange) {
+	sn.startOnce.Do(func() { go sn.run() })
+
+	sn.mtx.Lock()
+	sn.pending = append(sn.pending,
+		pendingChange{sc: sc, observers: slices.Clone(sn.observers)})
+	sn.mtx.Unlock()
+
+	select {
+	case sn.signal <- struct{}{}:
+	default: // the notifier has already been signalled
+	}
+}
+
+// run passes the queued state changes to the observers until a change to
+// StateClosed has been notified
+func (sn *stateNotifier) run() {
+	for range sn.signal {
+		sn.mtx.Lock()
+		pending := sn.pending
+		sn.pending = nil
+		sn.mtx.Unlock()
+
+		for _, pc := range pending {
+			for _, obs := range pc.observers {
+				obs(pc.sc)
+			}
+
+			if pc.sc.To == StateClosed {
+				return
+			}
+		}
+	}
+}
+
+// setState records the new state of the connection and notifies the
+// observers of the change. The Client mutex must be held when this is
+// called.
+func (c *Client) setState(to ConnState, cause error) {
+	if to == c.state {
+		return
+	}
+
+	sc := StateChange{
+		From:  c.state,
+		To:    to,
+		Cause: cause,
+		Time:  time.Now(),
+	}
+	c.state = to
+
+	c.log().Info("connection state changed",
+		slog.String(pusu.AttrPfx+"FromConnState", sc.From.String()),
+		to.Attr(),
+		pusu.ErrorAttr(cause))
+
+	c.notifier.notify(sc)
+}
+
+// State returns the current state of the Client's connection
+func (c *Client) State() ConnState {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.state
+}
+
+// AddStateObserver adds the observer to those to be notified of changes to
+// the state of the Client's connection. It will be notified of changes
+// made after it is added; use State to find the current state. To observe
+// the changes made while the Client first connects give a StateObserver in
+// the ConnInfo.
+func (c *Client) AddStateObserver(obs StateObserver) {
+	if obs == nil {
+		return
+	}
+
+	c.notifier.addObserver(obs)
+}
+
+// StateChanges returns a channel on which the changes to the state of the
+// Client's connection will be sent. The channel is closed after the change
+// to StateClosed has been sent. The size gives the capacity of the channel;
+// the channel should be read promptly as, once it is full, the notification
+// of state changes to all the observers will wait until there is room. If
+// the Client is already closed the returned channel is closed.
+func (c *Client) StateChanges(size int) <-chan StateChange {
+	ch := make(chan StateChange, max(size, 0))
+
+	if c.State() == StateClosed {
+		close(ch)
+
+		return ch
+	}
+
+	c.AddStateObserver(func(sc StateChange) {
+		ch <- sc
+
+		if sc.To == StateClosed {
+			close(ch)
+		}
+	})
+
+	return ch
+}
@@ -0,0 +1,66 @@
+package pusuclt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestConnStateString(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		cs     ConnState
+		expStr string
+	}{
+		{
+			ID:     testhelper.MkID("disconnected"),
+			cs:     StateDisconnected,
+			expStr: "Disconnected",
+		},
+		{
+			ID:     testhelper.MkID("connecting"),
+			cs:     StateConnecting,
+			expStr: "Connecting",
+		},
+		{
+			ID:     testhelper.MkID("connected"),
+			cs:     StateConnected,
+			expStr: "Connected",
+		},
+		{
+			ID:     testhelper.MkID("reconnecting"),
+			cs:     StateReconnecting,
+			expStr: "Reconnecting",
+		},
+		{
+			ID:     testhelper.MkID("closed"),
+			cs:     StateClosed,
+			expStr: "Closed",
+		},
+		{
+			ID:     testhelper.MkID("unknown"),
+			cs:     StateClosed + 1,
+			expStr: "ConnState(5)",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			testhelper.DiffString(t, tc.IDStr(), "string",
+				tc.cs.String(), tc.expStr)
+		})
+	}
+}
+
+func TestStateChangesClosed(t *testing.T) {
+	cc := makeTestClient(&bytes.Buffer{}, nil, nil, nil)
+
+	cc.mtx.Lock()
+	cc.setState(StateClosed, nil)
+	cc.mtx.Unlock()
+
+	if _, ok := <-cc.StateChanges(1); ok {
+		t.Error("the StateChanges channel of a closed Client should be closed")
+	}
+}
@@ -0,0 +1,52 @@
+package pusuclt
+
+import (
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+// Delivery holds the details of a Publish message received over the
+// connection
+type Delivery struct {
+	Topic       pusu.Topic        // the topic the message was published on
+	Headers     map[string]string // any headers given by the publisher
+	Payload     []byte            // the data published
+	PublishTime time.Time         // zero if not given by the publisher
+	PublisherID string            // the client ID of the publisher
+
+	// Retained is set if the message gives the value retained for the
+	// topic (see WithRetain), sent because the handler has just been
+	// subscribed, rather than a new publication
+	Retained bool
+}
+
+// makeDelivery constructs the Delivery from the Publish message payload
+func makeDelivery(pmp *pusu.PublishMsgPayload) Delivery {
+	d := Delivery{
+		Topic:       pusu.Topic(pmp.Topic),
+		Headers:     pmp.Headers,
+		Payload:     pmp.Payload,
+		PublisherID: pmp.PublisherId,
+		Retained:    pmp.Retained,
+	}
+
+	if pmp.PublishTime != nil {
+		d.PublishTime = pmp.PublishTime.AsTime()
+	}
+
+	return d
+}
+
+// DeliveryHandler is a function that will be called when a Publish message
+// is received over the connection. It is an alternative to a MsgHandler for
+// when the headers or other details of the publication are needed.
+//
+// Note that the handler is called in the same way as a MsgHandler; see the
+// notes on MsgHandler for details.
+type DeliveryHandler func(d Delivery)
+
+// id returns the id of the DeliveryHandler
+func (dh DeliveryHandler) id() uintptr {
+	return funcValueAddr(dh)
+}
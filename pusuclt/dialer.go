@@ -0,0 +1,113 @@
+package pusuclt
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// errNoPipeServer is returned by a PipeDialer having no Serve func
+var errNoPipeServer = errors.New("the PipeDialer has no Serve func")
+
+// Dialer makes the connection to the pub/sub server. Set the ConnInfo
+// Dialer to choose how the connection is made; the default is to use TLS
+// over TCP.
+type Dialer interface {
+	// Dial returns a new connection to the pub/sub server at the
+	// address, giving up once the timeout has passed. The tlsConfig is
+	// built from the ConnInfo CertInfo; it is nil if UsesTLS returns
+	// false.
+	Dial(addr string, timeout time.Duration, tlsConfig *tls.Config,
+	) (io.ReadWriteCloser, error)
+
+	// UsesTLS returns true if the connection is made using TLS, in which
+	// case the ConnInfo CertInfo must be set.
+	UsesTLS() bool
+}
+
+// TLSTCPDialer connects to the pub/sub server using TLS over TCP. This is
+// the Dialer used if the ConnInfo Dialer is not set.
+type TLSTCPDialer struct{}
+
+// Dial connects to the pub/sub server at the TCP address using TLS
+func (TLSTCPDialer) Dial(addr string, timeout time.Duration,
+	tlsConfig *tls.Config,
+) (io.ReadWriteCloser, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout},
+		"tcp", addr, tlsConfig)
+}
+
+// UsesTLS returns true
+func (TLSTCPDialer) UsesTLS() bool { return true }
+
+// TLSUnixDialer connects to the pub/sub server using TLS over a Unix domain
+// socket. The address is the path name of the socket.
+//
+// The ServerName is checked against the server's certificate; it should be
+// one of the host names the certificate was issued for. If it is not set the
+// ConnInfo TLS ServerName is used.
+type TLSUnixDialer struct {
+	ServerName string
+}
+
+// Dial connects to the pub/sub server at the Unix socket address using TLS
+func (d TLSUnixDialer) Dial(addr string, timeout time.Duration,
+	tlsConfig *tls.Config,
+) (io.ReadWriteCloser, error) {
+	if d.ServerName != "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = d.ServerName
+	}
+
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout},
+		"unix", addr, tlsConfig)
+}
+
+// UsesTLS returns true
+func (TLSUnixDialer) UsesTLS() bool { return true }
+
+// TCPDialer connects to the pub/sub server over TCP without TLS. The
+// connection is neither encrypted nor authenticated so this should only be
+// used where the network is trusted, for instance, to connect to a server
+// on the local host.
+type TCPDialer struct{}
+
+// Dial connects to the pub/sub server at the TCP address
+func (TCPDialer) Dial(addr string, timeout time.Duration, _ *tls.Config,
+) (io.ReadWriteCloser, error) {
+	return net.DialTimeout("tcp", addr, timeout)
+}
+
+// UsesTLS returns false
+func (TCPDialer) UsesTLS() bool { return false }
+
+// PipeDialer makes an in-memory connection to a pub/sub server in the same
+// program, typically in tests. Each call to Dial creates a new net.Pipe and
+// passes the server's end of it to Serve in a new goroutine. The address
+// and timeout are ignored. For instance, to connect to a pususvr.Server:
+//
+//	info.Dialer = pusuclt.PipeDialer{
+//		Serve: func(conn net.Conn) { _ = svr.ServeConn(conn) },
+//	}
+type PipeDialer struct {
+	Serve func(conn net.Conn)
+}
+
+// Dial returns the client's end of a new net.Pipe
+func (d PipeDialer) Dial(_ string, _ time.Duration, _ *tls.Config,
+) (io.ReadWriteCloser, error) {
+	if d.Serve == nil {
+		return nil, errNoPipeServer
+	}
+
+	clt, svr := net.Pipe()
+
+	go d.Serve(svr)
+
+	return clt, nil
+}
+
+// UsesTLS returns false
+func (PipeDialer) UsesTLS() bool { return false }
@@ -0,0 +1,217 @@
+package pusuclt
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"sync"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+const (
+	// DfltDispatchWorkers is the number of goroutines delivering messages
+	// to the handlers in the ConnInfo returned by NewConnInfo
+	DfltDispatchWorkers = 4
+	// DfltHandlerQueueSize is the number of messages that can be waiting
+	// to be delivered to a handler if the ConnInfo does not give a size
+	DfltHandlerQueueSize = 1000
+)
+
+// Overflow describes a message which was not delivered to a handler
+// because too many earlier messages were still waiting to be delivered to
+// it (see ConnInfo.HandlerQueueSize).
+type Overflow struct {
+	// Topic is the topic subscribed to, it may be a wildcard topic
+	Topic pusu.Topic
+	// SubscriptionID is the ID of the Subscription. Each TopicHandler
+	// given to Subscribe also has a Subscription ID
+	SubscriptionID uint64
+	// Delivery is the message that was not delivered
+	Delivery Delivery
+	// Dropped is the number of messages not delivered to the handler so
+	// far, including this one
+	Dropped uint64
+}
+
+// dispatchJob is a message to be delivered to the handlers
+type dispatchJob struct {
+	d       Delivery
+	entries []*handlerEntry
+}
+
+// dispatchQueue holds the messages waiting to be delivered by a dispatch
+// worker. The queue is not bounded; the number of messages waiting for
+// each handler is limited when they are added.
+type dispatchQueue struct {
+	mtx     sync.Mutex
+	ready   *sync.Cond
+	jobs    []dispatchJob
+	stopped bool
+}
+
+// newDispatchQueue returns a properly instantiated dispatchQueue
+func newDispatchQueue() *dispatchQueue {
+	q := &dispatchQueue{}
+	q.ready = sync.NewCond(&q.mtx)
+
+	return q
+}
+
+// push adds the job to the queue. It is ignored if the queue has been
+// stopped.
+func (q *dispatchQueue) push(j dispatchJob) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.stopped {
+		return
+	}
+
+	q.jobs = append(q.jobs, j)
+	q.ready.Signal()
+}
+
+// stop tells the worker to finish once the jobs already queued have been
+// delivered
+func (q *dispatchQueue) stop() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.stopped = true
+	q.ready.Signal()
+}
+
+// run delivers the queued jobs in turn until the queue is stopped and
+// empty
+func (q *dispatchQueue) run() {
+	for {
+		q.mtx.Lock()
+
+		for len(q.jobs) == 0 && !q.stopped {
+			q.ready.Wait()
+		}
+
+		if len(q.jobs) == 0 {
+			q.mtx.Unlock()
+
+			return
+		}
+
+		j := q.jobs[0]
+		q.jobs[0] = dispatchJob{}
+		q.jobs = q.jobs[1:]
+
+		q.mtx.Unlock()
+
+		for _, he := range j.entries {
+			he.deliver(j.d)
+		}
+	}
+}
+
+// dispatcher delivers the messages received to the handlers. If it has no
+// queues the handlers are called directly, otherwise each message is added
+// to the queues chosen by the topics its handlers subscribed to so that
+// each handler is called by just one worker and gets the messages in the
+// order they were received.
+type dispatcher struct {
+	startOnce sync.Once
+	queues    []*dispatchQueue
+	limit     int64
+	overflow  func(Overflow)
+	logger    func() *slog.Logger
+}
+
+// newDispatcher returns a dispatcher configured from the ConnInfo
+func newDispatcher(info *ConnInfo, logger func() *slog.Logger) *dispatcher {
+	d := &dispatcher{
+		limit:    int64(info.handlerQueueSize()),
+		overflow: info.Overflow,
+		logger:   logger,
+	}
+
+	for range max(info.DispatchWorkers, 0) {
+		d.queues = append(d.queues, newDispatchQueue())
+	}
+
+	return d
+}
+
+// dispatch delivers the message to the handlers. If there are dispatch
+// workers the message is queued and this returns without waiting for the
+// handlers; any handler with too many messages already waiting does not
+// get the message and the Overflow is reported.
+func (disp *dispatcher) dispatch(d Delivery, entries []*handlerEntry) {
+	if len(disp.queues) == 0 {
+		for _, he := range entries {
+			he.h(d)
+		}
+
+		return
+	}
+
+	disp.startOnce.Do(func() {
+		for _, q := range disp.queues {
+			go q.run()
+		}
+	})
+
+	accepted := make([][]*handlerEntry, len(disp.queues))
+
+	for _, he := range entries {
+		if he.pending.Add(1) > disp.limit {
+			he.pending.Add(-1)
+			disp.reportOverflow(he, d)
+
+			continue
+		}
+
+		qIdx := disp.queueIdx(he.topic)
+		accepted[qIdx] = append(accepted[qIdx], he)
+	}
+
+	for qIdx, qEntries := range accepted {
+		if len(qEntries) > 0 {
+			disp.queues[qIdx].push(dispatchJob{d: d, entries: qEntries})
+		}
+	}
+}
+
+// queueIdx returns the index of the queue for handlers subscribed to the
+// topic. This is chosen by the subscribed topic rather than the topic the
+// message was published on so that a handler for a wildcard topic is only
+// ever called by one worker.
+func (disp *dispatcher) queueIdx(t pusu.Topic) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(t))
+
+	return int(h.Sum32() % uint32(len(disp.queues))) //nolint:gosec
+}
+
+// reportOverflow logs the message not delivered to the handler and passes
+// the Overflow to the overflow func, if any
+func (disp *dispatcher) reportOverflow(he *handlerEntry, d Delivery) {
+	o := Overflow{
+		Topic:          he.topic,
+		SubscriptionID: he.key.subID,
+		Delivery:       d,
+		Dropped:        he.dropped.Add(1),
+	}
+
+	disp.logger().Error("the message was not delivered to the handler",
+		d.Topic.Attr(),
+		slog.String(pusu.AttrPfx+"SubscribedTopic", string(o.Topic)),
+		slog.Uint64(pusu.AttrPfx+"Dropped", o.Dropped))
+
+	if disp.overflow != nil {
+		disp.overflow(o)
+	}
+}
+
+// stop tells the dispatch workers to finish once the messages already
+// queued have been delivered
+func (disp *dispatcher) stop() {
+	for _, q := range disp.queues {
+		q.stop()
+	}
+}
@@ -0,0 +1,167 @@
+package pusuclt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestDispatchOverflow(t *testing.T) {
+	const topic = pusu.Topic("/a")
+
+	var overflows []Overflow
+
+	disp := newDispatcher(
+		&ConnInfo{
+			DispatchWorkers:  2,
+			HandlerQueueSize: 2,
+			Overflow: func(o Overflow) {
+				overflows = append(overflows, o)
+			},
+		},
+		func() *slog.Logger {
+			return slog.New(slog.NewTextHandler(io.Discard, nil))
+		})
+	defer disp.stop()
+
+	release := make(chan struct{})
+	delivered := make(chan string, 10)
+
+	hs := newHandlerSet()
+	err := hs.add(topic, handlerKey{subID: 1},
+		func(d Delivery) {
+			<-release
+			delivered <- string(d.Payload)
+		})
+	testhelper.CheckError(t, "adding the handler", err, false, nil)
+
+	for _, p := range []string{"1", "2", "3", "4"} {
+		disp.dispatch(Delivery{Topic: topic, Payload: []byte(p)},
+			hs.appendEntries(nil))
+	}
+
+	close(release)
+
+	var got []string
+
+	for range 2 {
+		select {
+		case p := <-delivered:
+			got = append(got, p)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the messages to be delivered")
+		}
+	}
+
+	if !slices.Equal(got, []string{"1", "2"}) {
+		t.Log("delivered messages")
+		t.Logf("\t: expected: %v\n", []string{"1", "2"})
+		t.Logf("\t:   actual: %v\n", got)
+		t.Error("\t: bad delivery")
+	}
+
+	if testhelper.DiffInt(t, "overflows", "count", len(overflows), 2) {
+		return
+	}
+
+	for i, o := range overflows {
+		id := "overflow " + string(o.Delivery.Payload)
+		testhelper.DiffString(t, id, "topic", o.Topic, topic)
+		testhelper.DiffInt(t, id, "subscription ID", o.SubscriptionID, 1)
+		testhelper.DiffInt(t, id, "dropped", o.Dropped, uint64(i+1))
+	}
+}
+
+func TestDispatchWildcard(t *testing.T) {
+	const (
+		pattern  = pusu.Topic("/a/*")
+		msgCount = 20
+	)
+
+	disp := newDispatcher(
+		&ConnInfo{DispatchWorkers: 8},
+		func() *slog.Logger {
+			return slog.New(slog.NewTextHandler(io.Discard, nil))
+		})
+	defer disp.stop()
+
+	var running, maxRunning atomic.Int32
+
+	delivered := make(chan string, msgCount)
+
+	hs := newHandlerSet()
+	err := hs.add(pattern, handlerKey{subID: 1},
+		func(d Delivery) {
+			n := running.Add(1)
+			if n > maxRunning.Load() {
+				maxRunning.Store(n)
+			}
+
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+			delivered <- string(d.Topic)
+		})
+	testhelper.CheckError(t, "adding the handler", err, false, nil)
+
+	var expected []string
+
+	for i := range msgCount {
+		topic := pusu.Topic(fmt.Sprintf("/a/%d", i))
+		expected = append(expected, string(topic))
+		disp.dispatch(Delivery{Topic: topic}, hs.appendEntries(nil))
+	}
+
+	var got []string
+
+	for range msgCount {
+		select {
+		case tp := <-delivered:
+			got = append(got, tp)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the messages to be delivered")
+		}
+	}
+
+	testhelper.DiffStringSlice(t, "wildcard handler", "delivered topics",
+		got, expected)
+	testhelper.DiffInt(t, "wildcard handler", "concurrent calls",
+		maxRunning.Load(), 1)
+}
+
+func TestDeliverUnlocked(t *testing.T) {
+	const topic = pusu.Topic("/topic")
+
+	cc := makeTestClient(&bytes.Buffer{}, &bytes.Buffer{}, nil, nil)
+
+	var state ConnState
+
+	_, err := cc.addHandler(TopicHandler{
+		Topic:   topic,
+		Handler: func(_ pusu.Topic, _ []byte) { state = cc.State() },
+	})
+	testhelper.CheckError(t, "adding the handler", err, false, nil)
+
+	done := make(chan struct{})
+
+	go func() {
+		cc.callMsgHandlers(topic, []byte("data"))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the handler could not lock the Client")
+	}
+
+	testhelper.DiffString(t, "handler", "state",
+		state.String(), StateDisconnected.String())
+}
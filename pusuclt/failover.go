@@ -0,0 +1,71 @@
+package pusuclt
+
+import (
+	"errors"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+// svrAddresses returns the addresses of the pub/sub servers in the order
+// they should be tried
+func (ci *ConnInfo) svrAddresses() []string {
+	if len(ci.SvrAddresses) == 0 {
+		return []string{ci.SvrAddress}
+	}
+
+	addrs := slices.Clone(ci.SvrAddresses)
+	if ci.ShuffleSvrAddresses {
+		rand.Shuffle(len(addrs), func(i, j int) {
+			addrs[i], addrs[j] = addrs[j], addrs[i]
+		})
+	}
+
+	return addrs
+}
+
+// setServer makes the server with the given index the one to connect to
+// and records its address in the logger. The Client mutex must be held
+// when this is called, unless the Client is not yet in use.
+func (c *Client) setServer(idx int) {
+	c.svrIdx = idx
+	c.logger.Store(c.baseLogger.With(
+		pusu.NetAddressAttr(c.svrAddrs[idx]),
+		c.namespace.Attr()))
+}
+
+// ServerAddress returns the network address of the pub/sub server that the
+// client is connected to or, if it is not connected, the one it last tried
+// to connect to.
+func (c *Client) ServerAddress() string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.svrAddrs[c.svrIdx]
+}
+
+// startAnyConn tries to start a connection to each of the pub/sub servers
+// in turn, starting with the server at the given index, until one
+// succeeds. It returns the error from the last attempt if none succeeds.
+func (c *Client) startAnyConn(first int) error {
+	var err error
+
+	for i := range len(c.svrAddrs) {
+		c.mtx.Lock()
+		c.setServer((first + i) % len(c.svrAddrs))
+		c.mtx.Unlock()
+
+		err = c.startConn()
+		if err == nil || errors.Is(err, errDisconnected) {
+			return err
+		}
+
+		if len(c.svrAddrs) > 1 {
+			c.log().Error("couldn't connect to the pub/sub server",
+				pusu.ErrorAttr(err))
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,55 @@
+package pusuclt
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestSvrAddresses(t *testing.T) {
+	addrs := []string{"a:1", "b:2", "c:3", "d:4"}
+
+	testCases := []struct {
+		testhelper.ID
+		ci       ConnInfo
+		expAddrs []string
+	}{
+		{
+			ID:       testhelper.MkID("single address"),
+			ci:       ConnInfo{SvrAddress: "x:1"},
+			expAddrs: []string{"x:1"},
+		},
+		{
+			ID: testhelper.MkID("list"),
+			ci: ConnInfo{
+				SvrAddress:   "x:1",
+				SvrAddresses: addrs,
+			},
+			expAddrs: addrs,
+		},
+		{
+			ID: testhelper.MkID("shuffled list"),
+			ci: ConnInfo{
+				SvrAddresses:        addrs,
+				ShuffleSvrAddresses: true,
+			},
+			expAddrs: addrs,
+		},
+	}
+
+	for _, tc := range testCases {
+		got := tc.ci.svrAddresses()
+
+		if tc.ci.ShuffleSvrAddresses {
+			slices.Sort(got)
+		}
+
+		testhelper.DiffStringSlice(t, tc.IDStr(), "addresses",
+			got, tc.expAddrs)
+	}
+
+	if !slices.Equal(addrs, []string{"a:1", "b:2", "c:3", "d:4"}) {
+		t.Error("the ConnInfo SvrAddresses were changed:", addrs)
+	}
+}
@@ -2,6 +2,9 @@ package pusuclt
 
 import (
 	"errors"
+	"sync/atomic"
+
+	"github.com/nickwells/pusu.mod/pusu"
 )
 
 var (
@@ -9,9 +12,38 @@ var (
 	errHandlerNotInSet     = errors.New("the handler is not in the handler set")
 )
 
-// handlerIndexes maps between a MsgHandler address and the index into the
+// handlerKey identifies a handler in a handlerSet. The handlers added by
+// the Client are identified by their Subscription's unique ID, those added
+// by addHandler by the address of the MsgHandler function value.
+type handlerKey struct {
+	fnAddr uintptr
+	subID  uint64
+}
+
+// handlerEntry records a handler in a handlerSet together with the count
+// of the messages waiting to be delivered to it by the dispatcher
+type handlerEntry struct {
+	topic   pusu.Topic
+	key     handlerKey
+	h       DeliveryHandler
+	pending atomic.Int64
+	dropped atomic.Uint64
+	removed atomic.Bool
+}
+
+// deliver calls the handler, unless it has been removed from its
+// handlerSet, and counts the message as no longer waiting
+func (he *handlerEntry) deliver(d Delivery) {
+	if !he.removed.Load() {
+		he.h(d)
+	}
+
+	he.pending.Add(-1)
+}
+
+// handlerIndexes maps between a handlerKey and the index into the
 // handlersInOrder slice in the handlerSet struct
-type handlerIndexes map[uintptr]int
+type handlerIndexes map[handlerKey]int
 
 // handlerSet represents the collection of handler funcs for messages
 // received on a Topic. This allows us to identify whether or not the
@@ -24,8 +56,8 @@ type handlerSet struct {
 	// the slice and the resulting last entry is checked to see if it is nil
 	// and if so that is deleted too and so on. When the slice is empty the
 	// Unsubscribe message is sent to the pub/sub server
-	handlersInOrder []MsgHandler
-	// handlerMap gives the index in the slice for the MsgHandler
+	handlersInOrder []*handlerEntry
+	// handlerMap gives the index in the slice for the handler
 	// . Unsubscribing will use this entry to find the slice entry to set to
 	// nil and then the map entry will be deleted
 	handlerMap handlerIndexes
@@ -34,7 +66,7 @@ type handlerSet struct {
 // newHandlerSet returns a properly instantiated handlerSet
 func newHandlerSet() *handlerSet {
 	return &handlerSet{
-		handlersInOrder: []MsgHandler{},
+		handlersInOrder: []*handlerEntry{},
 		handlerMap:      make(handlerIndexes),
 	}
 }
@@ -67,34 +99,71 @@ func (hs *handlerSet) removeTrailingNils() {
 	hs.handlersInOrder = hs.handlersInOrder[:len(hs.handlersInOrder)-count]
 }
 
-// addHandler adds the handler to the handlerSet. It returns a non-nil error
-// if the handler is already in the handler map.
+// addHandler adds the handler to the handlerSet identified by its
+// address. It returns a non-nil error if the handler is already in the
+// handler map.
 func (hs *handlerSet) addHandler(h MsgHandler) error {
-	hID := h.id()
+	return hs.add("", handlerKey{fnAddr: h.id()},
+		TopicHandler{Handler: h}.deliveryHandler())
+}
+
+// removeHandler removes the handler identified by its address from the
+// handlerSet. It returns a non-nil error if the handler is not found in the
+// handler map.
+func (hs *handlerSet) removeHandler(h MsgHandler) error {
+	return hs.remove(handlerKey{fnAddr: h.id()})
+}
 
-	if _, ok := hs.handlerMap[hID]; ok {
+// add adds the handler for the topic to the handlerSet identified by the
+// key. It returns a non-nil error if the key is already in the handler map.
+func (hs *handlerSet) add(t pusu.Topic, k handlerKey, h DeliveryHandler,
+) error {
+	if _, ok := hs.handlerMap[k]; ok {
 		return errHandlerAlreadyAdded
 	}
 
-	hs.handlerMap[hID] = len(hs.handlersInOrder)
-	hs.handlersInOrder = append(hs.handlersInOrder, h)
+	hs.handlerMap[k] = len(hs.handlersInOrder)
+	hs.handlersInOrder = append(hs.handlersInOrder,
+		&handlerEntry{topic: t, key: k, h: h})
 
 	return nil
 }
 
-// removeHandler removes the identified handler from the handlerSet. It
-// returns a non-nil error if the handler is not found in the handler map.
-func (hs *handlerSet) removeHandler(h MsgHandler) error {
-	hID := h.id()
-
-	hIdx, ok := hs.handlerMap[hID]
+// remove removes the handler identified by the key from the handlerSet. It
+// returns a non-nil error if the key is not found in the handler map.
+func (hs *handlerSet) remove(k handlerKey) error {
+	hIdx, ok := hs.handlerMap[k]
 	if !ok {
 		return errHandlerNotInSet
 	}
 
-	delete(hs.handlerMap, hID)
+	delete(hs.handlerMap, k)
+	hs.handlersInOrder[hIdx].removed.Store(true)
 	hs.handlersInOrder[hIdx] = nil
 	hs.removeTrailingNils()
 
 	return nil
 }
+
+// entry returns the entry for the handler identified by the key or nil if
+// it is not in the handlerSet
+func (hs *handlerSet) entry(k handlerKey) *handlerEntry {
+	hIdx, ok := hs.handlerMap[k]
+	if !ok {
+		return nil
+	}
+
+	return hs.handlersInOrder[hIdx]
+}
+
+// appendEntries appends the handler entries, in the order they were added,
+// to the slice and returns it
+func (hs *handlerSet) appendEntries(entries []*handlerEntry) []*handlerEntry {
+	for _, he := range hs.handlersInOrder {
+		if he != nil {
+			entries = append(entries, he)
+		}
+	}
+
+	return entries
+}
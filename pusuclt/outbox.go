@@ -0,0 +1,435 @@
+package pusuclt
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+const (
+	// DfltOutboxMaxMessages is the number of messages the outbox can hold
+	// if the OutboxConfig does not give a number
+	DfltOutboxMaxMessages = 1000
+	// spillHdrLen is the length of the header written to the SpillFile
+	// before each message; the message ID, the time it was added and the
+	// payload length
+	spillHdrLen = 8 + 8 + 4
+)
+
+var (
+	// ErrOutboxFull is returned when a message cannot be added to the
+	// outbox because it already holds OutboxConfig.MaxMessages messages
+	ErrOutboxFull = errors.New("the outbox is full")
+	// ErrOutboxExpired is passed to the Callback of a message which was
+	// held in the outbox for longer than OutboxConfig.MaxAge. The message
+	// is not sent.
+	ErrOutboxExpired = errors.New("the message expired in the outbox")
+)
+
+// OutboxConfig gives the details of the outbox in which Publish messages
+// are held while the client is reconnecting to the pub/sub server. Publish
+// messages still waiting to be sent when the connection is lost are also
+// put in the outbox. The messages are sent, in the order they were published, once the Start
+// message on the new connection has been acknowledged and each Callback is
+// called when the reply to its message arrives.
+type OutboxConfig struct {
+	// MaxMessages gives the largest number of messages that the outbox
+	// can hold, after which Publish returns ErrOutboxFull. If it is not
+	// greater than zero DfltOutboxMaxMessages is used.
+	MaxMessages int
+
+	// MaxAge gives the longest time that a message can be held in the
+	// outbox. Older messages are not sent and their Callbacks are called
+	// with ErrOutboxExpired. If it is not greater than zero the messages
+	// do not expire.
+	MaxAge time.Duration
+
+	// SpillFile, if set, gives the name of a file to which messages are
+	// written once MaxInMemory messages are held in memory. Any existing
+	// file is overwritten and the file is removed once the messages have
+	// been read back.
+	SpillFile string
+
+	// MaxInMemory gives the number of messages held in memory before
+	// later messages are written to the SpillFile. It is ignored if there
+	// is no SpillFile.
+	MaxInMemory int
+}
+
+// maxMessages returns the largest number of messages the outbox can hold
+func (oc OutboxConfig) maxMessages() int {
+	if oc.MaxMessages <= 0 {
+		return DfltOutboxMaxMessages
+	}
+
+	return oc.MaxMessages
+}
+
+// outboxEntry is a Publish message held in the outbox. The message ID is
+// given when the message is published.
+type outboxEntry struct {
+	id      pusu.MsgID
+	payload []byte
+	added   time.Time
+}
+
+// outbox holds the Publish messages waiting to be sent. The oldest
+// messages are held in memory; once the SpillFile has any messages in it
+// all the later messages are written there, so that the order is kept,
+// until it has been read back.
+type outbox struct {
+	cfg     OutboxConfig
+	mem     []outboxEntry
+	spilled int
+	cbs     map[pusu.MsgID]Callback
+}
+
+// newOutbox returns an outbox with the given configuration or nil if the
+// configuration is nil
+func newOutbox(cfg *OutboxConfig) *outbox {
+	if cfg == nil {
+		return nil
+	}
+
+	return &outbox{
+		cfg: *cfg,
+		cbs: make(map[pusu.MsgID]Callback),
+	}
+}
+
+// len returns the number of messages in the outbox
+func (ob *outbox) len() int {
+	return len(ob.mem) + ob.spilled
+}
+
+// add adds the message to the outbox, writing it to the SpillFile if
+// there are already enough messages in memory. It returns a non-nil error
+// if the outbox is full or the message could not be written.
+func (ob *outbox) add(e outboxEntry, cb Callback) error {
+	if ob.len() >= ob.cfg.maxMessages() {
+		return ErrOutboxFull
+	}
+
+	if ob.cfg.SpillFile != "" &&
+		(ob.spilled > 0 || len(ob.mem) >= ob.cfg.MaxInMemory) {
+		if err := ob.spill(e); err != nil {
+			return err
+		}
+	} else {
+		ob.mem = append(ob.mem, e)
+	}
+
+	if cb != nil {
+		ob.cbs[e.id] = cb
+	}
+
+	return nil
+}
+
+// spill appends the entry to the SpillFile. The file is truncated when the
+// first entry is written so that anything left in it, for instance by an
+// earlier program which did not finish, is not read back.
+func (ob *outbox) spill(e outboxEntry) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if ob.spilled == 0 {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(ob.cfg.SpillFile, flags, 0o600)
+	if err != nil {
+		return fmt.Errorf("couldn't open the outbox spill file: %w", err)
+	}
+
+	rec := make([]byte, 0, spillHdrLen+len(e.payload))
+	rec = binary.BigEndian.AppendUint64(rec, uint64(e.id)) //nolint:gosec
+	rec = binary.BigEndian.AppendUint64(rec,
+		uint64(e.added.UnixNano())) //nolint:gosec
+	rec = binary.BigEndian.AppendUint32(rec,
+		uint32(len(e.payload))) //nolint:gosec
+	rec = append(rec, e.payload...)
+
+	_, err = f.Write(rec)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("couldn't write to the outbox spill file: %w", err)
+	}
+
+	ob.spilled++
+
+	return nil
+}
+
+// readSpilled reads the entries back from the SpillFile and removes it
+func (ob *outbox) readSpilled() ([]outboxEntry, error) {
+	f, err := os.Open(ob.cfg.SpillFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open the outbox spill file: %w", err)
+	}
+
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(ob.cfg.SpillFile)
+		ob.spilled = 0
+	}()
+
+	r := bufio.NewReader(f)
+	entries := make([]outboxEntry, 0, ob.spilled)
+	hdr := make([]byte, spillHdrLen)
+
+	for range ob.spilled {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return entries,
+				fmt.Errorf("couldn't read the outbox spill file: %w", err)
+		}
+
+		e := outboxEntry{
+			id: pusu.MsgID(binary.BigEndian.Uint64(hdr)), //nolint:gosec
+			added: time.Unix(0,
+				int64(binary.BigEndian.Uint64(hdr[8:]))), //nolint:gosec
+			payload: make([]byte, binary.BigEndian.Uint32(hdr[16:])),
+		}
+
+		if _, err := io.ReadFull(r, e.payload); err != nil {
+			return entries,
+				fmt.Errorf("couldn't read the outbox spill file: %w", err)
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// takeBatch removes and returns the oldest messages in the outbox; those in
+// memory or, if there are none, those in the SpillFile. If the SpillFile
+// cannot be read the Callbacks of the messages lost are returned with the
+// error.
+func (ob *outbox) takeBatch() ([]outboxEntry, []Callback, error) {
+	if len(ob.mem) > 0 {
+		batch := ob.mem
+		ob.mem = nil
+
+		return batch, nil, nil
+	}
+
+	if ob.spilled == 0 {
+		return nil, nil, nil
+	}
+
+	batch, err := ob.readSpilled()
+	if err == nil {
+		return batch, nil, nil
+	}
+
+	// the memory is empty so the remaining Callbacks are those of the
+	// spilled messages; any not read back are lost
+	read := make(map[pusu.MsgID]bool, len(batch))
+	for _, e := range batch {
+		read[e.id] = true
+	}
+
+	var lost []Callback
+
+	for id, cb := range ob.cbs {
+		if !read[id] {
+			delete(ob.cbs, id)
+			lost = append(lost, cb)
+		}
+	}
+
+	return batch, lost, err
+}
+
+// requeue puts the entries back in the outbox. They are older than any
+// message in the SpillFile so they are added to those in memory which are
+// then kept in the order they were published.
+func (ob *outbox) requeue(entries []outboxEntry) {
+	ob.mem = append(slices.Clone(entries), ob.mem...)
+	slices.SortStableFunc(ob.mem, func(a, b outboxEntry) int {
+		return cmp.Compare(a.id, b.id)
+	})
+}
+
+// takeCallback removes the Callback for the message from the outbox and
+// returns it. It returns nil if there is no Callback for the message.
+func (ob *outbox) takeCallback(id pusu.MsgID) Callback {
+	cb, ok := ob.cbs[id]
+	if ok {
+		delete(ob.cbs, id)
+	}
+
+	return cb
+}
+
+// discard empties the outbox and returns the Callbacks of the messages it
+// held
+func (ob *outbox) discard() []Callback {
+	cbs := make([]Callback, 0, len(ob.cbs))
+	for _, cb := range ob.cbs {
+		cbs = append(cbs, cb)
+	}
+
+	clear(ob.cbs)
+	ob.mem = nil
+
+	if ob.spilled > 0 {
+		_ = os.Remove(ob.cfg.SpillFile)
+		ob.spilled = 0
+	}
+
+	return cbs
+}
+
+// outboxAccepts returns true if a Publish message should be held in the
+// outbox rather than sent; while the client is reconnecting or while the
+// messages already held are being sent. The Client mutex must be held when
+// this is called.
+func (c *Client) outboxAccepts() bool {
+	return c.outbox != nil &&
+		!c.closing && !c.disconnecting &&
+		(c.reconnecting || c.replaying)
+}
+
+// hold gives the Publish message the next message ID and adds it to the
+// outbox. The Client mutex must be held when this is called.
+func (c *Client) hold(payload []byte, cb Callback) (outMsg, error) {
+	msg := &pusu.Message{
+		MT:      pusu.Publish,
+		MsgID:   c.nextMsgID(),
+		Payload: payload,
+	}
+
+	err := c.outbox.add(
+		outboxEntry{id: msg.MsgID, payload: payload, added: time.Now()}, cb)
+	if err != nil {
+		return outMsg{}, err
+	}
+
+	return outMsg{msg: msg, held: true}, nil
+}
+
+// replayOutbox sends the messages held in the outbox, in the order they
+// were published, until it is empty or the connection is lost. Messages
+// held for longer than the MaxAge are not sent and their Callbacks are
+// called with ErrOutboxExpired. The Client mutex must not be held when
+// this is called.
+func (c *Client) replayOutbox() {
+	for {
+		c.mtx.Lock()
+
+		if !c.replaying {
+			c.mtx.Unlock()
+
+			return
+		}
+
+		batch, lost, err := c.outbox.takeBatch()
+		if err != nil {
+			c.log().Error("outbox messages lost", pusu.ErrorAttr(err),
+				slog.Int("count", len(lost)))
+
+			for _, cb := range lost {
+				go cb(err)
+			}
+		}
+
+		if len(batch) == 0 {
+			c.replaying = false
+			c.mtx.Unlock()
+
+			return
+		}
+
+		c.log().Info("replaying the outbox", slog.Int("count", len(batch)))
+		c.mtx.Unlock()
+
+		if !c.replayBatch(batch) {
+			return
+		}
+	}
+}
+
+// replayBatch sends the entries taken from the outbox. It returns false if
+// they could not all be sent, in which case those remaining are put back
+// in the outbox.
+func (c *Client) replayBatch(batch []outboxEntry) bool {
+	for i, e := range batch {
+		c.mtx.Lock()
+
+		if !c.replaying {
+			if c.state != StateClosed {
+				c.outbox.requeue(batch[i:])
+			}
+
+			c.mtx.Unlock()
+
+			return false
+		}
+
+		cb := c.outbox.takeCallback(e.id)
+
+		if maxAge := c.outbox.cfg.MaxAge; maxAge > 0 &&
+			time.Since(e.added) > maxAge {
+			c.log().Error("the message expired in the outbox",
+				e.id.Attr(), pusu.ErrorAttr(ErrOutboxExpired))
+
+			if cb != nil {
+				go cb(ErrOutboxExpired)
+			}
+
+			c.mtx.Unlock()
+
+			continue
+		}
+
+		c.addCallback(e.id, cb)
+		queue, runExit := c.sendChan, c.runExit
+		c.mtx.Unlock()
+
+		select {
+		case queue <- &pusu.Message{
+			MT:      pusu.Publish,
+			MsgID:   e.id,
+			Payload: e.payload,
+		}:
+		case <-runExit:
+			c.mtx.Lock()
+			defer c.mtx.Unlock()
+
+			c.replaying = false
+
+			if c.state == StateClosed {
+				return false
+			}
+
+			// a Callback that has gone was called when the connection was
+			// lost so its message is not sent again
+			rest := batch[i+1:]
+			if cb == nil || c.getCallback(e.id) != nil {
+				rest = batch[i:]
+
+				if cb != nil {
+					c.outbox.cbs[e.id] = cb
+				}
+			}
+
+			c.outbox.requeue(rest)
+
+			return false
+		}
+	}
+
+	return true
+}
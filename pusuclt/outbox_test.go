@@ -0,0 +1,213 @@
+package pusuclt
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOutbox(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		cfg       OutboxConfig
+		spill     bool
+		staleFile bool
+		msgCount  int
+		expMsgIDs []pusu.MsgID
+	}{
+		{
+			ID:        testhelper.MkID("in memory"),
+			cfg:       OutboxConfig{MaxMessages: 5},
+			msgCount:  3,
+			expMsgIDs: []pusu.MsgID{1, 2, 3},
+		},
+		{
+			ID:        testhelper.MkID("spilled"),
+			cfg:       OutboxConfig{MaxMessages: 5, MaxInMemory: 2},
+			spill:     true,
+			msgCount:  5,
+			expMsgIDs: []pusu.MsgID{1, 2, 3, 4, 5},
+		},
+		{
+			ID:        testhelper.MkID("spilled - stale file"),
+			cfg:       OutboxConfig{MaxMessages: 5, MaxInMemory: 2},
+			spill:     true,
+			staleFile: true,
+			msgCount:  5,
+			expMsgIDs: []pusu.MsgID{1, 2, 3, 4, 5},
+		},
+		{
+			ID:        testhelper.MkID("full"),
+			ExpErr:    testhelper.MkExpErr("the outbox is full"),
+			cfg:       OutboxConfig{MaxMessages: 2},
+			msgCount:  3,
+			expMsgIDs: []pusu.MsgID{1, 2},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if tc.spill {
+				tc.cfg.SpillFile = filepath.Join(t.TempDir(), "outbox")
+			}
+
+			ob := newOutbox(&tc.cfg)
+			added := time.Now()
+
+			if tc.staleFile {
+				stale := newOutbox(&tc.cfg)
+				if err := stale.spill(outboxEntry{
+					id:      99,
+					payload: []byte("STALE"),
+					added:   added,
+				}); err != nil {
+					t.Fatal("couldn't write the stale spill file:", err)
+				}
+			}
+
+			var err error
+
+			for i := range tc.msgCount {
+				id := pusu.MsgID(i + 1) //nolint:gosec
+				if err = ob.add(outboxEntry{
+					id:      id,
+					payload: []byte{byte(i)},
+					added:   added,
+				}, func(error) {}); err != nil {
+					break
+				}
+			}
+
+			testhelper.CheckExpErr(t, err, tc)
+
+			var ids []pusu.MsgID
+
+			for {
+				batch, _, err := ob.takeBatch()
+				if err != nil {
+					t.Fatal("couldn't take a batch from the outbox:", err)
+				}
+
+				if len(batch) == 0 {
+					break
+				}
+
+				for _, e := range batch {
+					ids = append(ids, e.id)
+
+					testhelper.DiffInt(t, tc.IDStr(), "payload",
+						int(e.payload[0]), int(e.id)-1)
+					testhelper.DiffTime(t, tc.IDStr(), "time added",
+						e.added, added)
+				}
+			}
+
+			testhelper.DiffSlice(t, tc.IDStr(), "message IDs",
+				ids, tc.expMsgIDs)
+			testhelper.DiffInt(t, tc.IDStr(), "remaining", ob.len(), 0)
+			testhelper.DiffInt(t, tc.IDStr(), "Callbacks",
+				len(ob.discard()), len(tc.expMsgIDs))
+		})
+	}
+}
+
+func TestOutboxClient(t *testing.T) {
+	cc := makeTestClient(&bytes.Buffer{}, nil, nil, nil)
+	cc.outbox = newOutbox(&OutboxConfig{})
+
+	err := cc.Publish(nil, "/a", []byte("not held"))
+	if !errors.Is(err, errNoConn) {
+		t.Errorf("Publish while not connected: unexpected error: %v", err)
+	}
+
+	cc.reconnecting = true
+
+	errChan := make(chan error, 1)
+
+	err = cc.Publish(func(err error) { errChan <- err }, "/a", []byte("held"))
+	testhelper.CheckError(t, "Publish while reconnecting", err, false, nil)
+	testhelper.DiffInt(t, "outbox", "length", cc.outbox.len(), 1)
+
+	cc.mtx.Lock()
+	cc.setClosed(nil)
+	cc.mtx.Unlock()
+
+	select {
+	case err = <-errChan:
+	case <-time.After(time.Second):
+	}
+
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("Callback after closing: unexpected error: %v", err)
+	}
+
+	testhelper.DiffInt(t, "outbox", "length after closing", cc.outbox.len(), 0)
+}
+
+func TestOutboxConnLost(t *testing.T) {
+	cc := makeTestClient(
+		&bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}, nil)
+	cc.outbox = newOutbox(&OutboxConfig{})
+	cc.cci.Reconnect = &ReconnectPolicy{InitialBackoff: time.Hour}
+	cc.sendChan = make(chan *pusu.Message, 2)
+	cc.runExit = make(chan struct{})
+	cc.started = true
+
+	errChan := make(chan error, 2)
+
+	for _, p := range []string{"first", "second"} {
+		err := cc.Publish(func(err error) { errChan <- err }, "/a", []byte(p))
+		testhelper.CheckError(t, "Publish "+p, err, false, nil)
+	}
+
+	cc.close(errors.New("connection lost"))
+
+	testhelper.DiffString(t, "client", "state",
+		cc.State().String(), StateReconnecting.String())
+	testhelper.DiffInt(t, "outbox", "length", cc.outbox.len(), 2)
+
+	select {
+	case err := <-errChan:
+		t.Errorf("a held Publish was failed: %v", err)
+	default:
+	}
+
+	batch, _, err := cc.outbox.takeBatch()
+	testhelper.CheckError(t, "taking the outbox batch", err, false, nil)
+
+	var payloads []string
+	for _, e := range batch {
+		var pmp pusu.PublishMsgPayload
+		if err := proto.Unmarshal(e.payload, &pmp); err != nil {
+			t.Fatal("couldn't unmarshal the held Publish:", err)
+		}
+
+		payloads = append(payloads, string(pmp.Payload))
+	}
+
+	testhelper.DiffStringSlice(t, "outbox", "payloads",
+		payloads, []string{"first", "second"})
+
+	cc.outbox.requeue(batch)
+
+	err = cc.Close(t.Context())
+	testhelper.CheckError(t, "Close", err, false, nil)
+
+	for range 2 {
+		select {
+		case err := <-errChan:
+			if !errors.Is(err, ErrClosed) {
+				t.Errorf("Callback after closing: unexpected error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("the Callback of a held Publish was not called")
+		}
+	}
+}
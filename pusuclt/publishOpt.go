@@ -0,0 +1,42 @@
+package pusuclt
+
+import (
+	"maps"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+// PublishOpt is the type of an option that can be passed to Publish (or
+// PublishCtx) to change the message sent to the pub/sub server
+type PublishOpt func(*pusu.PublishMsgPayload) error
+
+// WithRetain returns a PublishOpt that asks the pub/sub server to keep the
+// payload as the last value for the topic. Any client subsequently
+// subscribing to the topic will be sent the retained value as soon as its
+// subscription is acknowledged. The value is only given to the handlers
+// being subscribed; a handler added for a topic which the client is already
+// subscribed to is given the latest retained value that the client has
+// received. The retained value is replaced by the next retained
+// publication on the topic and can be discarded with ClearRetained.
+func WithRetain() PublishOpt {
+	return func(pmp *pusu.PublishMsgPayload) error {
+		pmp.Retain = true
+
+		return nil
+	}
+}
+
+// WithHeaders returns a PublishOpt that adds the headers to the message. The
+// headers are passed to subscribers in the Delivery given to a
+// DeliveryHandler. Any header already set with the same name is replaced.
+func WithHeaders(headers map[string]string) PublishOpt {
+	return func(pmp *pusu.PublishMsgPayload) error {
+		if pmp.Headers == nil {
+			pmp.Headers = make(map[string]string, len(headers))
+		}
+
+		maps.Copy(pmp.Headers, headers)
+
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+package pusuclt
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPublishOpts(t *testing.T) {
+	const errText = "test option error"
+
+	badOpt := func(_ *pusu.PublishMsgPayload) error {
+		return errors.New(errText)
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		f          func(*Client) error
+		expRetain  bool
+		expClear   bool
+		expPayload string
+		expHeaders map[string]string
+		noCaps     bool
+	}{
+		{
+			ID: testhelper.MkID("no options"),
+			f: func(cc *Client) error {
+				return cc.PublishCtx(t.Context(), "/topic", []byte("data"))
+			},
+			expPayload: "data",
+		},
+		{
+			ID: testhelper.MkID("WithRetain"),
+			f: func(cc *Client) error {
+				return cc.PublishCtx(t.Context(), "/topic", []byte("data"),
+					WithRetain())
+			},
+			expRetain:  true,
+			expPayload: "data",
+		},
+		{
+			ID: testhelper.MkID("WithHeaders"),
+			f: func(cc *Client) error {
+				return cc.PublishCtx(t.Context(), "/topic", []byte("data"),
+					WithHeaders(map[string]string{"a": "1", "b": "2"}),
+					WithHeaders(map[string]string{"b": "3"}))
+			},
+			expPayload: "data",
+			expHeaders: map[string]string{"a": "1", "b": "3"},
+		},
+		{
+			ID: testhelper.MkID("ClearRetained"),
+			f: func(cc *Client) error {
+				return cc.ClearRetainedCtx(t.Context(), "/topic")
+			},
+			expClear: true,
+		},
+		{
+			ID: testhelper.MkID("WithRetain - not supported"),
+			ExpErr: testhelper.MkExpErr("retaining a publication",
+				`is not supported`, `(capability: "retain")`),
+			noCaps: true,
+			f: func(cc *Client) error {
+				return cc.Publish(nil, "/topic", []byte("data"),
+					WithRetain())
+			},
+		},
+		{
+			ID: testhelper.MkID("WithHeaders - not supported"),
+			ExpErr: testhelper.MkExpErr("publishing with headers",
+				`is not supported`, `(capability: "headers")`),
+			noCaps: true,
+			f: func(cc *Client) error {
+				return cc.Publish(nil, "/topic", []byte("data"),
+					WithHeaders(map[string]string{"a": "1"}))
+			},
+		},
+		{
+			ID: testhelper.MkID("large message - not supported"),
+			ExpErr: testhelper.MkExpErr("publishing a large message",
+				`is not supported`, `(capability: "fragments")`),
+			noCaps: true,
+			f: func(cc *Client) error {
+				return cc.Publish(nil, "/topic",
+					make([]byte, pusu.MaxMessagePayload))
+			},
+		},
+		{
+			ID:     testhelper.MkID("bad option"),
+			ExpErr: testhelper.MkExpErr(errText),
+			f: func(cc *Client) error {
+				return cc.Publish(nil, "/topic", []byte("data"),
+					WithRetain(), badOpt)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			cc := makeTestClient(&bytes.Buffer{}, &bytes.Buffer{}, nil, nil)
+			cc.runExit = make(chan struct{})
+
+			if tc.noCaps {
+				cc.svrInfo = ServerInfo{ProtoVsn: 1}
+			}
+
+			var pmp pusu.PublishMsgPayload
+
+			go fakeServer(cc, func(cc *Client, msg *pusu.Message) {
+				if err := proto.Unmarshal(msg.Payload, &pmp); err != nil {
+					cc.callback(msg.MsgID, err)
+					return
+				}
+
+				cc.callback(msg.MsgID, nil)
+			})
+			defer close(cc.sendChan)
+
+			err := tc.f(cc)
+			if !testhelper.CheckExpErr(t, err, tc) || err != nil {
+				return
+			}
+
+			testhelper.DiffString(t, tc.IDStr(), "topic", pmp.Topic, "/topic")
+			testhelper.DiffString(t, tc.IDStr(), "payload",
+				string(pmp.Payload), tc.expPayload)
+			testhelper.DiffBool(t, tc.IDStr(), "retain",
+				pmp.Retain, tc.expRetain)
+			testhelper.DiffBool(t, tc.IDStr(), "clear retained",
+				pmp.ClearRetained, tc.expClear)
+			if !maps.Equal(pmp.Headers, tc.expHeaders) {
+				t.Log(tc.IDStr())
+				t.Logf("\t: expected headers: %v\n", tc.expHeaders)
+				t.Logf("\t:   actual headers: %v\n", pmp.Headers)
+				t.Error("\t: bad headers")
+			}
+
+			if pmp.PublishTime == nil {
+				t.Log(tc.IDStr())
+				t.Error("\t: the publish time should be set")
+			}
+		})
+	}
+}
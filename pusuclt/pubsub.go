@@ -0,0 +1,48 @@
+package pusuclt
+
+import (
+	"context"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+// Publisher is the interface for publishing messages. It is satisfied by
+// *Client and allows code which only publishes to be tested without a
+// connection to a pub/sub server (see [github.com/nickwells/pusu.mod/pusutest]
+// for a mock implementation).
+type Publisher interface {
+	Publish(cb Callback, topic pusu.Topic, payload []byte,
+		opts ...PublishOpt) error
+	TryPublish(cb Callback, topic pusu.Topic, payload []byte,
+		opts ...PublishOpt) error
+	PublishCtx(ctx context.Context, topic pusu.Topic, payload []byte,
+		opts ...PublishOpt) error
+}
+
+// Subscriber is the interface for subscribing to topics. It is satisfied by
+// *Client.
+type Subscriber interface {
+	Subscribe(cb Callback, handlers ...TopicHandler) error
+	SubscribeCtx(ctx context.Context, handlers ...TopicHandler) error
+	Unsubscribe(cb Callback, handlers ...TopicHandler) error
+	UnsubscribeCtx(ctx context.Context, handlers ...TopicHandler) error
+}
+
+// DecodeErrorReporter is implemented by a Subscriber which is told of the
+// messages that a handler subscribed with SubscribeTyped could not decode.
+// It is satisfied by *Client.
+type DecodeErrorReporter interface {
+	DecodeError(d Delivery, err error)
+}
+
+// PubSub combines the Publisher and Subscriber interfaces. It is satisfied
+// by *Client.
+type PubSub interface {
+	Publisher
+	Subscriber
+}
+
+var (
+	_ PubSub              = (*Client)(nil)
+	_ DecodeErrorReporter = (*Client)(nil)
+)
@@ -0,0 +1,78 @@
+package pusuclt
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// ReconnectPolicy describes how a Client should try to reconnect to the
+// pub/sub server after the connection has been lost. The wait before each
+// attempt starts at the InitialBackoff and doubles after each failed
+// attempt up to the MaxBackoff. Each wait is then varied randomly by up to
+// the Jitter fraction so that many clients losing their connections at the
+// same time do not all try to reconnect together.
+type ReconnectPolicy struct {
+	InitialBackoff time.Duration // the wait before the first attempt
+	MaxBackoff     time.Duration // the longest wait between attempts
+	Jitter         float64       // the fraction (0.0-1.0) to vary waits by
+	MaxAttempts    int           // the number of attempts, 0 means no limit
+}
+
+// NewReconnectPolicy returns a default ReconnectPolicy. It will try to
+// reconnect indefinitely.
+func NewReconnectPolicy() *ReconnectPolicy {
+	const (
+		dfltInitialBackoff = 100 * time.Millisecond
+		dfltMaxBackoff     = 30 * time.Second
+		dfltJitter         = 0.2
+	)
+
+	return &ReconnectPolicy{
+		InitialBackoff: dfltInitialBackoff,
+		MaxBackoff:     dfltMaxBackoff,
+		Jitter:         dfltJitter,
+	}
+}
+
+// moreAttempts returns true if the policy allows the given attempt (the
+// first attempt is attempt 1)
+func (rp ReconnectPolicy) moreAttempts(attempt int) bool {
+	return rp.MaxAttempts <= 0 || attempt <= rp.MaxAttempts
+}
+
+// baseBackoff returns the wait before the given attempt (the first attempt
+// is attempt 1) before any jitter is applied
+func (rp ReconnectPolicy) baseBackoff(attempt int) time.Duration {
+	limit := rp.MaxBackoff
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+
+	wait := min(rp.InitialBackoff, limit)
+
+	for i := 1; i < attempt && wait < limit; i++ {
+		if wait > limit/2 {
+			return limit
+		}
+
+		wait *= 2
+	}
+
+	return wait
+}
+
+// backoff returns the wait before the given attempt (the first attempt is
+// attempt 1) with the jitter applied
+func (rp ReconnectPolicy) backoff(attempt int) time.Duration {
+	wait := rp.baseBackoff(attempt)
+
+	jitter := min(max(rp.Jitter, 0), 1)
+	if jitter == 0 {
+		return wait
+	}
+
+	variation := jitter * (2*rand.Float64() - 1) //nolint:gosec
+
+	return time.Duration(float64(wait) * (1 + variation))
+}
@@ -0,0 +1,109 @@
+package pusuclt
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestReconnectPolicyBackoff(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		rp          ReconnectPolicy
+		attempt     int
+		expBackoff  time.Duration
+		expMoreAtts bool
+	}{
+		{
+			ID: testhelper.MkID("first attempt"),
+			rp: ReconnectPolicy{
+				InitialBackoff: time.Second,
+				MaxBackoff:     time.Minute,
+			},
+			attempt:     1,
+			expBackoff:  time.Second,
+			expMoreAtts: true,
+		},
+		{
+			ID: testhelper.MkID("third attempt - doubled twice"),
+			rp: ReconnectPolicy{
+				InitialBackoff: time.Second,
+				MaxBackoff:     time.Minute,
+			},
+			attempt:     3,
+			expBackoff:  4 * time.Second,
+			expMoreAtts: true,
+		},
+		{
+			ID: testhelper.MkID("many attempts - limited by MaxBackoff"),
+			rp: ReconnectPolicy{
+				InitialBackoff: time.Second,
+				MaxBackoff:     time.Minute,
+			},
+			attempt:     100,
+			expBackoff:  time.Minute,
+			expMoreAtts: true,
+		},
+		{
+			ID: testhelper.MkID("many attempts - no MaxBackoff"),
+			rp: ReconnectPolicy{
+				InitialBackoff: time.Second,
+			},
+			attempt:     100,
+			expBackoff:  math.MaxInt64,
+			expMoreAtts: true,
+		},
+		{
+			ID: testhelper.MkID("last allowed attempt"),
+			rp: ReconnectPolicy{
+				InitialBackoff: time.Second,
+				MaxBackoff:     time.Minute,
+				MaxAttempts:    3,
+			},
+			attempt:     3,
+			expBackoff:  4 * time.Second,
+			expMoreAtts: true,
+		},
+		{
+			ID: testhelper.MkID("too many attempts"),
+			rp: ReconnectPolicy{
+				InitialBackoff: time.Second,
+				MaxBackoff:     time.Minute,
+				MaxAttempts:    3,
+			},
+			attempt:     4,
+			expBackoff:  8 * time.Second,
+			expMoreAtts: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			testhelper.DiffInt(t, tc.IDStr(), "backoff",
+				tc.rp.backoff(tc.attempt), tc.expBackoff)
+			testhelper.DiffBool(t, tc.IDStr(), "more attempts",
+				tc.rp.moreAttempts(tc.attempt), tc.expMoreAtts)
+		})
+	}
+}
+
+func TestReconnectPolicyJitter(t *testing.T) {
+	rp := ReconnectPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		Jitter:         0.5,
+	}
+
+	const attempts = 100
+
+	for i := range attempts {
+		wait := rp.backoff(1)
+		if wait < time.Second/2 || wait > 3*time.Second/2 {
+			t.Logf("attempt %d", i)
+			t.Logf("\t: wait: %s", wait)
+			t.Error("\t: the jittered wait should be within 50% of 1s")
+		}
+	}
+}
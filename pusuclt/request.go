@@ -0,0 +1,177 @@
+package pusuclt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+const (
+	// HdrReplyTo is the header giving the topic on which the reply to a
+	// request should be published
+	HdrReplyTo = "pusu-reply-to"
+	// HdrCorrelationID is the header used to match a reply to its request
+	HdrCorrelationID = "pusu-correlation-id"
+	// HdrReplyError is the header set on a reply if the RequestHandler
+	// returned an error, its value is the error text
+	HdrReplyError = "pusu-reply-error"
+)
+
+const (
+	// replyTopicRoot is the first part of the topics on which replies to
+	// requests are published
+	replyTopicRoot = "/pusu-reply"
+	// DfltRequestTimeout is the time that Request will wait for a reply if
+	// the context has no deadline
+	DfltRequestTimeout = 30 * time.Second
+)
+
+// RequestHandler is a function that will be called when a request is
+// received on a topic subscribed to with Client.Respond. The returned bytes
+// are published as the reply to the request. If a non-nil error is returned
+// the error text is sent to the requester instead.
+type RequestHandler func(req Delivery) ([]byte, error)
+
+// makeReplyPfx returns a topic unique to this Client under which the replies
+// to its requests will be published
+func makeReplyPfx() pusu.Topic {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+
+	return pusu.Topic(replyTopicRoot + "/" + hex.EncodeToString(b))
+}
+
+// nextReplyTopic returns a new topic for the reply to a request and the
+// correlation ID to be used to match the reply with the request
+func (c *Client) nextReplyTopic() (pusu.Topic, string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.reqID++
+	corrID := strconv.FormatUint(c.reqID, 10)
+
+	return c.replyPfx + pusu.Topic("/"+corrID), corrID
+}
+
+// Request publishes the payload on the topic as a request and waits for the
+// first reply which it returns. The reply is published by the responder
+// (see Respond) on a temporary topic which Request subscribes to before
+// sending the request and unsubscribes from before returning.
+//
+// If the context has no deadline then DfltRequestTimeout is used. If the
+// context is done before a reply arrives the context's error is
+// returned. If the responder returned an error then that is reported in the
+// error returned.
+//
+// Note that the pub/sub server must support publication headers.
+func (c *Client) Request(
+	ctx context.Context,
+	topic pusu.Topic,
+	payload []byte,
+	opts ...PublishOpt,
+) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, DfltRequestTimeout)
+		defer cancel()
+	}
+
+	replyTopic, corrID := c.nextReplyTopic()
+	replies := make(chan Delivery, 1)
+
+	s, err := c.NewDeliverySubscriptionCtx(ctx, replyTopic,
+		func(d Delivery) {
+			if d.Headers[HdrCorrelationID] != corrID {
+				return
+			}
+
+			select {
+			case replies <- d:
+			default: // only the first reply is wanted
+			}
+		})
+	if s != nil {
+		defer func() { _ = s.Unsubscribe(nil) }()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, WithHeaders(map[string]string{
+		HdrReplyTo:       string(replyTopic),
+		HdrCorrelationID: corrID,
+	}))
+
+	if err := c.PublishCtx(ctx, topic, payload, opts...); err != nil {
+		return nil, err
+	}
+
+	select {
+	case d := <-replies:
+		if errText, ok := d.Headers[HdrReplyError]; ok {
+			return nil, fmt.Errorf("the request on %q failed: %s",
+				topic, errText)
+		}
+
+		return d.Payload, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no reply to the request on %q: %w",
+			topic, ctx.Err())
+	}
+}
+
+// Respond subscribes to the topic and calls the RequestHandler for each
+// request received, publishing the result as the reply. Messages received
+// on the topic which were not sent by Request (having no reply topic) are
+// ignored. The returned Subscription can be used to stop responding.
+//
+// The RequestHandler is called in a new goroutine for each request so it
+// may take as long as it needs without delaying the message reading
+// goroutine.
+//
+// Note that the Callback argument can be nil in which case it will be
+// ignored. See the documentation for the Callback type to understand how it
+// should be used.
+func (c *Client) Respond(cb Callback, topic pusu.Topic, rh RequestHandler,
+) (*Subscription, error) {
+	if rh == nil {
+		return nil, fmt.Errorf("the RequestHandler for Topic %q is nil", topic)
+	}
+
+	return c.NewDeliverySubscription(cb, topic,
+		func(d Delivery) {
+			replyTo, ok := d.Headers[HdrReplyTo]
+			if !ok {
+				return
+			}
+
+			go c.reply(rh, d, pusu.Topic(replyTo))
+		})
+}
+
+// reply calls the RequestHandler and publishes its result on the reply
+// topic
+func (c *Client) reply(rh RequestHandler, req Delivery, replyTo pusu.Topic) {
+	hdrs := map[string]string{
+		HdrCorrelationID: req.Headers[HdrCorrelationID],
+	}
+
+	payload, err := rh(req)
+	if err != nil {
+		hdrs[HdrReplyError] = err.Error()
+		payload = nil
+	}
+
+	if err := c.Publish(nil, replyTo, payload, WithHeaders(hdrs)); err != nil {
+		c.log().Error("couldn't publish the reply to a request",
+			req.Topic.Attr(),
+			pusu.ErrorAttr(err))
+	}
+}
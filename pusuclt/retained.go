@@ -0,0 +1,96 @@
+package pusuclt
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+// retainedMap maps between a topic and the latest retained value received
+// for it
+type retainedMap map[pusu.Topic]Delivery
+
+// tracksRetained returns true if the pub/sub server marks the retained
+// values it sends and reports when they are cleared. Only then can the
+// retained values be kept by the Client. The Client mutex must be held
+// when this is called.
+func (c *Client) tracksRetained() bool {
+	return c.svrInfo.ProtoVsn >= pusu.ProtoVsnRetainedValues
+}
+
+// noteRetained records the retained value given in the Publish message
+// payload or, if the message clears it, forgets the value. The Client
+// mutex must be held when this is called.
+func (c *Client) noteRetained(pmp *pusu.PublishMsgPayload, d Delivery) {
+	switch {
+	case pmp.ClearRetained:
+		delete(c.retained, d.Topic)
+	case pmp.Retain || pmp.Retained:
+		d.Retained = true
+		c.retained[d.Topic] = d
+	}
+}
+
+// forgetUnsubscribedRetained discards the retained values for topics no
+// longer subscribed to. The Client mutex must be held when this is called.
+func (c *Client) forgetUnsubscribedRetained() {
+	maps.DeleteFunc(c.retained, func(t pusu.Topic, _ Delivery) bool {
+		if _, ok := c.handlers[t]; ok {
+			return false
+		}
+
+		return !slices.ContainsFunc(c.patterns,
+			func(pt pusu.Topic) bool { return pt.Matches(t) })
+	})
+}
+
+// queueRetainedReplays arranges for the retained values already received
+// for topics matching the handler's topic to be given to the handler. It
+// is called when a handler is added for a topic which is already
+// subscribed to, since the pub/sub server will not send the values again.
+// The values are delivered by replayRetained. The Client mutex must be
+// held when this is called.
+func (c *Client) queueRetainedReplays(t pusu.Topic, he *handlerEntry) {
+	for _, rt := range slices.Sorted(maps.Keys(c.retained)) {
+		if rt == t || t.Matches(rt) {
+			c.replays = append(c.replays,
+				dispatchJob{d: c.retained[rt], entries: []*handlerEntry{he}})
+		}
+	}
+}
+
+// replayRetained passes the retained values queued by queueRetainedReplays
+// to the dispatcher. The Client mutex must not be held when this is called.
+func (c *Client) replayRetained() {
+	c.mtx.Lock()
+	replays := c.replays
+	c.replays = nil
+	c.mtx.Unlock()
+
+	for _, j := range replays {
+		c.dispatcher.dispatch(j.d, j.entries)
+	}
+}
+
+// deliverRetained passes the retained value to the dispatcher for just the
+// handlers of the subscribed topics it was sent for. Other handlers for
+// matching topics either had the value when their own subscription was
+// made or have had later values.
+func (c *Client) deliverRetained(d Delivery, subs []string) {
+	var entries []*handlerEntry
+
+	c.mtx.Lock()
+
+	for _, st := range subs {
+		if hs, ok := c.handlers[pusu.Topic(st)]; ok {
+			entries = hs.appendEntries(entries)
+		}
+	}
+
+	c.mtx.Unlock()
+
+	if len(entries) > 0 {
+		c.dispatcher.dispatch(d, entries)
+	}
+}
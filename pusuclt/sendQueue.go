@@ -0,0 +1,257 @@
+package pusuclt
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+const (
+	// DfltSendQueueSize is the number of messages that can be queued to be
+	// sent to the pub/sub server if the ConnInfo does not give a size
+	DfltSendQueueSize = 256
+	// DfltSendTimeout is the time to wait for room in the send queue, with
+	// the SendBlockTimeout policy, if the ConnInfo does not give a time
+	DfltSendTimeout = 5 * time.Second
+)
+
+// ErrSendQueueFull is returned, or passed to the Callback, when a message
+// cannot be sent because the queue of messages waiting to be sent to the
+// pub/sub server is full.
+var ErrSendQueueFull = errors.New("the send queue is full")
+
+// SendPolicy determines what is done with a Publish message when the queue
+// of messages waiting to be sent to the pub/sub server is full. Other
+// messages, such as Subscribe messages, are held in a separate queue, which
+// is sent first, and always wait until there is room.
+type SendPolicy int
+
+const (
+	// SendBlock waits until there is room in the queue. This is the default
+	SendBlock SendPolicy = iota
+	// SendBlockTimeout waits until there is room in the queue for no longer
+	// than the ConnInfo SendTimeout, after which ErrSendQueueFull is
+	// returned
+	SendBlockTimeout
+	// SendDropNewest discards the message being sent. No error is returned
+	// but the Callback, if any, is called with ErrSendQueueFull
+	SendDropNewest
+	// SendDropOldest discards the Publish message at the head of the queue
+	// to make room for the message being sent. The Callback of the
+	// discarded message, if any, is called with ErrSendQueueFull
+	SendDropOldest
+	// SendError returns ErrSendQueueFull immediately
+	SendError
+)
+
+// String returns the name of the SendPolicy
+func (sp SendPolicy) String() string {
+	switch sp {
+	case SendBlock:
+		return "Block"
+	case SendBlockTimeout:
+		return "BlockTimeout"
+	case SendDropNewest:
+		return "DropNewest"
+	case SendDropOldest:
+		return "DropOldest"
+	case SendError:
+		return "Error"
+	}
+
+	return fmt.Sprintf("SendPolicy(%d)", int(sp))
+}
+
+// outMsg is a message ready to be queued to be sent to the pub/sub
+// server. It records the queue and the runExit channel of the connection
+// at the time the message was prepared so that it can be queued without
+// holding the Client mutex.
+type outMsg struct {
+	msg     *pusu.Message
+	queue   chan *pusu.Message
+	runExit <-chan struct{}
+	held    bool // the message is held in the outbox, not queued
+}
+
+// prepare gives the message the next message ID and records the Callback
+// against that ID. The returned outMsg should be passed to enqueue once the
+// Client mutex has been released. The Client mutex must be held when this
+// is called.
+func (c *Client) prepare(msg *pusu.Message, cb Callback) outMsg {
+	msg.MsgID = c.nextMsgID()
+
+	c.addCallback(msg.MsgID, cb)
+
+	queue := c.sendChan
+	if msg.MT != pusu.Publish {
+		queue = c.ctrlChan
+	}
+
+	return outMsg{
+		msg:     msg,
+		queue:   queue,
+		runExit: c.runExit,
+	}
+}
+
+// enqueue adds the message to the send queue, to be written to the
+// connection by the run goroutine, applying the SendPolicy if the queue is
+// full. It returns the message ID. It returns a non-nil error, and
+// discards the Callback, if the message was not queued, other than when it
+// was dropped under the SendDropNewest policy. The Client mutex must not
+// be held when this is called.
+func (c *Client) enqueue(om outMsg, policy SendPolicy) (pusu.MsgID, error) {
+	select {
+	case <-om.runExit:
+		return c.unqueued(om, errNoConn)
+	default:
+	}
+
+	select {
+	case om.queue <- om.msg:
+		return om.msg.MsgID, nil
+	default:
+	}
+
+	switch policy {
+	case SendBlockTimeout:
+		timer := time.NewTimer(c.cci.sendTimeout())
+		defer timer.Stop()
+
+		select {
+		case om.queue <- om.msg:
+			return om.msg.MsgID, nil
+		case <-om.runExit:
+			return c.unqueued(om, errNoConn)
+		case <-timer.C:
+			return c.unqueued(om, ErrSendQueueFull)
+		}
+	case SendDropNewest:
+		c.drop(om.msg)
+
+		return om.msg.MsgID, nil
+	case SendDropOldest:
+		return c.enqueueDroppingOldest(om)
+	case SendError:
+		return c.unqueued(om, ErrSendQueueFull)
+	}
+
+	select {
+	case om.queue <- om.msg:
+		return om.msg.MsgID, nil
+	case <-om.runExit:
+		return c.unqueued(om, errNoConn)
+	}
+}
+
+// enqueueDroppingOldest adds the message to the send queue, discarding
+// messages from the head of the queue until there is room. Only Publish
+// messages are held in the send queue so no other message is discarded.
+func (c *Client) enqueueDroppingOldest(om outMsg) (pusu.MsgID, error) {
+	for {
+		select {
+		case om.queue <- om.msg:
+			return om.msg.MsgID, nil
+		case <-om.runExit:
+			return c.unqueued(om, errNoConn)
+		default:
+		}
+
+		select {
+		case oldest := <-om.queue:
+			c.drop(oldest)
+		default: // the run goroutine has taken a message
+		}
+	}
+}
+
+// unqueued discards the Callback for the message which was not queued and
+// returns the error
+func (c *Client) unqueued(om outMsg, err error) (pusu.MsgID, error) {
+	c.mtx.Lock()
+	c.getCallback(om.msg.MsgID)
+	c.mtx.Unlock()
+
+	return pusu.NoMsgID, err
+}
+
+// drop reports the discarding of a message from the send queue and calls
+// its Callback, if any, with ErrSendQueueFull
+func (c *Client) drop(msg *pusu.Message) {
+	c.log().Error("the message was dropped",
+		msg.MT.Attr(),
+		msg.MsgID.Attr(),
+		pusu.ErrorAttr(ErrSendQueueFull))
+
+	c.callback(msg.MsgID, ErrSendQueueFull)
+}
+
+// flushQueue writes the messages remaining in the control and send queues
+// to the connection. It is called by the run goroutine when it is told to
+// stop so that the messages already accepted are not lost.
+func (c *Client) flushQueue() error {
+	for _, queue := range []chan *pusu.Message{c.ctrlChan, c.sendChan} {
+		for {
+			var msg *pusu.Message
+
+			select {
+			case msg = <-queue:
+			default:
+			}
+
+			if msg == nil {
+				break
+			}
+
+			if err := msg.Write(c.conn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// discardQueue empties the control and send queues. If hold is true the
+// Publish messages are put in the outbox, to be sent once the client has
+// reconnected, otherwise the Callbacks of the messages which will now never
+// be sent are called with errNoConn. The Client mutex must be held when
+// this is called.
+func (c *Client) discardQueue(hold bool) {
+	for _, queue := range []chan *pusu.Message{c.ctrlChan, c.sendChan} {
+		for {
+			var msg *pusu.Message
+
+			select {
+			case msg = <-queue:
+			default:
+			}
+
+			if msg == nil {
+				break
+			}
+
+			cb := c.getCallback(msg.MsgID)
+
+			if hold && msg.MT == pusu.Publish {
+				c.outbox.requeue([]outboxEntry{{
+					id:      msg.MsgID,
+					payload: msg.Payload,
+					added:   time.Now(),
+				}})
+
+				if cb != nil {
+					c.outbox.cbs[msg.MsgID] = cb
+				}
+
+				continue
+			}
+
+			if cb != nil {
+				go cb(errNoConn)
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package pusuclt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+// awaitCallbackErr waits for the Callback to be called and returns the
+// error it was passed. It returns false if the Callback is not called
+// within the timeout.
+func awaitCallbackErr(ch <-chan error, timeout time.Duration) (error, bool) {
+	select {
+	case err := <-ch:
+		return err, true
+	case <-time.After(timeout):
+		return nil, false
+	}
+}
+
+func TestSendPolicy(t *testing.T) {
+	const (
+		queuedNone = iota
+		queuedOld
+		queuedNew
+	)
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		policy     SendPolicy
+		try        bool
+		takeOne    bool
+		expQueued  int
+		expOldDrop bool
+		expNewDrop bool
+	}{
+		{
+			ID:        testhelper.MkID("Block"),
+			policy:    SendBlock,
+			takeOne:   true,
+			expQueued: queuedNew,
+		},
+		{
+			ID:        testhelper.MkID("BlockTimeout"),
+			ExpErr:    testhelper.MkExpErr(ErrSendQueueFull.Error()),
+			policy:    SendBlockTimeout,
+			expQueued: queuedOld,
+		},
+		{
+			ID:         testhelper.MkID("DropNewest"),
+			policy:     SendDropNewest,
+			expQueued:  queuedOld,
+			expNewDrop: true,
+		},
+		{
+			ID:         testhelper.MkID("DropOldest"),
+			policy:     SendDropOldest,
+			expQueued:  queuedNew,
+			expOldDrop: true,
+		},
+		{
+			ID:        testhelper.MkID("Error"),
+			ExpErr:    testhelper.MkExpErr(ErrSendQueueFull.Error()),
+			policy:    SendError,
+			expQueued: queuedOld,
+		},
+		{
+			ID:        testhelper.MkID("TryPublish"),
+			ExpErr:    testhelper.MkExpErr(ErrSendQueueFull.Error()),
+			policy:    SendBlock,
+			try:       true,
+			expQueued: queuedOld,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			cc := makeTestClient(&bytes.Buffer{}, &bytes.Buffer{}, nil, nil)
+			cc.runExit = make(chan struct{})
+			cc.sendChan = make(chan *pusu.Message, 1)
+			cc.cci.SendPolicy = tc.policy
+			cc.cci.SendTimeout = 10 * time.Millisecond
+
+			oldCB := make(chan error, 1)
+			newCB := make(chan error, 1)
+
+			err := cc.TryPublish(func(err error) { oldCB <- err },
+				"/old", []byte("old"))
+			testhelper.CheckError(t, "filling the queue", err, false, nil)
+
+			oldID := cc.msgID
+
+			if tc.takeOne {
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					<-cc.sendChan
+				}()
+			}
+
+			publish := cc.Publish
+			if tc.try {
+				publish = cc.TryPublish
+			}
+
+			err = publish(func(err error) { newCB <- err },
+				"/new", []byte("new"))
+			testhelper.CheckExpErr(t, err, tc)
+
+			expQueuedID := map[int]pusu.MsgID{
+				queuedNone: pusu.NoMsgID,
+				queuedOld:  oldID,
+				queuedNew:  oldID + 1,
+			}[tc.expQueued]
+
+			queuedID := pusu.NoMsgID
+
+			select {
+			case msg := <-cc.sendChan:
+				queuedID = msg.MsgID
+			default:
+			}
+
+			testhelper.DiffInt(t, tc.IDStr(), "queued message ID",
+				queuedID, expQueuedID)
+
+			checkDropped(t, tc.IDStr(), "old", oldCB, tc.expOldDrop)
+			checkDropped(t, tc.IDStr(), "new", newCB, tc.expNewDrop)
+		})
+	}
+}
+
+// checkDropped checks that the Callback has been called with
+// ErrSendQueueFull if the message was expected to be dropped and not
+// called otherwise
+func checkDropped(t *testing.T, id, name string, cb <-chan error, exp bool) {
+	t.Helper()
+
+	timeout := 10 * time.Millisecond
+	if exp {
+		timeout = time.Second
+	}
+
+	err, called := awaitCallbackErr(cb, timeout)
+	if testhelper.DiffBool(t, id, name+" message dropped", called, exp) {
+		return
+	}
+
+	if called && !errors.Is(err, ErrSendQueueFull) {
+		t.Log(id)
+		t.Logf("\t: %s message Callback error: %v", name, err)
+		t.Error("\t: the Callback error should be ErrSendQueueFull")
+	}
+}
+
+func TestSendDropOldestKeepsControlMsgs(t *testing.T) {
+	cc := makeTestClient(&bytes.Buffer{}, &bytes.Buffer{}, nil, nil)
+	cc.runExit = make(chan struct{})
+	cc.sendChan = make(chan *pusu.Message, 1)
+	cc.ctrlChan = make(chan *pusu.Message, 1)
+	cc.cci.SendPolicy = SendDropOldest
+
+	oldCB := make(chan error, 1)
+
+	err := cc.Publish(func(err error) { oldCB <- err }, "/old", []byte("old"))
+	testhelper.CheckError(t, "filling the queue", err, false, nil)
+
+	err = cc.Subscribe(nil,
+		TopicHandler{Topic: "/a", Handler: func(pusu.Topic, []byte) {}})
+	testhelper.CheckError(t, "Subscribe", err, false, nil)
+
+	err = cc.Publish(nil, "/new", []byte("new"))
+	testhelper.CheckError(t, "Publish", err, false, nil)
+
+	checkDropped(t, "DropOldest", "old", oldCB, true)
+
+	var queued []string
+
+	for _, queue := range []chan *pusu.Message{cc.ctrlChan, cc.sendChan} {
+		select {
+		case msg := <-queue:
+			queued = append(queued, msg.MT.String())
+		default:
+		}
+	}
+
+	testhelper.DiffStringSlice(t, "DropOldest", "queued messages",
+		queued, []string{"Subscribe", "Publish"})
+}
@@ -0,0 +1,77 @@
+package pusuclt
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"google.golang.org/protobuf/proto"
+)
+
+// ServerInfo describes the pub/sub server as reported in its reply to the
+// Start message.
+type ServerInfo struct {
+	ProtoVsn     pusu.ProtoVsn     // the protocol version being used
+	ServerID     string            // identifying text for the server
+	Capabilities pusu.Capabilities // the optional features supported
+}
+
+// makeServerInfo constructs the ServerInfo from the payload of the Ack
+// message sent in reply to the Start message. A server which does not
+// report its details is taken to support only the first protocol version
+// and no optional features.
+func makeServerInfo(payload []byte) (ServerInfo, error) {
+	if len(payload) == 0 {
+		return ServerInfo{ProtoVsn: 1}, nil
+	}
+
+	var samp pusu.StartAckMsgPayload
+	if err := proto.Unmarshal(payload, &samp); err != nil {
+		return ServerInfo{},
+			fmt.Errorf("could not unmarshal the Start Ack message: %w", err)
+	}
+
+	si := ServerInfo{
+		ProtoVsn:     pusu.ProtoVsn(samp.ProtocolVersion),
+		ServerID:     samp.ServerId,
+		Capabilities: pusu.CapabilitiesFromStrings(samp.Capabilities),
+	}
+
+	if err := si.ProtoVsn.Check(); err != nil {
+		return ServerInfo{},
+			fmt.Errorf("the server chose an unsupported protocol: %w", err)
+	}
+
+	return si, nil
+}
+
+// attrs returns slog Attrs describing the ServerInfo
+func (si ServerInfo) attrs() []any {
+	return []any{
+		si.ProtoVsn.Attr(),
+		slog.String(pusu.AttrPfx+"ServerID", si.ServerID),
+		si.Capabilities.Attr(),
+	}
+}
+
+// ServerInfo returns the details of the pub/sub server as reported when the
+// connection was last started.
+func (c *Client) ServerInfo() ServerInfo {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.svrInfo
+}
+
+// checkCapability returns a non-nil error if the pub/sub server does not
+// support the capability. The Client mutex must be held when this is
+// called.
+func (c *Client) checkCapability(pc pusu.Capability, feature string) error {
+	if c.svrInfo.Capabilities.Has(pc) {
+		return nil
+	}
+
+	return pusu.NewCodedError(pusu.ErrCodeUnsupported,
+		"%s is not supported by the %s (capability: %q)",
+		feature, c.serverDetails(), pc)
+}
@@ -0,0 +1,93 @@
+package pusuclt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMakeServerInfo(t *testing.T) {
+	mkPayload := func(samp *pusu.StartAckMsgPayload) []byte {
+		payload, err := proto.Marshal(samp)
+		if err != nil {
+			t.Fatal("couldn't marshal the Start Ack payload:", err)
+		}
+
+		return payload
+	}
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		payload []byte
+		expSI   ServerInfo
+	}{
+		{
+			ID:    testhelper.MkID("no payload"),
+			expSI: ServerInfo{ProtoVsn: 1},
+		},
+		{
+			ID: testhelper.MkID("full payload"),
+			payload: mkPayload(&pusu.StartAckMsgPayload{
+				ProtocolVersion: pusu.CurrentProtoVsn,
+				ServerId:        "server",
+				Capabilities:    []string{"retain", "unknown"},
+			}),
+			expSI: ServerInfo{
+				ProtoVsn:     pusu.CurrentProtoVsn,
+				ServerID:     "server",
+				Capabilities: pusu.Capabilities{pusu.CapRetain, "unknown"},
+			},
+		},
+		{
+			ID: testhelper.MkID("bad protocol version"),
+			ExpErr: testhelper.MkExpErr(
+				"the server chose an unsupported protocol",
+				"too big"),
+			payload: mkPayload(&pusu.StartAckMsgPayload{
+				ProtocolVersion: pusu.CurrentProtoVsn + 1,
+			}),
+		},
+		{
+			ID: testhelper.MkID("bad payload"),
+			ExpErr: testhelper.MkExpErr(
+				"could not unmarshal the Start Ack message"),
+			payload: []byte{0xff},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			si, err := makeServerInfo(tc.payload)
+			if !testhelper.CheckExpErr(t, err, tc) || err != nil {
+				return
+			}
+
+			testhelper.DiffInt(t, tc.IDStr(), "protocol version",
+				si.ProtoVsn, tc.expSI.ProtoVsn)
+			testhelper.DiffString(t, tc.IDStr(), "server ID",
+				si.ServerID, tc.expSI.ServerID)
+			testhelper.DiffStringSlice(t, tc.IDStr(), "capabilities",
+				si.Capabilities, tc.expSI.Capabilities)
+		})
+	}
+}
+
+func TestSubscribeWildcardCapability(t *testing.T) {
+	cc := makeTestClient(&bytes.Buffer{}, nil, nil, nil)
+	cc.connected = true
+
+	err := cc.Subscribe(nil,
+		TopicHandler{Topic: "/a", Handler: mhFunc0},
+		TopicHandler{Topic: "/a/*", Handler: mhFunc1})
+	testhelper.CheckError(t, "wildcard subscription", err, true,
+		[]string{
+			`cannot add the handler for Topic "/a/*" (1)`,
+			"subscribing to a wildcard topic is not supported",
+		})
+	testhelper.DiffInt(t, "wildcard subscription", "topic count",
+		len(cc.handlers), 0)
+}
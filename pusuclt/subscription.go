@@ -0,0 +1,177 @@
+package pusuclt
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+// Subscription represents a single subscription to a topic made through
+// Client.NewSubscription. A Subscription is identified by a unique ID
+// rather than by its handler and so the same handler can be subscribed to
+// a topic many times and each Subscription can be cancelled individually.
+type Subscription struct {
+	c  *Client
+	th TopicHandler
+	id uint64
+}
+
+// Topic returns the topic subscribed to
+func (s *Subscription) Topic() pusu.Topic {
+	return s.th.Topic
+}
+
+// Handler returns the MsgHandler called for messages received on the
+// topic. It will be nil if the Subscription was made with a DeliveryHandler.
+func (s *Subscription) Handler() MsgHandler {
+	return s.th.Handler
+}
+
+// DeliveryHandler returns the DeliveryHandler called for messages received
+// on the topic. It will be nil if the Subscription was made with a
+// MsgHandler.
+func (s *Subscription) DeliveryHandler() DeliveryHandler {
+	return s.th.DeliveryHandler
+}
+
+// ID returns the unique ID of the Subscription
+func (s *Subscription) ID() uint64 {
+	return s.id
+}
+
+// String returns a string representation of the Subscription
+func (s *Subscription) String() string {
+	return fmt.Sprintf("Subscription{ID: %d, Topic: %q}", s.id, s.th.Topic)
+}
+
+// keyed returns the Subscription as a keyedHandler identified by its ID
+func (s *Subscription) keyed() keyedHandler {
+	return keyedHandler{
+		TopicHandler: s.th,
+		key:          handlerKey{subID: s.id},
+	}
+}
+
+// Unsubscribe cancels the Subscription. If it was the last handler for the
+// topic an Unsubscribe message is sent to the pub/sub server. Note that the
+// Callback argument can be nil in which case it will be ignored. See the
+// documentation for the Callback type to understand how it should be used.
+func (s *Subscription) Unsubscribe(cb Callback) error {
+	_, err := s.c.unsubscribe(cb, s.keyed())
+
+	return err
+}
+
+// UnsubscribeCtx behaves like Unsubscribe but waits for the reply from the
+// pub/sub server. See Client.UnsubscribeCtx for details.
+func (s *Subscription) UnsubscribeCtx(ctx context.Context) error {
+	return s.c.await(ctx, func(cb Callback) (pusu.MsgID, error) {
+		return s.c.unsubscribe(cb, s.keyed())
+	})
+}
+
+// NewSubscription subscribes the handler to the topic and returns a
+// Subscription which can be used to cancel it. If this is the first handler
+// for the topic a Subscribe message is sent to the pub/sub server. The topic
+// must pass the topic check and the handler must not be nil.
+//
+// Note that the Callback argument can be nil in which case it will be
+// ignored. See the documentation for the Callback type to understand how it
+// should be used.
+func (c *Client) NewSubscription(
+	cb Callback,
+	topic pusu.Topic,
+	mh MsgHandler,
+) (*Subscription, error) {
+	return c.newSubscription(cb, TopicHandler{Topic: topic, Handler: mh})
+}
+
+// NewDeliverySubscription behaves like NewSubscription but the handler is
+// passed the full Delivery, including any headers, for each message.
+func (c *Client) NewDeliverySubscription(
+	cb Callback,
+	topic pusu.Topic,
+	dh DeliveryHandler,
+) (*Subscription, error) {
+	return c.newSubscription(cb,
+		TopicHandler{Topic: topic, DeliveryHandler: dh})
+}
+
+// newSubscription subscribes the TopicHandler with a new Subscription ID
+func (c *Client) newSubscription(cb Callback, th TopicHandler,
+) (*Subscription, error) {
+	s := c.makeSubscription(th)
+
+	if _, err := c.subscribe(cb, s.keyed()); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// NewSubscriptionCtx behaves like NewSubscription but waits for the reply
+// from the pub/sub server. See Client.SubscribeCtx for details. Note that if
+// the context finishes before the reply arrives the Subscription is still
+// returned, with the error, so that it can be cancelled. If the server
+// rejects the subscription the handler is removed and no Subscription is
+// returned.
+func (c *Client) NewSubscriptionCtx(
+	ctx context.Context,
+	topic pusu.Topic,
+	mh MsgHandler,
+) (*Subscription, error) {
+	return c.newSubscriptionCtx(ctx, TopicHandler{Topic: topic, Handler: mh})
+}
+
+// NewDeliverySubscriptionCtx behaves like NewDeliverySubscription but waits
+// for the reply from the pub/sub server. See NewSubscriptionCtx for details.
+func (c *Client) NewDeliverySubscriptionCtx(
+	ctx context.Context,
+	topic pusu.Topic,
+	dh DeliveryHandler,
+) (*Subscription, error) {
+	return c.newSubscriptionCtx(ctx,
+		TopicHandler{Topic: topic, DeliveryHandler: dh})
+}
+
+// newSubscriptionCtx subscribes the TopicHandler with a new Subscription ID
+// and waits for the reply from the pub/sub server
+func (c *Client) newSubscriptionCtx(ctx context.Context, th TopicHandler,
+) (*Subscription, error) {
+	s := c.makeSubscription(th)
+
+	var added bool
+
+	err := c.await(ctx, func(cb Callback) (pusu.MsgID, error) {
+		msgID, err := c.subscribe(cb, s.keyed())
+		added = err == nil
+
+		return msgID, err
+	})
+	if !added || isRejection(err) {
+		return nil, err
+	}
+
+	return s, err
+}
+
+// makeSubscription returns a new Subscription with the next Subscription ID
+func (c *Client) makeSubscription(th TopicHandler) *Subscription {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.nextSubscription(th)
+}
+
+// nextSubscription returns a new Subscription with the next Subscription
+// ID. The Client mutex must be held when this is called.
+func (c *Client) nextSubscription(th TopicHandler) *Subscription {
+	c.subID++
+
+	return &Subscription{
+		c:  c,
+		th: th,
+		id: c.subID,
+	}
+}
@@ -0,0 +1,234 @@
+package pusuclt
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestSubscription(t *testing.T) {
+	const topic = pusu.Topic("/topic")
+
+	cc := makeTestClient(&bytes.Buffer{}, &bytes.Buffer{}, nil, nil)
+	cc.runExit = make(chan struct{})
+
+	sent := &bytes.Buffer{}
+
+	go fakeServer(cc, func(cc *Client, msg *pusu.Message) {
+		fmt.Fprintf(sent, "%s;", msg.MT)
+		cc.callback(msg.MsgID, nil)
+	})
+	defer close(cc.sendChan)
+
+	recvBuf := &bytes.Buffer{}
+
+	// these two handlers are made from the same function literal
+	mh1 := makeTestMsgHandler(recvBuf, 1)
+	mh2 := makeTestMsgHandler(recvBuf, 2)
+
+	s1, err := cc.NewSubscriptionCtx(t.Context(), topic, mh1)
+	testhelper.CheckError(t, "first Subscription", err, false, nil)
+
+	s2, err := cc.NewSubscription(nil, topic, mh2)
+	testhelper.CheckError(t, "second Subscription", err, false, nil)
+
+	_, err = cc.NewSubscription(nil, "bad-topic", mh1)
+	testhelper.CheckError(t, "bad topic Subscription", err, true,
+		[]string{`bad topic "bad-topic" - it must start with a '/'`})
+
+	if s1 == nil || s2 == nil {
+		t.Fatal("the Subscriptions should not be nil")
+	}
+
+	testhelper.DiffString(t, "Subscription", "topic", s1.Topic(), topic)
+
+	if s1.ID() == s2.ID() {
+		t.Error("the Subscription IDs should differ, both are:", s1.ID())
+	}
+
+	cc.callMsgHandlers(topic, []byte("both"))
+
+	testhelper.CheckError(t, "unsubscribe first", s1.Unsubscribe(nil),
+		false, nil)
+
+	cc.callMsgHandlers(topic, []byte("second"))
+
+	testhelper.CheckError(t, "unsubscribe first again",
+		s1.Unsubscribe(nil), true, []string{errHandlerNotInSet.Error()})
+
+	testhelper.CheckError(t, "unsubscribe second",
+		s2.UnsubscribeCtx(t.Context()), false, nil)
+
+	cc.callMsgHandlers(topic, []byte("neither"))
+
+	testhelper.DiffString(t, "Subscription", "handler calls",
+		recvBuf.String(),
+		"/topic:1=both\n/topic:2=both\n/topic:2=second\n")
+	testhelper.DiffString(t, "Subscription", "messages sent",
+		sent.String(), "Subscribe;Unsubscribe;")
+	testhelper.DiffInt(t, "Subscription", "topic count", len(cc.handlers), 0)
+}
+
+func TestSubscribeSameLiteral(t *testing.T) {
+	const topic = pusu.Topic("/topic")
+
+	cc := makeTestClient(&bytes.Buffer{}, &bytes.Buffer{}, nil, nil)
+	cc.runExit = make(chan struct{})
+
+	go fakeServer(cc, func(cc *Client, msg *pusu.Message) {
+		cc.callback(msg.MsgID, nil)
+	})
+	defer close(cc.sendChan)
+
+	recvBuf := &bytes.Buffer{}
+
+	mh1 := makeTestMsgHandler(recvBuf, 1)
+	mh2 := makeTestMsgHandler(recvBuf, 2)
+
+	err := cc.SubscribeCtx(t.Context(),
+		TopicHandler{Topic: topic, Handler: mh1},
+		TopicHandler{Topic: topic, Handler: mh2})
+	testhelper.CheckError(t, "Subscribe", err, false, nil)
+
+	err = cc.Subscribe(nil, TopicHandler{Topic: topic, Handler: mh1})
+	testhelper.CheckError(t, "Subscribe again", err, true,
+		[]string{errHandlerAlreadyAdded.Error()})
+
+	cc.callMsgHandlers(topic, []byte("both"))
+
+	err = cc.Unsubscribe(nil, TopicHandler{Topic: topic, Handler: mh1})
+	testhelper.CheckError(t, "Unsubscribe first", err, false, nil)
+
+	err = cc.Unsubscribe(nil, TopicHandler{Topic: topic, Handler: mh1})
+	testhelper.CheckError(t, "Unsubscribe first again", err, true,
+		[]string{errHandlerNotInSet.Error()})
+
+	cc.callMsgHandlers(topic, []byte("second"))
+
+	testhelper.DiffString(t, "Subscribe", "handler calls",
+		recvBuf.String(),
+		"/topic:1=both\n/topic:2=both\n/topic:2=second\n")
+	testhelper.DiffInt(t, "Subscribe", "recorded Subscriptions",
+		len(cc.thSubs), 1)
+}
+
+func TestDeliverySubscription(t *testing.T) {
+	const topic = pusu.Topic("/topic")
+
+	cc := makeTestClient(&bytes.Buffer{}, &bytes.Buffer{}, nil, nil)
+	cc.runExit = make(chan struct{})
+
+	go fakeServer(cc, func(cc *Client, msg *pusu.Message) {
+		cc.callback(msg.MsgID, nil)
+	})
+	defer close(cc.sendChan)
+
+	var got []Delivery
+
+	s, err := cc.NewDeliverySubscriptionCtx(t.Context(), topic,
+		func(d Delivery) { got = append(got, d) })
+	testhelper.CheckError(t, "Delivery Subscription", err, false, nil)
+
+	if s == nil {
+		t.Fatal("the Subscription should not be nil")
+	}
+
+	if s.Handler() != nil || s.DeliveryHandler() == nil {
+		t.Error("the Subscription should only have a DeliveryHandler")
+	}
+
+	pubTime := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	payload, err := proto.Marshal(&pusu.PublishMsgPayload{
+		Topic:       string(topic),
+		Payload:     []byte("data"),
+		Headers:     map[string]string{"content-type": "text/plain"},
+		PublishTime: timestamppb.New(pubTime),
+		PublisherId: "publisher",
+	})
+	if err != nil {
+		t.Fatal("couldn't marshal the Publish message payload:", err)
+	}
+
+	err = cc.handlePublish(pusu.Message{MT: pusu.Publish, Payload: payload})
+	testhelper.CheckError(t, "handlePublish", err, false, nil)
+
+	cc.callMsgHandlers(topic, []byte("bare"))
+
+	if testhelper.DiffInt(t, "Delivery", "count", len(got), 2) {
+		return
+	}
+
+	d := got[0]
+	testhelper.DiffString(t, "Delivery", "topic", d.Topic, topic)
+	testhelper.DiffString(t, "Delivery", "payload", string(d.Payload), "data")
+	testhelper.DiffString(t, "Delivery", "content-type header",
+		d.Headers["content-type"], "text/plain")
+	testhelper.DiffTime(t, "Delivery", "publish time", d.PublishTime, pubTime)
+	testhelper.DiffString(t, "Delivery", "publisher ID",
+		d.PublisherID, "publisher")
+
+	d = got[1]
+	testhelper.DiffString(t, "bare Delivery", "payload",
+		string(d.Payload), "bare")
+	testhelper.DiffInt(t, "bare Delivery", "header count", len(d.Headers), 0)
+	testhelper.DiffBool(t, "bare Delivery", "zero publish time",
+		d.PublishTime.IsZero(), true)
+}
+
+func TestSubscriptionRejected(t *testing.T) {
+	const topic = pusu.Topic("/topic")
+
+	cc := makeTestClient(&bytes.Buffer{}, &bytes.Buffer{}, nil, nil)
+	cc.runExit = make(chan struct{})
+
+	sent := &bytes.Buffer{}
+	rejected := false
+
+	go fakeServer(cc, func(cc *Client, msg *pusu.Message) {
+		fmt.Fprintf(sent, "%s;", msg.MT)
+
+		if !rejected {
+			rejected = true
+
+			cc.callback(msg.MsgID,
+				pusu.NewCodedError(pusu.ErrCodeNamespace, "not permitted"))
+
+			return
+		}
+
+		cc.callback(msg.MsgID, nil)
+	})
+	defer close(cc.sendChan)
+
+	mh := makeTestMsgHandler(&bytes.Buffer{}, 1)
+
+	s, err := cc.NewSubscriptionCtx(t.Context(), topic, mh)
+	testhelper.CheckError(t, "rejected Subscription", err, true,
+		[]string{"not permitted"})
+
+	if s != nil {
+		t.Error("a rejected Subscription should not be returned")
+	}
+
+	testhelper.DiffInt(t, "rejected Subscription", "topic count",
+		len(cc.handlers), 0)
+
+	s, err = cc.NewSubscriptionCtx(t.Context(), topic, mh)
+	testhelper.CheckError(t, "second Subscription", err, false, nil)
+
+	if s == nil {
+		t.Fatal("the second Subscription should not be nil")
+	}
+
+	testhelper.DiffString(t, "Subscription", "messages sent",
+		sent.String(), "Subscribe;Subscribe;")
+	testhelper.DiffInt(t, "second Subscription", "topic count",
+		len(cc.handlers), 1)
+}
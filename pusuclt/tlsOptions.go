@@ -0,0 +1,91 @@
+package pusuclt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// TLSOptions gives the TLS settings to use, in addition to the CertInfo,
+// when connecting to the pub/sub server with a Dialer using TLS. Any
+// values left unset take the crypto/tls defaults.
+type TLSOptions struct {
+	// ServerName, if set, is checked against the server's certificate
+	// instead of the host name taken from the server address.
+	ServerName string
+
+	// CipherSuites gives the cipher suites the client will accept. Note
+	// that the TLS 1.3 cipher suites cannot be configured.
+	CipherSuites []uint16
+
+	// CurvePreferences gives the key exchange mechanisms the client will
+	// use, in order of preference.
+	CurvePreferences []tls.CurveID
+
+	// SessionCache, if not nil, holds the TLS sessions so that they can be
+	// resumed when reconnecting to the pub/sub server.
+	SessionCache tls.ClientSessionCache
+
+	// NextProtos gives the application protocols to offer to the server,
+	// in order of preference (see tls.ConnectionState.NegotiatedProtocol).
+	NextProtos []string
+
+	// VerifyPeerCertificate, if not nil, is called after the normal
+	// verification of the server's certificate, for instance, to check
+	// that the server's public key is one of a set of pinned keys. The
+	// connection fails if it returns a non-nil error.
+	VerifyPeerCertificate func(rawCerts [][]byte,
+		verifiedChains [][]*x509.Certificate) error
+
+	// Configure, if not nil, is called with the tls.Config after all the
+	// other settings have been applied. It can change any of them.
+	Configure func(cfg *tls.Config)
+}
+
+// tlsConfig returns the tls.Config to use to connect to the pub/sub
+// server. The CertInfo must have been populated.
+func (ci *ConnInfo) tlsConfig() *tls.Config {
+	cfg := &tls.Config{
+		RootCAs:      ci.CertInfo.CertPool(),
+		Certificates: []tls.Certificate{ci.CertInfo.Cert()},
+		MinVersion:   tls.VersionTLS13,
+	}
+
+	opts := ci.TLS
+	if opts == nil {
+		return cfg
+	}
+
+	cfg.ServerName = opts.ServerName
+	cfg.CipherSuites = opts.CipherSuites
+	cfg.CurvePreferences = opts.CurvePreferences
+	cfg.ClientSessionCache = opts.SessionCache
+	cfg.NextProtos = opts.NextProtos
+	cfg.VerifyPeerCertificate = opts.VerifyPeerCertificate
+
+	if opts.Configure != nil {
+		opts.Configure(cfg)
+	}
+
+	return cfg
+}
+
+// TLSConnectionState returns the details of the TLS connection to the
+// pub/sub server, such as the server's certificates, the negotiated
+// application protocol and whether the session was resumed. The bool is
+// false if the client is not connected or the connection does not use
+// TLS.
+func (c *Client) TLSConnectionState() (tls.ConnectionState, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.connected {
+		return tls.ConnectionState{}, false
+	}
+
+	tc, ok := c.conn.(interface{ ConnectionState() tls.ConnectionState })
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+
+	return tc.ConnectionState(), true
+}
@@ -2,39 +2,133 @@ package pusuclt
 
 import (
 	"fmt"
+	"unsafe"
 
 	"github.com/nickwells/pusu.mod/pusu"
 )
 
 // TopicHandler represents an association between a topic and a handler for
-// the messages expected to be received over that topic.
+// the messages expected to be received over that topic. Exactly one of the
+// Handler or the DeliveryHandler must be given.
+//
+// Each TopicHandler subscribed is given its own Subscription ID. The
+// TopicHandler passed to Unsubscribe is matched by its topic and its
+// handler function value; that is, it must be the same function value as
+// was subscribed. Distinct closures, even if made from the same function
+// literal, are different values, as are two evaluations of the same method
+// value. Use Client.NewSubscription to get a Subscription which can be
+// cancelled without keeping the handler.
 type TopicHandler struct {
-	Topic   pusu.Topic
-	Handler MsgHandler
+	Topic           pusu.Topic
+	Handler         MsgHandler
+	DeliveryHandler DeliveryHandler
 }
 
 // check tests the TopicHandler for validity - the Topic must pass its checks
-// and the MsgHandler must not be nil
+// and exactly one of the MsgHandler and the DeliveryHandler must be non-nil
 func (th TopicHandler) check() error {
 	if err := th.Topic.Check(); err != nil {
 		return err
 	}
 
-	if th.Handler == nil {
+	if th.Handler == nil && th.DeliveryHandler == nil {
 		return fmt.Errorf("the MsgHandler for Topic %q is nil", th.Topic)
 	}
 
+	if th.Handler != nil && th.DeliveryHandler != nil {
+		return fmt.Errorf(
+			"both the MsgHandler and the DeliveryHandler for Topic %q are set",
+			th.Topic)
+	}
+
 	return nil
 }
 
-// id returns the id of the MsgHandler
+// funcValueAddr returns the address of the function value, zero if it is
+// nil. Distinct closures have distinct addresses, even when made from the
+// same function literal, and copies of a function value have the same
+// address.
+func funcValueAddr[F MsgHandler | DeliveryHandler](f F) uintptr {
+	if f == nil {
+		return 0
+	}
+
+	return *(*uintptr)(unsafe.Pointer(&f))
+}
+
+// id returns the id of the MsgHandler or, if that is nil, of the
+// DeliveryHandler
 func (th TopicHandler) id() uintptr {
-	return th.Handler.id()
+	if th.Handler != nil {
+		return th.Handler.id()
+	}
+
+	return th.DeliveryHandler.id()
+}
+
+// deliveryHandler returns the handler as a DeliveryHandler, adapting the
+// MsgHandler if that is the handler given
+func (th TopicHandler) deliveryHandler() DeliveryHandler {
+	if th.DeliveryHandler != nil {
+		return th.DeliveryHandler
+	}
+
+	mh := th.Handler
+
+	return func(d Delivery) { mh(d.Topic, d.Payload) }
+}
+
+// keyed returns the TopicHandler as a keyedHandler. It has no key; the
+// key is given when it is subscribed (see Client.addKeyedHandler) and
+// found from the TopicHandler when it is unsubscribed.
+func (th TopicHandler) keyed() keyedHandler {
+	return keyedHandler{
+		TopicHandler: th,
+		byValue:      true,
+	}
+}
+
+// Same returns true if the two TopicHandlers have the same topic and the
+// same handler function value. This is how the Client matches the
+// TopicHandler given to Unsubscribe with the one subscribed.
+func (th TopicHandler) Same(other TopicHandler) bool {
+	return th.key() == other.key()
+}
+
+// thKey identifies a TopicHandler by its topic and handler function value
+type thKey struct {
+	topic pusu.Topic
+	fn    uintptr
+}
+
+// key returns the thKey for the TopicHandler
+func (th TopicHandler) key() thKey {
+	return thKey{topic: th.Topic, fn: th.id()}
+}
+
+// keyedHandler bundles a TopicHandler with the key identifying it in the
+// handlerSet for its topic. If byValue is set the TopicHandler was given
+// to Subscribe or Unsubscribe and the key is found from the Subscription
+// recorded for it.
+type keyedHandler struct {
+	TopicHandler
+	key     handlerKey
+	byValue bool
+}
+
+// keyedHandlers converts the TopicHandlers into keyedHandlers
+func keyedHandlers(ths []TopicHandler) []keyedHandler {
+	khs := make([]keyedHandler, 0, len(ths))
+	for _, th := range ths {
+		khs = append(khs, th.keyed())
+	}
+
+	return khs
 }
 
 // String returns a string representation of the TopicHandler
 func (th TopicHandler) String() string {
-	if th.Handler == nil {
+	if th.id() == 0 {
 		return fmt.Sprintf("TopicHandler{Topic: %q, Handler: nil}", th.Topic)
 	}
 
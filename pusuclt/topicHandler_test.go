@@ -9,6 +9,7 @@ import (
 
 func TestTopicHandlerCheck(t *testing.T) {
 	handler := func(_ pusu.Topic, _ []byte) {}
+	dHandler := func(_ Delivery) {}
 
 	testCases := []struct {
 		testhelper.ID
@@ -33,6 +34,17 @@ func TestTopicHandlerCheck(t *testing.T) {
 				Handler: nil,
 			},
 		},
+		{
+			ID: testhelper.MkID("both handlers"),
+			ExpErr: testhelper.MkExpErr(
+				`both the MsgHandler and the DeliveryHandler`,
+				`for Topic "/good" are set`),
+			th: TopicHandler{
+				Topic:           "/good",
+				Handler:         handler,
+				DeliveryHandler: dHandler,
+			},
+		},
 		{
 			ID: testhelper.MkID("good TopicHandler"),
 			th: TopicHandler{
@@ -40,6 +52,13 @@ func TestTopicHandlerCheck(t *testing.T) {
 				Handler: handler,
 			},
 		},
+		{
+			ID: testhelper.MkID("good TopicHandler - DeliveryHandler"),
+			th: TopicHandler{
+				Topic:           "/good",
+				DeliveryHandler: dHandler,
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -89,3 +108,48 @@ func TestTopicHandlerID(t *testing.T) {
 		})
 	}
 }
+
+func TestTopicHandlerSame(t *testing.T) {
+	handler := func(_ pusu.Topic, _ []byte) {}
+	mkHandler := func() MsgHandler { return func(_ pusu.Topic, _ []byte) {} }
+	dHandler := func(_ Delivery) {}
+
+	th := TopicHandler{Topic: "/topic", Handler: handler}
+
+	testCases := []struct {
+		testhelper.ID
+		other   TopicHandler
+		expSame bool
+	}{
+		{
+			ID:      testhelper.MkID("same topic, same handler"),
+			other:   TopicHandler{Topic: "/topic", Handler: handler},
+			expSame: true,
+		},
+		{
+			ID:    testhelper.MkID("different topic, same handler"),
+			other: TopicHandler{Topic: "/other", Handler: handler},
+		},
+		{
+			ID:    testhelper.MkID("same topic, different closure"),
+			other: TopicHandler{Topic: "/topic", Handler: mkHandler()},
+		},
+		{
+			ID:    testhelper.MkID("same topic, DeliveryHandler"),
+			other: TopicHandler{Topic: "/topic", DeliveryHandler: dHandler},
+		},
+		{
+			ID:    testhelper.MkID("same topic, nil handler"),
+			other: TopicHandler{Topic: "/topic"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			testhelper.DiffBool(t, tc.IDStr(), "same",
+				th.Same(tc.other), tc.expSame)
+			testhelper.DiffBool(t, tc.IDStr(), "same (reversed)",
+				tc.other.Same(th), tc.expSame)
+		})
+	}
+}
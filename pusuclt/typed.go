@@ -0,0 +1,150 @@
+package pusuclt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+// TypedHandler is a function that will be called with the decoded payload
+// of each message received on a topic subscribed to with SubscribeTyped
+type TypedHandler[T any] func(topic pusu.Topic, v T)
+
+// PublishTyped encodes the value with the Codec and publishes it on the
+// topic. See Client.Publish for details.
+func PublishTyped[T any](
+	p Publisher,
+	cb Callback,
+	codec Codec[T],
+	topic pusu.Topic,
+	v T,
+	opts ...PublishOpt,
+) error {
+	payload, err := codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("couldn't encode the payload for %q: %w", topic, err)
+	}
+
+	return p.Publish(cb, topic, payload, opts...)
+}
+
+// PublishTypedCtx behaves like PublishTyped but waits for the reply from
+// the pub/sub server. See Client.PublishCtx for details.
+func PublishTypedCtx[T any](
+	ctx context.Context,
+	p Publisher,
+	codec Codec[T],
+	topic pusu.Topic,
+	v T,
+	opts ...PublishOpt,
+) error {
+	payload, err := codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("couldn't encode the payload for %q: %w", topic, err)
+	}
+
+	return p.PublishCtx(ctx, topic, payload, opts...)
+}
+
+// SubscribeTyped subscribes to the topic and calls the handler with the
+// payload of each message received, decoded with the Codec. A message whose
+// payload cannot be decoded is not passed to the handler; if the Subscriber
+// is a DecodeErrorReporter the error is passed to its DecodeError method.
+// It returns the TopicHandler subscribed which can be passed to Unsubscribe
+// to cancel the subscription. See Client.Subscribe for details.
+func SubscribeTyped[T any](
+	s Subscriber,
+	cb Callback,
+	codec Codec[T],
+	topic pusu.Topic,
+	h TypedHandler[T],
+) (TopicHandler, error) {
+	th, err := typedTopicHandler(s, codec, topic, h)
+	if err != nil {
+		return TopicHandler{}, err
+	}
+
+	if err := s.Subscribe(cb, th); err != nil {
+		return TopicHandler{}, err
+	}
+
+	return th, nil
+}
+
+// SubscribeTypedCtx behaves like SubscribeTyped but waits for the reply
+// from the pub/sub server. See Client.SubscribeCtx for details. Note that
+// if the context finishes first the TopicHandler is still returned, with
+// the error, as the handler may have been added and so may need to be
+// unsubscribed.
+func SubscribeTypedCtx[T any](
+	ctx context.Context,
+	s Subscriber,
+	codec Codec[T],
+	topic pusu.Topic,
+	h TypedHandler[T],
+) (TopicHandler, error) {
+	th, err := typedTopicHandler(s, codec, topic, h)
+	if err != nil {
+		return TopicHandler{}, err
+	}
+
+	err = s.SubscribeCtx(ctx, th)
+	if err != nil &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded) {
+		return TopicHandler{}, err
+	}
+
+	return th, err
+}
+
+// typedTopicHandler returns a TopicHandler whose DeliveryHandler decodes
+// the payload and passes the value to the TypedHandler. Decoding errors are
+// passed to the Subscriber if it is a DecodeErrorReporter.
+func typedTopicHandler[T any](
+	s Subscriber,
+	codec Codec[T],
+	topic pusu.Topic,
+	h TypedHandler[T],
+) (TopicHandler, error) {
+	if h == nil {
+		return TopicHandler{},
+			fmt.Errorf("the TypedHandler for Topic %q is nil", topic)
+	}
+
+	der, _ := s.(DecodeErrorReporter)
+
+	return TopicHandler{
+		Topic: topic,
+		DeliveryHandler: func(d Delivery) {
+			v, err := codec.Unmarshal(d.Payload)
+			if err != nil {
+				if der != nil {
+					der.DecodeError(d, err)
+				}
+
+				return
+			}
+
+			h(d.Topic, v)
+		},
+	}, nil
+}
+
+// DecodeError logs the failure to decode the payload of the Delivery and
+// passes the error to the ConnInfo DecodeError func, if any. It is called
+// for the messages that a handler subscribed with SubscribeTyped could not
+// decode.
+func (c *Client) DecodeError(d Delivery, err error) {
+	err = fmt.Errorf("couldn't decode the payload on %q: %w", d.Topic, err)
+
+	c.log().Error("the message was not delivered to the handler",
+		d.Topic.Attr(),
+		pusu.ErrorAttr(err))
+
+	if c.cci.DecodeError != nil {
+		c.cci.DecodeError(d, err)
+	}
+}
@@ -0,0 +1,72 @@
+package pusuclt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestTyped(t *testing.T) {
+	const topic = pusu.Topic("/typed")
+
+	cc := makeTestClient(&bytes.Buffer{}, &bytes.Buffer{}, nil, nil)
+	cc.runExit = make(chan struct{})
+
+	var published []byte
+
+	go fakeServer(cc, func(cc *Client, msg *pusu.Message) {
+		published = msg.Payload
+		cc.callback(msg.MsgID, nil)
+	})
+	defer close(cc.sendChan)
+
+	var (
+		got        []testCodecVal
+		decodeErrs []error
+	)
+
+	cc.cci.DecodeError = func(_ Delivery, err error) {
+		decodeErrs = append(decodeErrs, err)
+	}
+
+	codec := JSONCodec[testCodecVal]{}
+
+	th, err := SubscribeTypedCtx(t.Context(), cc, codec, topic,
+		func(_ pusu.Topic, v testCodecVal) { got = append(got, v) })
+	testhelper.CheckError(t, "SubscribeTypedCtx", err, false, nil)
+
+	_, err = SubscribeTyped[testCodecVal](cc, nil, codec, topic, nil)
+	testhelper.CheckError(t, "SubscribeTyped - nil handler", err, true,
+		[]string{`the TypedHandler for Topic "/typed" is nil`})
+
+	err = PublishTypedCtx(t.Context(), cc, codec, topic,
+		testCodecVal{Name: "a", Count: 1})
+	testhelper.CheckError(t, "PublishTypedCtx", err, false, nil)
+
+	cc.callMsgHandlers(topic, []byte(`{"Name":"b","Count":2}`))
+	cc.callMsgHandlers(topic, []byte(`not JSON`))
+
+	if testhelper.DiffInt(t, "handler calls", "count", len(got), 1) {
+		return
+	}
+
+	testhelper.DiffString(t, "handler value", "name", got[0].Name, "b")
+
+	if testhelper.DiffInt(t, "decode errors", "count", len(decodeErrs), 1) {
+		return
+	}
+
+	testhelper.CheckError(t, "decode error", decodeErrs[0], true,
+		[]string{`couldn't decode the payload on "/typed"`})
+
+	if len(published) == 0 {
+		t.Error("PublishTypedCtx: no message was published")
+	}
+
+	err = cc.UnsubscribeCtx(t.Context(), th)
+	testhelper.CheckError(t, "UnsubscribeCtx", err, false, nil)
+	testhelper.DiffInt(t, "after UnsubscribeCtx", "topic count",
+		len(cc.handlers), 0)
+}
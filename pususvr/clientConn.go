@@ -0,0 +1,545 @@
+package pususvr
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+// clientConn holds the details of a single connection from a pub/sub
+// client.
+type clientConn struct {
+	svr    *Server
+	conn   io.ReadWriteCloser
+	logger *slog.Logger
+
+	clientID  string         // the client ID given in the Start message
+	namespace pusu.Namespace // the namespace given in the Start message
+	protoVsn  pusu.ProtoVsn  // the protocol version negotiated at Start
+
+	// topics records the topics subscribed to by the client. It is only
+	// accessed while holding the Server mutex.
+	topics map[pusu.Topic]bool
+
+	sendChan  chan *pusu.Message // messages to be written to the client
+	doneChan  chan struct{}      // closed when the connection is finished
+	closeOnce sync.Once
+}
+
+// newClientConn creates a new clientConn for the connection
+func newClientConn(s *Server, conn io.ReadWriteCloser) *clientConn {
+	logger := s.logger
+
+	if nc, ok := conn.(net.Conn); ok {
+		logger = logger.With(pusu.NetAddressAttr(nc.RemoteAddr().String()))
+	}
+
+	return &clientConn{
+		svr:      s,
+		conn:     conn,
+		logger:   logger,
+		topics:   make(map[pusu.Topic]bool),
+		sendChan: make(chan *pusu.Message, s.si.sendQueueSize()),
+		doneChan: make(chan struct{}),
+	}
+}
+
+// close marks the connection as finished and closes the underlying
+// connection without waiting for any queued messages to be written. It is
+// safe to call this more than once.
+func (cc *clientConn) close() {
+	cc.finish()
+	cc.closeConn()
+}
+
+// closeConn closes the underlying connection, logging any error other than
+// that the connection is already closed.
+func (cc *clientConn) closeConn() {
+	if err := cc.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		cc.logger.Error("problem closing the client connection",
+			pusu.ErrorAttr(err))
+	}
+}
+
+// send queues the message to be written to the client. If the connection
+// is finished the message is discarded.
+func (cc *clientConn) send(msg *pusu.Message) {
+	select {
+	case cc.sendChan <- msg:
+	case <-cc.doneChan:
+	}
+}
+
+// publish queues the Publish message to be written to the client without
+// waiting. If the send queue is full the client is not reading the messages
+// published to it quickly enough and it is disconnected rather than making
+// the publisher wait.
+func (cc *clientConn) publish(msg *pusu.Message) {
+	select {
+	case cc.sendChan <- msg:
+	case <-cc.doneChan:
+	default:
+		cc.logger.Error("the client is not keeping up - disconnecting",
+			slog.Int(pusu.AttrPfx+"SendQueueSize", cap(cc.sendChan)))
+		cc.close()
+	}
+}
+
+// sendAck queues an Ack message for the message with the given id
+func (cc *clientConn) sendAck(id pusu.MsgID) {
+	cc.send(&pusu.Message{
+		MT:    pusu.Ack,
+		MsgID: id,
+	})
+}
+
+// sendError queues an Error message reporting the error for the message
+// with the given id. The fatal flag should be set if the connection will be
+// closed after the Error is sent.
+func (cc *clientConn) sendError(id pusu.MsgID, err error, fatal bool) {
+	if msg := cc.makeErrorMsg(id, err, fatal); msg != nil {
+		cc.send(msg)
+	}
+}
+
+// makeErrorMsg logs the error and returns an Error message reporting it for
+// the message with the given id. It returns nil if the Error message could
+// not be constructed.
+func (cc *clientConn) makeErrorMsg(id pusu.MsgID, err error, fatal bool,
+) *pusu.Message {
+	cc.logger.Error("client message rejected",
+		id.Attr(),
+		pusu.ErrorCodeOf(err).Attr(),
+		slog.Bool(pusu.AttrPfx+"Fatal", fatal),
+		pusu.ErrorAttr(err))
+
+	msg := &pusu.Message{
+		MT:    pusu.Error,
+		MsgID: id,
+	}
+
+	if merr := msg.Marshal(
+		pusu.MakeErrorMsgPayload(err, fatal), cc.logger); merr != nil {
+		return nil
+	}
+
+	return msg
+}
+
+// serve reads and handles the messages from the client until the
+// connection fails or the client breaks the protocol. Every message other
+// than a Ping is answered with either an Ack or an Error. If the error is
+// fatal (see isFatal) the connection is closed once the Error has been
+// written.
+func (cc *clientConn) serve() {
+	if err := cc.start(); err != nil {
+		cc.finish()
+		cc.closeConn()
+
+		return
+	}
+
+	writerDone := make(chan struct{})
+
+	go func() {
+		defer close(writerDone)
+
+		cc.writeMsgs()
+	}()
+
+	defer func() {
+		cc.finish()
+		<-writerDone
+	}()
+
+	for {
+		msg, err := pusu.ReadMsgWithLimit(cc.conn, cc.svr.si.maxPayload())
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+				cc.logger.Error("read failure on the connection",
+					pusu.ErrorAttr(err))
+			}
+
+			return
+		}
+
+		if err := cc.handleMessageByType(msg); err != nil {
+			fatal := cc.isFatal(err)
+			cc.sendError(msg.MsgID, err, fatal)
+
+			if fatal {
+				return
+			}
+		}
+	}
+}
+
+// isFatal reports whether the error in handling a message should close the
+// connection. Before protocol version ProtoVsnNonFatalErrors every error is
+// fatal, after that only protocol errors are.
+func (cc *clientConn) isFatal(err error) bool {
+	return cc.protoVsn < pusu.ProtoVsnNonFatalErrors ||
+		pusu.ErrorCodeOf(err) == pusu.ErrCodeProtocol
+}
+
+// finish signals the writer that no more messages will be sent by the
+// reader. The writer will write any remaining queued messages and then
+// close the connection.
+func (cc *clientConn) finish() {
+	cc.closeOnce.Do(func() {
+		close(cc.doneChan)
+	})
+}
+
+// writeMsgs writes the queued messages to the client until the connection
+// is finished. Once finished it writes any messages still queued and then
+// closes the connection.
+func (cc *clientConn) writeMsgs() {
+	defer cc.closeConn()
+
+	for {
+		select {
+		case msg := <-cc.sendChan:
+			if err := cc.write(msg); err != nil {
+				return
+			}
+		case <-cc.doneChan:
+			for {
+				select {
+				case msg := <-cc.sendChan:
+					if err := cc.write(msg); err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write writes the message to the client, logging any error. A message
+// too big to be sent to the client without being split into fragments is
+// discarded if the client's protocol version does not support fragments.
+func (cc *clientConn) write(msg *pusu.Message) error {
+	if len(msg.Payload) > pusu.MaxMessagePayload &&
+		cc.protoVsn < pusu.ProtoVsnFragments {
+		cc.logger.Error("message discarded - too big for the client",
+			msg.MT.Attr(),
+			cc.protoVsn.Attr(),
+			slog.Int(pusu.AttrPfx+"PayloadSize", len(msg.Payload)))
+
+		return nil
+	}
+
+	if cc.svr.si.WriteTimeout > 0 {
+		cc.setWriteDeadline(time.Now().Add(cc.svr.si.WriteTimeout))
+	}
+
+	err := msg.Write(cc.conn)
+	if err != nil {
+		cc.logger.Error("couldn't write the message to the client",
+			msg.MT.Attr(),
+			pusu.ErrorAttr(err))
+	}
+
+	return err
+}
+
+// setReadDeadline sets the read deadline on the connection if it supports
+// deadlines. The zero time clears the deadline.
+func (cc *clientConn) setReadDeadline(t time.Time) {
+	type deadliner interface {
+		SetReadDeadline(t time.Time) error
+	}
+
+	if dl, ok := cc.conn.(deadliner); ok {
+		_ = dl.SetReadDeadline(t)
+	}
+}
+
+// setWriteDeadline sets the write deadline on the connection if it supports
+// deadlines
+func (cc *clientConn) setWriteDeadline(t time.Time) {
+	type deadliner interface {
+		SetWriteDeadline(t time.Time) error
+	}
+
+	if dl, ok := cc.conn.(deadliner); ok {
+		_ = dl.SetWriteDeadline(t)
+	}
+}
+
+// start reads the first message from the client, which must be a valid
+// Start message, and replies to it. It returns a non-nil error if the
+// message could not be read or was not valid, in which case an Error will
+// have been written to the client. This is called before the message
+// writing goroutine is started and so it writes its reply directly.
+func (cc *clientConn) start() error {
+	if cc.svr.si.StartTimeout > 0 {
+		cc.setReadDeadline(time.Now().Add(cc.svr.si.StartTimeout))
+		defer cc.setReadDeadline(time.Time{})
+	}
+
+	msg, err := pusu.ReadMsgWithLimit(cc.conn, cc.svr.si.maxPayload())
+	if err != nil {
+		cc.logger.Error("couldn't read the Start message",
+			pusu.ErrorAttr(err))
+
+		return err
+	}
+
+	if err := cc.handleStart(msg); err != nil {
+		if errMsg := cc.makeErrorMsg(msg.MsgID, err, true); errMsg != nil {
+			_ = cc.write(errMsg)
+		}
+
+		return err
+	}
+
+	ack := &pusu.Message{
+		MT:    pusu.Ack,
+		MsgID: msg.MsgID,
+	}
+
+	if err := ack.Marshal(
+		&pusu.StartAckMsgPayload{
+			ProtocolVersion: int32(cc.protoVsn),
+			ServerId:        cc.svr.si.ServerID,
+			Capabilities:    capabilities(cc.protoVsn).Strings(),
+		}, cc.logger); err != nil {
+		return err
+	}
+
+	return cc.write(ack)
+}
+
+// capabilities returns the capabilities supported by the server for the
+// protocol version
+func capabilities(pv pusu.ProtoVsn) pusu.Capabilities {
+	caps := pusu.Capabilities{
+		pusu.CapWildcards,
+		pusu.CapRetain,
+		pusu.CapHeaders,
+	}
+
+	if pv >= pusu.ProtoVsnFragments {
+		caps = append(caps, pusu.CapFragments)
+	}
+
+	return caps
+}
+
+// handleStart checks the Start message and records the details from it. It
+// returns a non-nil error if the message is not a valid Start message.
+func (cc *clientConn) handleStart(msg pusu.Message) error {
+	if msg.MT != pusu.Start {
+		return pusu.NewCodedError(pusu.ErrCodeProtocol,
+			"protocol error - the first message must be %s, not %s",
+			pusu.Start, msg.MT)
+	}
+
+	var smp pusu.StartMsgPayload
+	if err := msg.Unmarshal(&smp, cc.logger); err != nil {
+		return err
+	}
+
+	pv, err := pusu.ProtoVsn(smp.ProtocolVersion).Negotiate()
+	if err != nil {
+		return err
+	}
+
+	ns := pusu.Namespace(smp.Namespace)
+	if ns == "" {
+		return pusu.NewCodedError(pusu.ErrCodeNamespace,
+			"the namespace must not be empty")
+	}
+
+	if !cc.svr.si.namespacePermitted(ns) {
+		return pusu.NewCodedError(pusu.ErrCodeNamespace,
+			"the namespace %q is not permitted", ns).
+			WithDetail("namespace", string(ns))
+	}
+
+	cc.clientID = smp.ClientId
+	cc.namespace = ns
+	cc.protoVsn = pv
+	cc.logger = cc.logger.With(ns.Attr())
+
+	cc.logger.Info("client started",
+		pv.Attr(),
+		slog.String(pusu.AttrPfx+"ClientID", cc.clientID))
+
+	return nil
+}
+
+// handleMessageByType switches on the message type handling each type
+// appropriately. It returns a non-nil error if the message is not properly
+// handled.
+func (cc *clientConn) handleMessageByType(msg pusu.Message) error {
+	var err error
+
+	var afterAck []*pusu.Message // messages to be sent after the Ack
+
+	switch msg.MT {
+	case pusu.Publish:
+		err = cc.handlePublish(msg)
+	case pusu.Subscribe:
+		afterAck, err = cc.handleSubscribe(msg)
+	case pusu.Unsubscribe:
+		err = cc.handleUnsubscribe(msg)
+	case pusu.Ping:
+		cc.send(&msg)
+
+		return nil
+	case pusu.Start:
+		err = pusu.NewCodedError(pusu.ErrCodeProtocol,
+			"protocol error - the client has already started")
+	default:
+		err = pusu.NewCodedError(pusu.ErrCodeProtocol,
+			"protocol error - unexpected message: %s", msg.MT)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	cc.sendAck(msg.MsgID)
+
+	for _, m := range afterAck {
+		cc.send(m)
+	}
+
+	return nil
+}
+
+// handlePublish checks the publication and sends it to the subscribers,
+// having first recorded the client ID of the publisher in it. If the
+// publication is to be retained it is recorded before being sent. If the
+// publication is to clear the retained value then the value is discarded
+// and the subscribers able to understand it are told.
+func (cc *clientConn) handlePublish(msg pusu.Message) error {
+	var pmp pusu.PublishMsgPayload
+	if err := msg.Unmarshal(&pmp, cc.logger); err != nil {
+		return err
+	}
+
+	t := pusu.Topic(pmp.Topic)
+	if err := t.CheckConcrete(); err != nil {
+		return err
+	}
+
+	if pmp.ClearRetained {
+		if pmp.Retain || len(pmp.Payload) > 0 {
+			return pusu.NewCodedError(pusu.ErrCodeBadMessage,
+				"a message clearing the retained value for %q"+
+					" must not retain a payload",
+				t).WithDetail("topic", string(t))
+		}
+
+		if cc.svr.clearRetained(cc.namespace, t) {
+			pmp.PublisherId = cc.clientID
+
+			clearMsg := &pusu.Message{
+				MT:    pusu.Publish,
+				MsgID: pusu.NoMsgID,
+			}
+
+			if err := clearMsg.Marshal(&pmp, cc.logger); err != nil {
+				return err
+			}
+
+			cc.svr.publishClear(cc.namespace, t, clearMsg)
+		}
+
+		return nil
+	}
+
+	pmp.PublisherId = cc.clientID
+	pmp.Retained = false
+	pmp.Subscriptions = nil
+
+	pubMsg := &pusu.Message{
+		MT:    pusu.Publish,
+		MsgID: pusu.NoMsgID,
+	}
+
+	if err := pubMsg.Marshal(&pmp, cc.logger); err != nil {
+		return err
+	}
+
+	if pmp.Retain {
+		cc.svr.retain(cc.namespace, t, &pmp)
+	}
+
+	cc.svr.publish(cc.namespace, t, pubMsg)
+
+	return nil
+}
+
+// handleSubscribe records the subscriptions in the Subscribe message. It
+// returns a Publish message for each retained value for the topics; these
+// should be sent after the Subscribe message has been acknowledged.
+func (cc *clientConn) handleSubscribe(msg pusu.Message,
+) ([]*pusu.Message, error) {
+	topics, err := cc.subscriptionTopics(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	retained, err := cc.svr.subscribe(cc, topics)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]*pusu.Message, 0, len(retained))
+
+	for _, rv := range retained {
+		m, err := rv.message(cc)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs = append(msgs, m)
+	}
+
+	return msgs, nil
+}
+
+// handleUnsubscribe removes the subscriptions in the Unsubscribe message
+func (cc *clientConn) handleUnsubscribe(msg pusu.Message) error {
+	topics, err := cc.subscriptionTopics(msg)
+	if err != nil {
+		return err
+	}
+
+	return cc.svr.unsubscribe(cc, topics)
+}
+
+// subscriptionTopics extracts the topics from the (un)subscription message
+// and checks them
+func (cc *clientConn) subscriptionTopics(msg pusu.Message,
+) ([]pusu.Topic, error) {
+	var smp pusu.SubscriptionMsgPayload
+	if err := msg.Unmarshal(&smp, cc.logger); err != nil {
+		return nil, err
+	}
+
+	topics := make([]pusu.Topic, 0, len(smp.Subs))
+
+	for _, sub := range smp.Subs {
+		t := pusu.Topic(sub.Topic)
+		if err := t.Check(); err != nil {
+			return nil, err
+		}
+
+		topics = append(topics, t)
+	}
+
+	return topics, nil
+}
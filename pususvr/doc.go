@@ -0,0 +1,7 @@
+/*
+Package pususvr provides a reference implementation of the publish/subscribe
+server (the message broker). It accepts connections from pub/sub clients,
+such as those provided by the pusuclt package, and distributes the messages
+they publish to the clients that have subscribed to the topics.
+*/
+package pususvr
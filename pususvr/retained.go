@@ -0,0 +1,122 @@
+package pususvr
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"google.golang.org/protobuf/proto"
+)
+
+// topicValueMap maps between a Topic and the retained Publish message
+// payload for that topic
+type topicValueMap map[pusu.Topic]*pusu.PublishMsgPayload
+
+// retainedMap maps between a Namespace and the values retained in it
+type retainedMap map[pusu.Namespace]topicValueMap
+
+// retainedValue is a retained Publish message payload together with the
+// subscribed topics which it matches
+type retainedValue struct {
+	pmp  *pusu.PublishMsgPayload
+	subs []pusu.Topic
+}
+
+// retain records the Publish message payload as the retained value for the
+// topic in the namespace. The payload must not be changed afterwards.
+func (s *Server) retain(ns pusu.Namespace, t pusu.Topic,
+	pmp *pusu.PublishMsgPayload,
+) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	tvm, ok := s.retained[ns]
+	if !ok {
+		tvm = make(topicValueMap)
+		s.retained[ns] = tvm
+	}
+
+	tvm[t] = pmp
+}
+
+// clearRetained discards any value retained for the topic in the
+// namespace. It returns true if there was a value to discard.
+func (s *Server) clearRetained(ns pusu.Namespace, t pusu.Topic) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	tvm, ok := s.retained[ns]
+	if !ok {
+		return false
+	}
+
+	if _, ok := tvm[t]; !ok {
+		return false
+	}
+
+	delete(tvm, t)
+
+	if len(tvm) == 0 {
+		delete(s.retained, ns)
+	}
+
+	return true
+}
+
+// retainedValues returns the retained Publish message payloads for all the
+// topics in the namespace matching any of the subscribed topics. The
+// payloads are given in topic order and each appears only once, however
+// many of the subscribed topics it matches. The Server mutex must be held
+// when this is called.
+func (s *Server) retainedValues(ns pusu.Namespace, subs []pusu.Topic,
+) []retainedValue {
+	tvm := s.retained[ns]
+	if len(tvm) == 0 {
+		return nil
+	}
+
+	var values []retainedValue
+
+	for _, t := range slices.Sorted(maps.Keys(tvm)) {
+		rv := retainedValue{pmp: tvm[t]}
+
+		for _, st := range subs {
+			if st.Matches(t) {
+				rv.subs = append(rv.subs, st)
+			}
+		}
+
+		if len(rv.subs) > 0 {
+			values = append(values, rv)
+		}
+	}
+
+	return values
+}
+
+// message returns the Publish message giving the retained value to the
+// client. If the client's protocol version supports it the message is
+// marked as retained and gives the subscribed topics it is for.
+func (rv retainedValue) message(cc *clientConn) (*pusu.Message, error) {
+	pmp := rv.pmp
+
+	if cc.protoVsn >= pusu.ProtoVsnRetainedValues {
+		pmp = proto.CloneOf(rv.pmp)
+		pmp.Retained = true
+
+		for _, st := range rv.subs {
+			pmp.Subscriptions = append(pmp.Subscriptions, string(st))
+		}
+	}
+
+	msg := &pusu.Message{
+		MT:    pusu.Publish,
+		MsgID: pusu.NoMsgID,
+	}
+
+	if err := msg.Marshal(pmp, cc.logger); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,228 @@
+package pususvr
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+var errSvrClosed = errors.New("the pub/sub server has been closed")
+
+// Server is a publish/subscribe server. It accepts connections from pub/sub
+// clients and distributes the messages published by them to the clients
+// subscribed to the topics.
+type Server struct {
+	mtx sync.Mutex
+
+	si *SvrInfo
+
+	listener net.Listener         // the listener, set by Serve
+	conns    map[*clientConn]bool // the currently open client connections
+	subs     namespaceMap         // the subscriptions, by namespace
+	retained retainedMap          // the retained values, by namespace
+	closed   bool                 // set when the server has been closed
+	wg       sync.WaitGroup       // counts the running client connections
+	logger   *slog.Logger
+}
+
+// NewServer creates an instance of a Server. The server will not accept
+// connections until one of the ListenAndServe, Serve or ServeConn methods
+// is called.
+//
+// The logger is used to record log messages.
+//
+// The info argument holds the details needed to run the server.
+func NewServer(logger *slog.Logger, info *SvrInfo) *Server {
+	return &Server{
+		si:       info,
+		conns:    make(map[*clientConn]bool),
+		subs:     make(namespaceMap),
+		retained: make(retainedMap),
+		logger:   logger.With(pusu.NetAddressAttr(info.Address)),
+	}
+}
+
+// ListenAndServe listens on the TLS network address given in the SvrInfo
+// and then calls Serve to handle connections from clients.
+func (s *Server) ListenAndServe() error {
+	l, err := s.Listen()
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Listen returns a TLS listener on the network address given in the
+// SvrInfo. Client certificates are required and are verified against the
+// certificate pool from the SvrInfo CertInfo. The listener can be passed to
+// Serve; this allows the caller to find the address actually listened on
+// before serving connections.
+func (s *Server) Listen() (net.Listener, error) {
+	if err := s.si.CertInfo.Populate(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		ClientCAs:    s.si.CertInfo.CertPool(),
+		Certificates: []tls.Certificate{s.si.CertInfo.Cert()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS13,
+	}
+
+	l, err := tls.Listen("tcp", s.si.Address, tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't listen on %q: %w", s.si.Address, err)
+	}
+
+	return l, nil
+}
+
+// Serve accepts connections on the listener and serves each of them in a
+// new goroutine. It returns when the listener fails or the Server is
+// closed. The listener is closed before Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.mtx.Lock()
+
+	if s.closed {
+		s.mtx.Unlock()
+
+		_ = l.Close()
+
+		return errSvrClosed
+	}
+
+	s.listener = l
+	s.mtx.Unlock()
+
+	defer l.Close()
+
+	s.logger.Info("serving", pusu.NetAddressAttr(l.Addr().String()))
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return errSvrClosed
+			}
+
+			return fmt.Errorf("couldn't accept a connection: %w", err)
+		}
+
+		go s.ServeConn(conn) //nolint:errcheck
+	}
+}
+
+// ServeConn serves the single client connection, returning when the
+// connection is closed. The connection is closed before ServeConn returns.
+// This can be used to serve connections made by means other than the
+// Server's own listener, for instance, an in-memory connection.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) error {
+	cc, err := s.addConn(conn)
+	if err != nil {
+		_ = conn.Close()
+
+		return err
+	}
+
+	defer s.removeConn(cc)
+
+	cc.serve()
+
+	return nil
+}
+
+// addConn creates a new clientConn and records it. It returns an error if
+// the server has been closed.
+func (s *Server) addConn(conn io.ReadWriteCloser) (*clientConn, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closed {
+		return nil, errSvrClosed
+	}
+
+	cc := newClientConn(s, conn)
+	s.conns[cc] = true
+	s.wg.Add(1)
+
+	return cc, nil
+}
+
+// removeConn removes the clientConn and all its subscriptions from the
+// Server
+func (s *Server) removeConn(cc *clientConn) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.unsubscribeAll(cc)
+	delete(s.conns, cc)
+	s.wg.Done()
+}
+
+// isClosed returns true if the server has been closed
+func (s *Server) isClosed() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.closed
+}
+
+// Close stops the Server from accepting new connections, closes all the
+// existing client connections and waits for them to finish. It returns a
+// non-nil error if the server has already been closed.
+func (s *Server) Close() error {
+	s.mtx.Lock()
+
+	if s.closed {
+		s.mtx.Unlock()
+
+		return errSvrClosed
+	}
+
+	s.closed = true
+
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+
+	for cc := range s.conns {
+		cc.close()
+	}
+
+	s.mtx.Unlock()
+
+	s.wg.Wait()
+
+	s.logger.Info("server closed")
+
+	return nil
+}
+
+// publish sends the message to all the clients in the namespace having a
+// subscription to the topic. It does not wait for the subscribers; any
+// subscriber not keeping up is disconnected.
+func (s *Server) publish(ns pusu.Namespace, t pusu.Topic, msg *pusu.Message) {
+	for _, cc := range s.subscribers(ns, t) {
+		cc.publish(msg)
+	}
+}
+
+// publishClear sends the message clearing the retained value for the topic
+// to the clients in the namespace subscribed to the topic whose protocol
+// version supports it; earlier clients would pass it to their handlers.
+func (s *Server) publishClear(ns pusu.Namespace, t pusu.Topic,
+	msg *pusu.Message,
+) {
+	for _, cc := range s.subscribers(ns, t) {
+		if cc.protoVsn >= pusu.ProtoVsnRetainedValues {
+			cc.publish(msg)
+		}
+	}
+}
@@ -0,0 +1,775 @@
+package pususvr
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	testNamespace      = pusu.Namespace("test-namespace")
+	testOtherNamespace = pusu.Namespace("test-other-namespace")
+	testTimeout        = 5 * time.Second
+)
+
+// testConn is the client end of a connection to a test Server
+type testConn struct {
+	t      *testing.T
+	id     string
+	conn   net.Conn
+	nextID pusu.MsgID
+}
+
+// makeTestServer returns a Server which permits the test namespaces. The
+// SvrInfo is passed to each of the setters before the Server is made.
+func makeTestServer(t *testing.T, setters ...func(*SvrInfo)) *Server {
+	t.Helper()
+
+	info := NewSvrInfo()
+	info.Namespaces = []pusu.Namespace{testNamespace, testOtherNamespace}
+
+	for _, set := range setters {
+		set(info)
+	}
+
+	s := NewServer(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)), info)
+
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+// connect returns a new testConn being served by the Server
+func connect(t *testing.T, s *Server, id string) *testConn {
+	t.Helper()
+
+	clientEnd, svrEnd := net.Pipe()
+
+	go s.ServeConn(svrEnd) //nolint:errcheck
+
+	t.Cleanup(func() { _ = clientEnd.Close() })
+
+	return &testConn{t: t, id: id, conn: clientEnd}
+}
+
+// start connects a testConn and sends a valid Start message for the
+// namespace, checking that it is acknowledged
+func start(t *testing.T, s *Server, id string, ns pusu.Namespace) *testConn {
+	t.Helper()
+
+	tc := connect(t, s, id)
+	msgID := tc.send(pusu.Start, &pusu.StartMsgPayload{
+		ProtocolVersion: pusu.CurrentProtoVsn,
+		ClientId:        id,
+		Namespace:       string(ns),
+	})
+	tc.expectAck(msgID)
+
+	return tc
+}
+
+// send marshals the payload and writes the message to the server returning
+// the message ID used
+func (tc *testConn) send(mt pusu.MsgType, payload proto.Message) pusu.MsgID {
+	tc.t.Helper()
+
+	tc.nextID++
+	msg := pusu.Message{MT: mt, MsgID: tc.nextID}
+
+	if payload != nil {
+		var err error
+
+		msg.Payload, err = proto.Marshal(payload)
+		if err != nil {
+			tc.t.Fatalf("%s: couldn't marshal the %s payload: %s",
+				tc.id, mt, err)
+		}
+	}
+
+	_ = tc.conn.SetWriteDeadline(time.Now().Add(testTimeout))
+
+	if err := msg.Write(tc.conn); err != nil {
+		tc.t.Fatalf("%s: couldn't write the %s message: %s", tc.id, mt, err)
+	}
+
+	return msg.MsgID
+}
+
+// read reads the next message from the server
+func (tc *testConn) read() pusu.Message {
+	tc.t.Helper()
+
+	_ = tc.conn.SetReadDeadline(time.Now().Add(testTimeout))
+
+	msg, err := pusu.ReadMsg(tc.conn)
+	if err != nil {
+		tc.t.Fatalf("%s: couldn't read the message: %s", tc.id, err)
+	}
+
+	return msg
+}
+
+// expect reads the next message and checks its type and message ID
+func (tc *testConn) expect(mt pusu.MsgType, msgID pusu.MsgID) pusu.Message {
+	tc.t.Helper()
+
+	msg := tc.read()
+	if msg.MT != mt || msg.MsgID != msgID {
+		tc.t.Log(tc.id)
+		tc.t.Logf("\t: expected: %s (MsgID: %d)", mt, msgID)
+		tc.t.Logf("\t:   actual: %s (MsgID: %d)", msg.MT, msg.MsgID)
+		tc.t.Error("\t: unexpected message")
+	}
+
+	return msg
+}
+
+// expectAck reads the next message and checks that it is an Ack for the
+// message
+func (tc *testConn) expectAck(msgID pusu.MsgID) {
+	tc.t.Helper()
+
+	tc.expect(pusu.Ack, msgID)
+}
+
+// expectError reads the next message and checks that it is an Error for the
+// message with the expected code and text
+func (tc *testConn) expectError(msgID pusu.MsgID,
+	expCode pusu.ErrorCode, expText string,
+) {
+	tc.t.Helper()
+
+	msg := tc.expect(pusu.Error, msgID)
+
+	var emp pusu.ErrorMsgPayload
+	if err := proto.Unmarshal(msg.Payload, &emp); err != nil {
+		tc.t.Fatalf("%s: couldn't unmarshal the Error payload: %s", tc.id, err)
+	}
+
+	if !strings.Contains(emp.Error, expText) {
+		tc.t.Log(tc.id)
+		tc.t.Logf("\t: expected error containing: %q", expText)
+		tc.t.Logf("\t:                    actual: %q", emp.Error)
+		tc.t.Error("\t: unexpected error text")
+	}
+
+	if code := pusu.ErrorCode(emp.Code); code != expCode {
+		tc.t.Log(tc.id)
+		tc.t.Logf("\t: expected error code: %s", expCode)
+		tc.t.Logf("\t:   actual error code: %s", code)
+		tc.t.Error("\t: unexpected error code")
+	}
+}
+
+// expectPublish reads the next message and checks that it is a Publish
+// message with the expected topic and payload. It returns the Publish
+// message payload.
+func (tc *testConn) expectPublish(topic pusu.Topic, payload string,
+) *pusu.PublishMsgPayload {
+	tc.t.Helper()
+
+	msg := tc.expect(pusu.Publish, pusu.NoMsgID)
+
+	var pmp pusu.PublishMsgPayload
+	if err := proto.Unmarshal(msg.Payload, &pmp); err != nil {
+		tc.t.Fatalf("%s: couldn't unmarshal the Publish payload: %s",
+			tc.id, err)
+	}
+
+	testhelper.DiffString(tc.t, tc.id, "topic", pmp.Topic, string(topic))
+	testhelper.DiffString(tc.t, tc.id, "payload", string(pmp.Payload), payload)
+
+	return &pmp
+}
+
+// expectNothingPending checks that there are no messages pending from the
+// server by sending a Ping and checking that the next message is the reply
+func (tc *testConn) expectNothingPending() {
+	tc.t.Helper()
+
+	tc.send(pusu.Ping, &pusu.PingMsgPayload{PingTime: timestamppb.Now()})
+
+	if msg := tc.read(); msg.MT != pusu.Ping {
+		tc.t.Log(tc.id)
+		tc.t.Logf("\t: expected: %s", pusu.Ping)
+		tc.t.Logf("\t:   actual: %s", msg.MT)
+		tc.t.Error("\t: unexpected pending message")
+	}
+}
+
+// subscription makes a SubscriptionMsgPayload for the topics
+func subscription(topics ...pusu.Topic) *pusu.SubscriptionMsgPayload {
+	smp := &pusu.SubscriptionMsgPayload{}
+	for _, t := range topics {
+		smp.Subs = append(smp.Subs,
+			&pusu.SubscriptionMsgPayload_Sub{Topic: string(t)})
+	}
+
+	return smp
+}
+
+func TestServerStart(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		mt          pusu.MsgType
+		smp         *pusu.StartMsgPayload
+		expErrCode  pusu.ErrorCode
+		expErrText  string
+		expAccepted bool
+		expProtoVsn pusu.ProtoVsn
+		expCaps     pusu.Capabilities
+	}{
+		{
+			ID: testhelper.MkID("good Start"),
+			mt: pusu.Start,
+			smp: &pusu.StartMsgPayload{
+				ProtocolVersion: pusu.CurrentProtoVsn,
+				Namespace:       string(testNamespace),
+			},
+			expAccepted: true,
+			expProtoVsn: pusu.CurrentProtoVsn,
+			expCaps: pusu.Capabilities{
+				pusu.CapWildcards, pusu.CapRetain, pusu.CapHeaders,
+				pusu.CapFragments,
+			},
+		},
+		{
+			ID: testhelper.MkID("bad Start - not a Start message"),
+			mt: pusu.Subscribe,
+			smp: &pusu.StartMsgPayload{
+				ProtocolVersion: pusu.CurrentProtoVsn,
+				Namespace:       string(testNamespace),
+			},
+			expErrCode: pusu.ErrCodeProtocol,
+			expErrText: "the first message must be Start, not Subscribe",
+		},
+		{
+			ID: testhelper.MkID("bad Start - protocol version too small"),
+			mt: pusu.Start,
+			smp: &pusu.StartMsgPayload{
+				ProtocolVersion: 0,
+				Namespace:       string(testNamespace),
+			},
+			expErrCode: pusu.ErrCodeProtocol,
+			expErrText: "bad protocol version: 0 - too small",
+		},
+		{
+			ID: testhelper.MkID("good Start - newer protocol version"),
+			mt: pusu.Start,
+			smp: &pusu.StartMsgPayload{
+				ProtocolVersion: pusu.CurrentProtoVsn + 1,
+				Namespace:       string(testNamespace),
+			},
+			expAccepted: true,
+			expProtoVsn: pusu.CurrentProtoVsn,
+			expCaps: pusu.Capabilities{
+				pusu.CapWildcards, pusu.CapRetain, pusu.CapHeaders,
+				pusu.CapFragments,
+			},
+		},
+		{
+			ID: testhelper.MkID("good Start - old protocol version"),
+			mt: pusu.Start,
+			smp: &pusu.StartMsgPayload{
+				ProtocolVersion: pusu.ProtoVsnFragments - 1,
+				Namespace:       string(testNamespace),
+			},
+			expAccepted: true,
+			expProtoVsn: pusu.ProtoVsnFragments - 1,
+			expCaps: pusu.Capabilities{
+				pusu.CapWildcards, pusu.CapRetain, pusu.CapHeaders,
+			},
+		},
+		{
+			ID: testhelper.MkID("bad Start - empty namespace"),
+			mt: pusu.Start,
+			smp: &pusu.StartMsgPayload{
+				ProtocolVersion: pusu.CurrentProtoVsn,
+			},
+			expErrCode: pusu.ErrCodeNamespace,
+			expErrText: "the namespace must not be empty",
+		},
+		{
+			ID: testhelper.MkID("bad Start - namespace not permitted"),
+			mt: pusu.Start,
+			smp: &pusu.StartMsgPayload{
+				ProtocolVersion: pusu.CurrentProtoVsn,
+				Namespace:       "nonesuch",
+			},
+			expErrCode: pusu.ErrCodeNamespace,
+			expErrText: `the namespace "nonesuch" is not permitted`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			s := makeTestServer(t)
+			c := connect(t, s, tc.IDStr())
+
+			msgID := c.send(tc.mt, tc.smp)
+			if tc.expAccepted {
+				ack := c.expect(pusu.Ack, msgID)
+
+				var samp pusu.StartAckMsgPayload
+				if err := proto.Unmarshal(ack.Payload, &samp); err != nil {
+					t.Fatal("couldn't unmarshal the Start Ack payload:", err)
+				}
+
+				testhelper.DiffInt(t, tc.IDStr(), "protocol version",
+					samp.ProtocolVersion, int32(tc.expProtoVsn))
+				testhelper.DiffString(t, tc.IDStr(), "server ID",
+					samp.ServerId, "pususvr")
+				testhelper.DiffStringSlice(t, tc.IDStr(), "capabilities",
+					samp.Capabilities, tc.expCaps.Strings())
+				c.expectNothingPending()
+
+				return
+			}
+
+			c.expectError(msgID, tc.expErrCode, tc.expErrText)
+
+			_ = c.conn.SetReadDeadline(time.Now().Add(testTimeout))
+			if _, err := pusu.ReadMsg(c.conn); err == nil {
+				t.Log(tc.IDStr())
+				t.Error("\t: the connection should have been closed")
+			}
+		})
+	}
+}
+
+func TestServerPublish(t *testing.T) {
+	s := makeTestServer(t)
+
+	subscriber := start(t, s, "subscriber", testNamespace)
+	publisher := start(t, s, "publisher", testNamespace)
+	other := start(t, s, "other-namespace subscriber", testOtherNamespace)
+
+	subscriber.expectAck(
+		subscriber.send(pusu.Subscribe, subscription("/a", "/b")))
+	other.expectAck(other.send(pusu.Subscribe, subscription("/a")))
+
+	publisher.expectAck(publisher.send(pusu.Publish,
+		&pusu.PublishMsgPayload{Topic: "/a", Payload: []byte("hello")}))
+	subscriber.expectPublish("/a", "hello")
+
+	publisher.expectAck(publisher.send(pusu.Publish,
+		&pusu.PublishMsgPayload{Topic: "/c", Payload: []byte("nobody")}))
+
+	subscriber.expectAck(subscriber.send(pusu.Unsubscribe, subscription("/a")))
+
+	publisher.expectAck(publisher.send(pusu.Publish,
+		&pusu.PublishMsgPayload{Topic: "/a", Payload: []byte("gone")}))
+	publisher.expectAck(publisher.send(pusu.Publish,
+		&pusu.PublishMsgPayload{Topic: "/b", Payload: []byte("still here")}))
+	subscriber.expectPublish("/b", "still here")
+
+	subscriber.expectNothingPending()
+	publisher.expectNothingPending()
+	other.expectNothingPending()
+}
+
+func TestServerSlowSubscriber(t *testing.T) {
+	const queueSize = 2
+
+	s := makeTestServer(t, func(si *SvrInfo) { si.SendQueueSize = queueSize })
+
+	slow := start(t, s, "slow subscriber", testNamespace)
+	fast := start(t, s, "fast subscriber", testNamespace)
+	publisher := start(t, s, "publisher", testNamespace)
+
+	slow.expectAck(slow.send(pusu.Subscribe, subscription("/a")))
+	fast.expectAck(fast.send(pusu.Subscribe, subscription("/b")))
+
+	// the slow subscriber never reads so its queue fills and it is
+	// disconnected; the publisher is not held up
+	for range queueSize + 2 {
+		publisher.expectAck(publisher.send(pusu.Publish,
+			&pusu.PublishMsgPayload{Topic: "/a", Payload: []byte("slow")}))
+	}
+
+	publisher.expectAck(publisher.send(pusu.Publish,
+		&pusu.PublishMsgPayload{Topic: "/b", Payload: []byte("fast")}))
+	fast.expectPublish("/b", "fast")
+
+	_ = slow.conn.SetReadDeadline(time.Now().Add(testTimeout))
+
+	for {
+		if _, err := pusu.ReadMsg(slow.conn); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Error("the slow subscriber was not disconnected")
+			}
+
+			break
+		}
+	}
+}
+
+func TestServerHeaders(t *testing.T) {
+	s := makeTestServer(t)
+
+	subscriber := start(t, s, "subscriber", testNamespace)
+	publisher := start(t, s, "publisher", testNamespace)
+
+	subscriber.expectAck(subscriber.send(pusu.Subscribe, subscription("/a")))
+
+	pubTime := timestamppb.Now()
+	publisher.expectAck(publisher.send(pusu.Publish,
+		&pusu.PublishMsgPayload{
+			Topic:       "/a",
+			Payload:     []byte("hello"),
+			Headers:     map[string]string{"content-type": "text/plain"},
+			PublishTime: pubTime,
+			PublisherId: "an impostor",
+		}))
+
+	pmp := subscriber.expectPublish("/a", "hello")
+
+	testhelper.DiffString(t, "headers", "content-type",
+		pmp.Headers["content-type"], "text/plain")
+	testhelper.DiffTime(t, "headers", "publish time",
+		pmp.PublishTime.AsTime(), pubTime.AsTime())
+	testhelper.DiffString(t, "headers", "publisher ID",
+		pmp.PublisherId, "publisher")
+}
+
+func TestServerWildcards(t *testing.T) {
+	s := makeTestServer(t)
+
+	subscriber := start(t, s, "subscriber", testNamespace)
+	publisher := start(t, s, "publisher", testNamespace)
+
+	subscriber.expectAck(subscriber.send(pusu.Subscribe,
+		subscription("/a/**", "/a/b", "/x/*/z")))
+
+	publisher.expectAck(publisher.send(pusu.Publish,
+		&pusu.PublishMsgPayload{Topic: "/a/b", Payload: []byte("once")}))
+	subscriber.expectPublish("/a/b", "once")
+
+	publisher.expectAck(publisher.send(pusu.Publish,
+		&pusu.PublishMsgPayload{Topic: "/a", Payload: []byte("prefix")}))
+	subscriber.expectPublish("/a", "prefix")
+
+	publisher.expectAck(publisher.send(pusu.Publish,
+		&pusu.PublishMsgPayload{Topic: "/x/y/z", Payload: []byte("single")}))
+	subscriber.expectPublish("/x/y/z", "single")
+
+	publisher.expectAck(publisher.send(pusu.Publish,
+		&pusu.PublishMsgPayload{Topic: "/x/y/y/z", Payload: []byte("none")}))
+
+	subscriber.expectNothingPending()
+	publisher.expectNothingPending()
+}
+
+func TestServerRetained(t *testing.T) {
+	s := makeTestServer(t)
+
+	publisher := start(t, s, "publisher", testNamespace)
+
+	for _, pmp := range []*pusu.PublishMsgPayload{
+		{Topic: "/a", Payload: []byte("first"), Retain: true},
+		{Topic: "/a", Payload: []byte("second"), Retain: true},
+		{Topic: "/a", Payload: []byte("not retained")},
+		{Topic: "/b/c", Payload: []byte("bc"), Retain: true},
+	} {
+		publisher.expectAck(publisher.send(pusu.Publish, pmp))
+	}
+
+	early := start(t, s, "other-namespace", testOtherNamespace)
+	early.expectAck(early.send(pusu.Subscribe, subscription("/a")))
+	early.expectNothingPending()
+
+	late := start(t, s, "late subscriber", testNamespace)
+	late.expectAck(late.send(pusu.Subscribe, subscription("/a")))
+	pmp := late.expectPublish("/a", "second")
+	testhelper.DiffBool(t, "late subscriber", "retained", pmp.Retained, true)
+	testhelper.DiffStringSlice(t, "late subscriber", "subscriptions",
+		pmp.Subscriptions, []string{"/a"})
+	late.expectNothingPending()
+
+	wild := start(t, s, "wildcard subscriber", testNamespace)
+	wild.expectAck(wild.send(pusu.Subscribe, subscription("/**", "/a")))
+	pmp = wild.expectPublish("/a", "second")
+	testhelper.DiffStringSlice(t, "wildcard subscriber", "subscriptions",
+		pmp.Subscriptions, []string{"/**", "/a"})
+	pmp = wild.expectPublish("/b/c", "bc")
+	testhelper.DiffStringSlice(t, "wildcard subscriber", "subscriptions",
+		pmp.Subscriptions, []string{"/**"})
+	wild.expectNothingPending()
+
+	old := connect(t, s, "old subscriber")
+	old.expectAck(old.send(pusu.Start, &pusu.StartMsgPayload{
+		ProtocolVersion: pusu.ProtoVsnRetainedValues - 1,
+		ClientId:        "old subscriber",
+		Namespace:       string(testNamespace),
+	}))
+	old.expectAck(old.send(pusu.Subscribe, subscription("/a")))
+	pmp = old.expectPublish("/a", "second")
+	testhelper.DiffBool(t, "old subscriber", "retained", pmp.Retained, false)
+	old.expectNothingPending()
+
+	publisher.expectAck(publisher.send(pusu.Publish,
+		&pusu.PublishMsgPayload{Topic: "/a", ClearRetained: true}))
+	pmp = late.expectPublish("/a", "")
+	testhelper.DiffBool(t, "late subscriber", "clear retained",
+		pmp.ClearRetained, true)
+	late.expectNothingPending()
+	old.expectNothingPending()
+
+	publisher.expectAck(publisher.send(pusu.Publish,
+		&pusu.PublishMsgPayload{Topic: "/a", ClearRetained: true}))
+	late.expectNothingPending()
+
+	cleared := start(t, s, "after clearing", testNamespace)
+	cleared.expectAck(cleared.send(pusu.Subscribe, subscription("/a")))
+	cleared.expectNothingPending()
+}
+
+func TestServerFragments(t *testing.T) {
+	s := makeTestServer(t)
+
+	subscriber := start(t, s, "subscriber", testNamespace)
+	publisher := start(t, s, "publisher", testNamespace)
+
+	oldSubscriber := connect(t, s, "old subscriber")
+	oldSubscriber.expectAck(oldSubscriber.send(pusu.Start,
+		&pusu.StartMsgPayload{
+			ProtocolVersion: pusu.ProtoVsnFragments - 1,
+			Namespace:       string(testNamespace),
+		}))
+
+	for _, c := range []*testConn{subscriber, oldSubscriber} {
+		c.expectAck(c.send(pusu.Subscribe, subscription("/big", "/small")))
+	}
+
+	bigPayload := strings.Repeat("x", 3*pusu.MaxMessagePayload)
+
+	publisher.expectAck(publisher.send(pusu.Publish,
+		&pusu.PublishMsgPayload{Topic: "/big", Payload: []byte(bigPayload)}))
+	publisher.expectAck(publisher.send(pusu.Publish,
+		&pusu.PublishMsgPayload{Topic: "/small", Payload: []byte("small")}))
+
+	subscriber.expectPublish("/big", bigPayload)
+	subscriber.expectPublish("/small", "small")
+	oldSubscriber.expectPublish("/small", "small")
+
+	subscriber.expectNothingPending()
+	oldSubscriber.expectNothingPending()
+}
+
+func TestServerBadMessages(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		subs       []pusu.Topic
+		mt         pusu.MsgType
+		payload    proto.Message
+		expErrCode pusu.ErrorCode
+		expErrText string
+	}{
+		{
+			ID: testhelper.MkID("Subscribe - bad topic"),
+			mt: pusu.Subscribe,
+			payload: subscription(
+				"/good", "bad"),
+			expErrCode: pusu.ErrCodeBadTopic,
+			expErrText: `bad topic "bad" - it must start with a '/'`,
+		},
+		{
+			ID:         testhelper.MkID("Subscribe - already subscribed"),
+			subs:       []pusu.Topic{"/a"},
+			mt:         pusu.Subscribe,
+			payload:    subscription("/a"),
+			expErrCode: pusu.ErrCodeSubscription,
+			expErrText: `the client is already subscribed to "/a"`,
+		},
+		{
+			ID:         testhelper.MkID("Unsubscribe - not subscribed"),
+			mt:         pusu.Unsubscribe,
+			payload:    subscription("/a"),
+			expErrCode: pusu.ErrCodeSubscription,
+			expErrText: `the client is not subscribed to "/a"`,
+		},
+		{
+			ID: testhelper.MkID("Publish - bad topic"),
+			mt: pusu.Publish,
+			payload: &pusu.PublishMsgPayload{
+				Topic: "/a/../b",
+			},
+			expErrCode: pusu.ErrCodeBadTopic,
+			expErrText: `bad topic "/a/../b" - unclean`,
+		},
+		{
+			ID: testhelper.MkID("Publish - wildcard topic"),
+			mt: pusu.Publish,
+			payload: &pusu.PublishMsgPayload{
+				Topic: "/a/*",
+			},
+			expErrCode: pusu.ErrCodeBadTopic,
+			expErrText: `bad topic "/a/*" - messages cannot be published`,
+		},
+		{
+			ID: testhelper.MkID("Publish - clear retained with payload"),
+			mt: pusu.Publish,
+			payload: &pusu.PublishMsgPayload{
+				Topic:         "/a",
+				Payload:       []byte("payload"),
+				ClearRetained: true,
+			},
+			expErrCode: pusu.ErrCodeBadMessage,
+			expErrText: `a message clearing the retained value for "/a"` +
+				" must not retain a payload",
+		},
+		{
+			ID:         testhelper.MkID("Subscribe - bad wildcard topic"),
+			mt:         pusu.Subscribe,
+			payload:    subscription("/a/**/b"),
+			expErrCode: pusu.ErrCodeBadTopic,
+			expErrText: `"**" may only be the last part`,
+		},
+		{
+			ID: testhelper.MkID("Start - already started"),
+			mt: pusu.Start,
+			payload: &pusu.StartMsgPayload{
+				ProtocolVersion: pusu.CurrentProtoVsn,
+				Namespace:       string(testNamespace),
+			},
+			expErrCode: pusu.ErrCodeProtocol,
+			expErrText: "the client has already started",
+		},
+		{
+			ID:         testhelper.MkID("Ack - unexpected"),
+			mt:         pusu.Ack,
+			expErrCode: pusu.ErrCodeProtocol,
+			expErrText: "protocol error - unexpected message: Ack",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			s := makeTestServer(t)
+			c := start(t, s, tc.IDStr(), testNamespace)
+
+			if len(tc.subs) > 0 {
+				c.expectAck(c.send(pusu.Subscribe, subscription(tc.subs...)))
+			}
+
+			c.expectError(c.send(tc.mt, tc.payload),
+				tc.expErrCode, tc.expErrText)
+		})
+	}
+}
+
+func TestServerNonFatalErrors(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		protoVsn   pusu.ProtoVsn
+		mt         pusu.MsgType
+		payload    proto.Message
+		expFatal   bool
+		expErrCode pusu.ErrorCode
+	}{
+		{
+			ID:         testhelper.MkID("bad topic - not fatal"),
+			protoVsn:   pusu.CurrentProtoVsn,
+			mt:         pusu.Subscribe,
+			payload:    subscription("bad"),
+			expErrCode: pusu.ErrCodeBadTopic,
+		},
+		{
+			ID:         testhelper.MkID("bad topic - old protocol, fatal"),
+			protoVsn:   pusu.ProtoVsnNonFatalErrors - 1,
+			mt:         pusu.Subscribe,
+			payload:    subscription("bad"),
+			expFatal:   true,
+			expErrCode: pusu.ErrCodeBadTopic,
+		},
+		{
+			ID:         testhelper.MkID("protocol error - fatal"),
+			protoVsn:   pusu.CurrentProtoVsn,
+			mt:         pusu.Ack,
+			expFatal:   true,
+			expErrCode: pusu.ErrCodeProtocol,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			s := makeTestServer(t)
+			c := connect(t, s, tc.IDStr())
+
+			c.expectAck(c.send(pusu.Start, &pusu.StartMsgPayload{
+				ProtocolVersion: int32(tc.protoVsn),
+				Namespace:       string(testNamespace),
+			}))
+
+			msg := c.expect(pusu.Error, c.send(tc.mt, tc.payload))
+
+			var emp pusu.ErrorMsgPayload
+			if err := proto.Unmarshal(msg.Payload, &emp); err != nil {
+				t.Fatal("couldn't unmarshal the Error payload:", err)
+			}
+
+			testhelper.DiffBool(t, tc.IDStr(), "fatal", emp.Fatal, tc.expFatal)
+			testhelper.DiffInt(t, tc.IDStr(), "error code",
+				pusu.ErrorCode(emp.Code), tc.expErrCode)
+
+			if !tc.expFatal {
+				c.expectAck(c.send(pusu.Subscribe, subscription("/good")))
+				return
+			}
+
+			_ = c.conn.SetReadDeadline(time.Now().Add(testTimeout))
+			if _, err := pusu.ReadMsg(c.conn); err == nil {
+				t.Log(tc.IDStr())
+				t.Error("\t: the connection should have been closed")
+			}
+		})
+	}
+}
+
+func TestServerPing(t *testing.T) {
+	s := makeTestServer(t)
+	c := start(t, s, "pinger", testNamespace)
+
+	pingTime := timestamppb.Now()
+	c.send(pusu.Ping, &pusu.PingMsgPayload{PingTime: pingTime})
+
+	msg := c.expect(pusu.Ping, c.nextID)
+
+	var pmp pusu.PingMsgPayload
+	if err := proto.Unmarshal(msg.Payload, &pmp); err != nil {
+		t.Fatal("couldn't unmarshal the Ping payload:", err)
+	}
+
+	testhelper.DiffTime(t, "ping", "ping time",
+		pmp.PingTime.AsTime(), pingTime.AsTime())
+}
+
+func TestServerClose(t *testing.T) {
+	s := makeTestServer(t)
+	c := start(t, s, "closed client", testNamespace)
+
+	testhelper.CheckError(t, "first Close", s.Close(), false, nil)
+	testhelper.CheckError(t, "second Close", s.Close(), true,
+		[]string{errSvrClosed.Error()})
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(testTimeout))
+	if _, err := pusu.ReadMsg(c.conn); err == nil {
+		t.Error("the connection should have been closed")
+	}
+
+	clientEnd, svrEnd := net.Pipe()
+	defer clientEnd.Close()
+
+	testhelper.CheckError(t, "ServeConn after Close", s.ServeConn(svrEnd), true,
+		[]string{errSvrClosed.Error()})
+}
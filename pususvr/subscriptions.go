@@ -0,0 +1,141 @@
+package pususvr
+
+import "github.com/nickwells/pusu.mod/pusu"
+
+// subscriberSet is the collection of client connections subscribed to a
+// topic
+type subscriberSet map[*clientConn]bool
+
+// topicSubscriberMap maps between a Topic and the clients subscribed to it
+type topicSubscriberMap map[pusu.Topic]subscriberSet
+
+// namespaceMap maps between a Namespace and the subscriptions made in it
+type namespaceMap map[pusu.Namespace]topicSubscriberMap
+
+// subscribe records the subscriptions to the topics for the client
+// connection. It returns a non-nil error if any of the topics are already
+// subscribed to by the client, in which case none of the subscriptions are
+// made. Otherwise it returns any retained values for the topics.
+func (s *Server) subscribe(cc *clientConn, topics []pusu.Topic,
+) ([]retainedValue, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, t := range topics {
+		if cc.topics[t] {
+			return nil,
+				pusu.NewCodedError(pusu.ErrCodeSubscription,
+					"the client is already subscribed to %q", t).
+					WithDetail("topic", string(t))
+		}
+	}
+
+	tsm, ok := s.subs[cc.namespace]
+	if !ok {
+		tsm = make(topicSubscriberMap)
+		s.subs[cc.namespace] = tsm
+	}
+
+	for _, t := range topics {
+		ss, ok := tsm[t]
+		if !ok {
+			ss = make(subscriberSet)
+			tsm[t] = ss
+		}
+
+		ss[cc] = true
+		cc.topics[t] = true
+	}
+
+	return s.retainedValues(cc.namespace, topics), nil
+}
+
+// unsubscribe removes the subscriptions to the topics for the client
+// connection. It returns a non-nil error if any of the topics are not
+// subscribed to by the client, in which case none of the subscriptions are
+// removed.
+func (s *Server) unsubscribe(cc *clientConn, topics []pusu.Topic) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, t := range topics {
+		if !cc.topics[t] {
+			return pusu.NewCodedError(pusu.ErrCodeSubscription,
+				"the client is not subscribed to %q", t).
+				WithDetail("topic", string(t))
+		}
+	}
+
+	for _, t := range topics {
+		s.removeSubscriber(cc, t)
+	}
+
+	return nil
+}
+
+// unsubscribeAll removes all the subscriptions for the client
+// connection. The Server mutex must be held when this is called.
+func (s *Server) unsubscribeAll(cc *clientConn) {
+	for t := range cc.topics {
+		s.removeSubscriber(cc, t)
+	}
+}
+
+// removeSubscriber removes the client connection from the subscribers to
+// the topic, tidying away any emptied entries. The Server mutex must be
+// held when this is called.
+func (s *Server) removeSubscriber(cc *clientConn, t pusu.Topic) {
+	delete(cc.topics, t)
+
+	tsm, ok := s.subs[cc.namespace]
+	if !ok {
+		return
+	}
+
+	ss, ok := tsm[t]
+	if !ok {
+		return
+	}
+
+	delete(ss, cc)
+
+	if len(ss) == 0 {
+		delete(tsm, t)
+	}
+
+	if len(tsm) == 0 {
+		delete(s.subs, cc.namespace)
+	}
+}
+
+// subscribers returns the client connections in the namespace which are
+// subscribed to the topic or to any wildcard topic matching it. Each client
+// connection appears only once, however many of its subscriptions match.
+func (s *Server) subscribers(ns pusu.Namespace, t pusu.Topic) []*clientConn {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	tsm := s.subs[ns]
+
+	matched := make(subscriberSet, len(tsm[t]))
+	for cc := range tsm[t] {
+		matched[cc] = true
+	}
+
+	for st, ss := range tsm {
+		if st == t || !st.IsPattern() || !st.Matches(t) {
+			continue
+		}
+
+		for cc := range ss {
+			matched[cc] = true
+		}
+	}
+
+	ccs := make([]*clientConn, 0, len(matched))
+	for cc := range matched {
+		ccs = append(ccs, cc)
+	}
+
+	return ccs
+}
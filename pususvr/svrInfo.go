@@ -0,0 +1,87 @@
+package pususvr
+
+import (
+	"slices"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+)
+
+// SvrInfo encapsulates the details needed to run a publish/subscribe server.
+type SvrInfo struct {
+	Address      string        // the network address to listen on
+	ServerID     string        // identifying text sent to the clients
+	CertInfo     pusu.CertInfo // certificate information for the server
+	StartTimeout time.Duration // how long to wait for the Start message
+
+	// MaxPayload gives the largest message payload that the server will
+	// accept. A client sending a larger message is disconnected. If it is
+	// not greater than zero pusu.DfltMaxPayload is used.
+	MaxPayload int
+
+	// Namespaces gives the namespaces that clients may use. If it is empty
+	// then any (non-empty) namespace is permitted.
+	Namespaces []pusu.Namespace
+
+	// SendQueueSize gives the number of messages that can be waiting to be
+	// written to each client. A client whose queue is full when a message
+	// is published to it is disconnected so that a client which is not
+	// reading its messages cannot delay the publishers. If it is not
+	// greater than zero DfltSendQueueSize is used.
+	SendQueueSize int
+
+	// WriteTimeout gives the longest time that writing a message to a
+	// client can take before the client is disconnected. If it is not
+	// greater than zero there is no limit.
+	WriteTimeout time.Duration
+}
+
+// DfltSendQueueSize is the number of messages that can be waiting to be
+// written to each client if the SvrInfo does not give a size
+const DfltSendQueueSize = 100
+
+// NewSvrInfo returns a default SvrInfo
+func NewSvrInfo() *SvrInfo {
+	const (
+		dfltStartTimeoutSecs = 5
+		dfltWriteTimeoutSecs = 10
+		dfltServerID         = "pususvr"
+	)
+
+	return &SvrInfo{
+		ServerID:      dfltServerID,
+		StartTimeout:  dfltStartTimeoutSecs * time.Second,
+		MaxPayload:    pusu.DfltMaxPayload,
+		SendQueueSize: DfltSendQueueSize,
+		WriteTimeout:  dfltWriteTimeoutSecs * time.Second,
+	}
+}
+
+// sendQueueSize returns the number of messages that can be waiting to be
+// written to each client
+func (si *SvrInfo) sendQueueSize() int {
+	if si.SendQueueSize <= 0 {
+		return DfltSendQueueSize
+	}
+
+	return si.SendQueueSize
+}
+
+// maxPayload returns the largest message payload to be accepted
+func (si *SvrInfo) maxPayload() int {
+	if si.MaxPayload <= 0 {
+		return pusu.DfltMaxPayload
+	}
+
+	return si.MaxPayload
+}
+
+// namespacePermitted returns true if the namespace is allowed by the
+// SvrInfo
+func (si *SvrInfo) namespacePermitted(ns pusu.Namespace) bool {
+	if len(si.Namespaces) == 0 {
+		return true
+	}
+
+	return slices.Contains(si.Namespaces, ns)
+}
@@ -0,0 +1,142 @@
+package pusutest
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/pusu.mod/pusuclt"
+	"github.com/nickwells/pusu.mod/pususvr"
+)
+
+// localAddress is the address the Broker listens on; the port is chosen by
+// the system
+const localAddress = "127.0.0.1:0"
+
+// Broker is an in-process publish/subscribe server for use in tests. It
+// listens on the local host using certificates generated in memory.
+type Broker struct {
+	t          testing.TB
+	svr        *pususvr.Server
+	addr       string
+	namespaces []pusu.Namespace
+	certs      *testCerts
+	logger     *slog.Logger
+}
+
+// NewBroker creates a Broker and starts it serving connections. Any failure
+// is reported as a fatal error on the test. The Broker will be closed when
+// the test completes.
+//
+// The namespaces, if any are given, are the only namespaces that clients
+// are permitted to use; otherwise any namespace is permitted.
+func NewBroker(t testing.TB, namespaces ...pusu.Namespace) *Broker {
+	t.Helper()
+
+	certs, err := makeTestCerts()
+	if err != nil {
+		t.Fatal("couldn't make the test certificates:", err)
+	}
+
+	b := &Broker{
+		t:          t,
+		addr:       localAddress,
+		namespaces: namespaces,
+		certs:      certs,
+		logger:     slog.New(slog.DiscardHandler),
+	}
+
+	b.start()
+
+	t.Cleanup(b.Close)
+
+	return b
+}
+
+// start creates a new pub/sub server listening on the Broker's address and
+// starts it serving connections. Any failure is reported as a fatal error
+// on the test.
+func (b *Broker) start() {
+	b.t.Helper()
+
+	info := pususvr.NewSvrInfo()
+	info.Address = b.addr
+	info.Namespaces = b.namespaces
+	info.CertInfo.SetCert(b.certs.svrCert)
+	info.CertInfo.SetCertPool(b.certs.certPool)
+
+	b.svr = pususvr.NewServer(b.logger, info)
+
+	l, err := b.svr.Listen()
+	if err != nil {
+		b.t.Fatal("couldn't start the test pub/sub server:", err)
+	}
+
+	b.addr = l.Addr().String()
+
+	go b.svr.Serve(l) //nolint:errcheck
+}
+
+// Restart closes the Broker, dropping all the connections to it and
+// discarding all the subscriptions, and then starts it again listening on
+// the same address. This can be used to test how clients handle the loss of
+// their connections.
+func (b *Broker) Restart() {
+	b.t.Helper()
+
+	b.Close()
+	b.start()
+}
+
+// Addr returns the network address that the Broker is listening on
+func (b *Broker) Addr() string {
+	return b.addr
+}
+
+// ConnInfo returns a new ConnInfo which can be used to connect a client to
+// the Broker. The ping handler is passed to pusuclt.NewConnInfo.
+func (b *Broker) ConnInfo(pingHandler func(time.Duration)) *pusuclt.ConnInfo {
+	info := pusuclt.NewConnInfo(pingHandler)
+	info.SvrAddress = b.addr
+	info.CertInfo.SetCert(b.certs.cltCert)
+	info.CertInfo.SetCertPool(b.certs.certPool)
+
+	return info
+}
+
+// NewClient returns a new client connected to the Broker and using the
+// given namespace. Any failure is reported as a fatal error on the test. The
+// client will be disconnected when the test completes.
+func (b *Broker) NewClient(namespace pusu.Namespace) *pusuclt.Client {
+	b.t.Helper()
+
+	return b.NewClientWithConnInfo(namespace, b.ConnInfo(nil))
+}
+
+// NewClientWithConnInfo returns a new client connected to the Broker using
+// the given namespace and ConnInfo. The ConnInfo should be one returned by
+// the ConnInfo method, perhaps with some values changed. Any failure is
+// reported as a fatal error on the test. The client will be disconnected
+// when the test completes.
+func (b *Broker) NewClientWithConnInfo(
+	namespace pusu.Namespace,
+	info *pusuclt.ConnInfo,
+) *pusuclt.Client {
+	b.t.Helper()
+
+	c, err := pusuclt.NewClient(namespace, b.t.Name(), b.logger, info)
+	if err != nil {
+		b.t.Fatal("couldn't connect to the test pub/sub server:", err)
+	}
+
+	b.t.Cleanup(func() { _ = c.Disconnect() })
+
+	return c
+}
+
+// Close closes the Broker and all the connections to it. It is safe to call
+// this more than once.
+func (b *Broker) Close() {
+	_ = b.svr.Close()
+}
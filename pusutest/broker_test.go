@@ -0,0 +1,638 @@
+package pusutest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/pusu.mod/pusuclt"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+const (
+	testNamespace      = pusu.Namespace("test-namespace")
+	testOtherNamespace = pusu.Namespace("test-other-namespace")
+	testWait           = 5 * time.Second
+	testShortWait      = 100 * time.Millisecond
+)
+
+func TestBroker(t *testing.T) {
+	b := NewBroker(t)
+
+	subscriber := b.NewClient(testNamespace)
+	publisher := b.NewClient(testNamespace)
+	outsider := b.NewClient(testOtherNamespace)
+
+	si := subscriber.ServerInfo()
+	testhelper.DiffInt(t, "server info", "protocol version",
+		si.ProtoVsn, pusu.CurrentProtoVsn)
+	testhelper.DiffBool(t, "server info", "supports wildcards",
+		si.Capabilities.Has(pusu.CapWildcards), true)
+
+	rec := NewRecorder()
+	outsiderRec := NewRecorder()
+
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return subscriber.Subscribe(cb,
+			pusuclt.TopicHandler{Topic: "/a", Handler: rec.Handler()})
+	})
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return outsider.Subscribe(cb,
+			pusuclt.TopicHandler{Topic: "/a", Handler: outsiderRec.Handler()})
+	})
+
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return publisher.Publish(cb, "/a", []byte("hello"))
+	})
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return publisher.Publish(cb, "/b", []byte("unheard"))
+	})
+
+	received := rec.WaitFor(1, testWait)
+	if testhelper.DiffInt(t, "subscriber", "received count",
+		len(received), 1) {
+		return
+	}
+
+	testhelper.DiffString(t, "subscriber", "topic",
+		received[0].Topic, "/a")
+	testhelper.DiffString(t, "subscriber", "payload",
+		string(received[0].Payload), "hello")
+
+	testhelper.DiffInt(t, "outsider", "received count",
+		len(outsiderRec.WaitFor(1, testShortWait)), 0)
+}
+
+func TestBrokerRetained(t *testing.T) {
+	b := NewBroker(t)
+
+	publisher := b.NewClient(testNamespace)
+
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return publisher.Publish(cb, "/status", []byte("up"), pusuclt.WithRetain())
+	})
+
+	subscriber := b.NewClient(testNamespace)
+	rec := NewRecorder()
+
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return subscriber.Subscribe(cb,
+			pusuclt.TopicHandler{Topic: "/status", Handler: rec.Handler()})
+	})
+
+	received := rec.WaitFor(1, testWait)
+	if testhelper.DiffInt(t, "late subscriber", "received count",
+		len(received), 1) {
+		return
+	}
+
+	testhelper.DiffString(t, "late subscriber", "payload",
+		string(received[0].Payload), "up")
+}
+
+func TestBrokerRetainedPerSubscription(t *testing.T) {
+	b := NewBroker(t)
+
+	publisher := b.NewClient(testNamespace)
+	subscriber := b.NewClient(testNamespace)
+
+	topicRec := NewRecorder()
+	wildRec := NewRecorder()
+
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return subscriber.Subscribe(cb,
+			pusuclt.TopicHandler{Topic: "/cfg/a", Handler: topicRec.Handler()})
+	})
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return publisher.Publish(cb, "/cfg/a", []byte("v1"),
+			pusuclt.WithRetain())
+	})
+	testhelper.DiffInt(t, "topic handler", "received count",
+		len(topicRec.WaitFor(1, testWait)), 1)
+
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return subscriber.Subscribe(cb,
+			pusuclt.TopicHandler{Topic: "/cfg/**", Handler: wildRec.Handler()})
+	})
+	testhelper.DiffInt(t, "wildcard handler", "received count",
+		len(wildRec.WaitFor(1, testWait)), 1)
+	testhelper.DiffInt(t, "topic handler", "received count after wildcard",
+		len(topicRec.WaitFor(2, testShortWait)), 1)
+
+	deliveries := make(chan pusuclt.Delivery, 2)
+
+	err := subscriber.SubscribeCtx(t.Context(), pusuclt.TopicHandler{
+		Topic: "/cfg/a",
+		DeliveryHandler: func(d pusuclt.Delivery) {
+			deliveries <- d
+		},
+	})
+	testhelper.CheckError(t, "adding a handler", err, false, nil)
+
+	select {
+	case d := <-deliveries:
+		testhelper.DiffString(t, "added handler", "payload",
+			string(d.Payload), "v1")
+		testhelper.DiffBool(t, "added handler", "retained", d.Retained, true)
+	case <-time.After(testWait):
+		t.Error("the handler added to a subscribed topic was not replayed" +
+			" the retained value")
+	}
+
+	testhelper.DiffInt(t, "topic handler", "received count after adding",
+		len(topicRec.WaitFor(2, testShortWait)), 1)
+	testhelper.DiffInt(t, "wildcard handler", "received count after adding",
+		len(wildRec.WaitFor(2, testShortWait)), 1)
+
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return publisher.ClearRetained(cb, "/cfg/a")
+	})
+	// the reply to this follows the clearing message on the connection
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return subscriber.Publish(cb, "/sync", nil)
+	})
+
+	clearedRec := NewRecorder()
+
+	err = subscriber.SubscribeCtx(t.Context(),
+		pusuclt.TopicHandler{Topic: "/cfg/a", Handler: clearedRec.Handler()})
+	testhelper.CheckError(t, "adding a handler after clearing", err,
+		false, nil)
+	testhelper.DiffInt(t, "handler added after clearing", "received count",
+		len(clearedRec.WaitFor(1, testShortWait)), 0)
+	testhelper.DiffInt(t, "topic handler", "received count after clearing",
+		len(topicRec.WaitFor(2, testShortWait)), 1)
+}
+
+func TestBrokerLargePayload(t *testing.T) {
+	const maxPayload = 1024 * 1024
+
+	b := NewBroker(t)
+
+	info := b.ConnInfo(nil)
+	info.MaxPayload = maxPayload
+
+	subscriber := b.NewClient(testNamespace)
+	publisher := b.NewClientWithConnInfo(testNamespace, info)
+
+	rec := NewRecorder()
+
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return subscriber.Subscribe(cb,
+			pusuclt.TopicHandler{Topic: "/big", Handler: rec.Handler()})
+	})
+
+	bigPayload := bytes.Repeat([]byte("0123456789"), maxPayload/20)
+
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return publisher.Publish(cb, "/big", bigPayload)
+	})
+
+	err := publisher.Publish(nil, "/big", make([]byte, maxPayload))
+	testhelper.CheckError(t, "too big", err, true,
+		[]string{"the Publish message is too big"})
+
+	received := rec.WaitFor(1, testWait)
+	if testhelper.DiffInt(t, "subscriber", "received count",
+		len(received), 1) {
+		return
+	}
+
+	testhelper.DiffSlice(t, "subscriber", "payload",
+		received[0].Payload, bigPayload)
+}
+
+func TestBrokerNamespaces(t *testing.T) {
+	b := NewBroker(t, testNamespace)
+
+	b.NewClient(testNamespace)
+
+	_, err := pusuclt.NewClient(testOtherNamespace, t.Name(),
+		b.logger, b.ConnInfo(nil))
+	testhelper.CheckError(t, "disallowed namespace", err, true,
+		[]string{`the namespace "` + string(testOtherNamespace) +
+			`" is not permitted`})
+	testhelper.DiffBool(t, "disallowed namespace", "is ErrNamespace",
+		errors.Is(err, pusu.ErrNamespace), true)
+}
+
+func TestBrokerRestart(t *testing.T) {
+	b := NewBroker(t)
+
+	info := b.ConnInfo(nil)
+	info.Reconnect = pusuclt.NewReconnectPolicy()
+	info.Reconnect.InitialBackoff = 10 * time.Millisecond
+	info.Reconnect.MaxBackoff = 100 * time.Millisecond
+
+	subscriber := b.NewClientWithConnInfo(testNamespace, info)
+	publisher := b.NewClientWithConnInfo(testNamespace, info)
+
+	rec := NewRecorder()
+
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return subscriber.Subscribe(cb,
+			pusuclt.TopicHandler{Topic: "/a", Handler: rec.Handler()})
+	})
+
+	b.Restart()
+
+	// keep publishing until the subscriber has reconnected and
+	// resubscribed and so receives a message
+	deadline := time.Now().Add(testWait)
+
+	for len(rec.Received()) == 0 && time.Now().Before(deadline) {
+		_ = publisher.Publish(nil, "/a", []byte("after restart"))
+		rec.WaitFor(1, testShortWait)
+	}
+
+	received := rec.Received()
+	if len(received) == 0 {
+		t.Fatal("no message was received after the broker restarted")
+	}
+
+	testhelper.DiffString(t, "after restart", "payload",
+		string(received[0].Payload), "after restart")
+}
+
+func TestBrokerOutbox(t *testing.T) {
+	const msgCount = 3
+
+	b := NewBroker(t)
+
+	subInfo := b.ConnInfo(nil)
+	subInfo.Reconnect = pusuclt.NewReconnectPolicy()
+	subInfo.Reconnect.InitialBackoff = 10 * time.Millisecond
+
+	pubInfo := b.ConnInfo(nil)
+	pubInfo.Reconnect = pusuclt.NewReconnectPolicy()
+	pubInfo.Reconnect.InitialBackoff = 500 * time.Millisecond
+	pubInfo.Outbox = &pusuclt.OutboxConfig{MaxMessages: msgCount}
+
+	subscriber := b.NewClientWithConnInfo(testNamespace, subInfo)
+	publisher := b.NewClientWithConnInfo(testNamespace, pubInfo)
+	changes := publisher.StateChanges(10)
+
+	rec := NewRecorder()
+
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return subscriber.Subscribe(cb,
+			pusuclt.TopicHandler{Topic: "/a", Handler: rec.Handler()})
+	})
+
+	b.Restart()
+
+	for sc := range changes {
+		if sc.To == pusuclt.StateReconnecting {
+			break
+		}
+	}
+
+	replies := make(chan error, msgCount)
+
+	for i := range msgCount {
+		err := publisher.Publish(func(err error) { replies <- err },
+			"/a", fmt.Appendf(nil, "msg %d", i))
+		testhelper.CheckError(t, "Publish while reconnecting", err, false, nil)
+	}
+
+	err := publisher.Publish(nil, "/a", []byte("one too many"))
+	if !errors.Is(err, pusuclt.ErrOutboxFull) {
+		t.Errorf("Publish with a full outbox: unexpected error: %v", err)
+	}
+
+	for i := range msgCount {
+		select {
+		case err := <-replies:
+			testhelper.CheckError(t, fmt.Sprintf("reply %d", i), err,
+				false, nil)
+		case <-time.After(testWait):
+			t.Fatalf("reply %d was not received", i)
+		}
+	}
+
+	received := rec.WaitFor(msgCount, testWait)
+	if testhelper.DiffInt(t, "received", "count", len(received), msgCount) {
+		return
+	}
+
+	for i, r := range received {
+		testhelper.DiffString(t, fmt.Sprintf("message %d", i), "payload",
+			string(r.Payload), fmt.Sprintf("msg %d", i))
+	}
+}
+
+func TestBrokerOutboxAbandoned(t *testing.T) {
+	b := NewBroker(t)
+
+	info := b.ConnInfo(nil)
+	info.Reconnect = pusuclt.NewReconnectPolicy()
+	info.Reconnect.InitialBackoff = 200 * time.Millisecond
+	info.Reconnect.MaxAttempts = 2
+	info.Outbox = &pusuclt.OutboxConfig{}
+
+	c := b.NewClientWithConnInfo(testNamespace, info)
+	changes := c.StateChanges(10)
+
+	b.Close()
+
+	for sc := range changes {
+		if sc.To == pusuclt.StateReconnecting {
+			break
+		}
+	}
+
+	replies := make(chan error, 1)
+
+	err := c.Publish(func(err error) { replies <- err }, "/a", nil)
+	testhelper.CheckError(t, "Publish while reconnecting", err, false, nil)
+
+	select {
+	case err := <-replies:
+		if !errors.Is(err, pusuclt.ErrClosed) {
+			t.Errorf("held Callback: unexpected error: %v", err)
+		}
+	case <-time.After(testWait):
+		t.Fatal("the held Callback was not called")
+	}
+
+	testhelper.DiffString(t, "state", "after abandoning reconnection",
+		c.State().String(), pusuclt.StateClosed.String())
+}
+
+func TestBrokerTLSOptions(t *testing.T) {
+	b := NewBroker(t)
+
+	verified := false
+	configured := false
+
+	info := b.ConnInfo(nil)
+	info.TLS = &pusuclt.TLSOptions{
+		ServerName:   "localhost",
+		SessionCache: tls.NewLRUClientSessionCache(1),
+		VerifyPeerCertificate: func(rawCerts [][]byte,
+			_ [][]*x509.Certificate,
+		) error {
+			verified = len(rawCerts) > 0
+
+			return nil
+		},
+		Configure: func(cfg *tls.Config) {
+			configured = cfg.ServerName == "localhost"
+		},
+	}
+
+	c := b.NewClientWithConnInfo(testNamespace, info)
+
+	testhelper.DiffBool(t, "TLSOptions", "Configure called", configured, true)
+	testhelper.DiffBool(t, "TLSOptions", "peer certificate verified",
+		verified, true)
+
+	cs, ok := c.TLSConnectionState()
+	if testhelper.DiffBool(t, "TLSConnectionState", "ok", ok, true) {
+		return
+	}
+
+	testhelper.DiffBool(t, "TLSConnectionState", "handshake complete",
+		cs.HandshakeComplete, true)
+	testhelper.DiffString(t, "TLSConnectionState", "server name",
+		cs.ServerName, "localhost")
+	testhelper.DiffBool(t, "TLSConnectionState", "has peer certificates",
+		len(cs.PeerCertificates) > 0, true)
+
+	if err := c.Close(t.Context()); err != nil {
+		t.Fatal("couldn't close the client:", err)
+	}
+
+	_, ok = c.TLSConnectionState()
+	testhelper.DiffBool(t, "TLSConnectionState", "ok after closing",
+		ok, false)
+}
+
+func TestBrokerPinning(t *testing.T) {
+	b := NewBroker(t)
+
+	errPinned := errors.New("the server's key is not pinned")
+
+	info := b.ConnInfo(nil)
+	info.TLS = &pusuclt.TLSOptions{
+		VerifyPeerCertificate: func(_ [][]byte, _ [][]*x509.Certificate,
+		) error {
+			return errPinned
+		},
+	}
+
+	_, err := pusuclt.NewClient(testNamespace, t.Name(),
+		slog.New(slog.DiscardHandler), info)
+	if !errors.Is(err, errPinned) {
+		t.Errorf("connecting to an unpinned server: unexpected error: %v",
+			err)
+	}
+}
+
+func TestBrokerRequest(t *testing.T) {
+	b := NewBroker(t)
+
+	responder := b.NewClient(testNamespace)
+	requester := b.NewClient(testNamespace)
+
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		_, err := responder.Respond(cb, "/upper",
+			func(req pusuclt.Delivery) ([]byte, error) {
+				if len(req.Payload) == 0 {
+					return nil, errors.New("nothing to convert")
+				}
+
+				return bytes.ToUpper(req.Payload), nil
+			})
+
+		return err
+	})
+
+	ctx, cancel := context.WithTimeout(t.Context(), testWait)
+	defer cancel()
+
+	reply, err := requester.Request(ctx, "/upper", []byte("hello"))
+	testhelper.CheckError(t, "good request", err, false, nil)
+	testhelper.DiffString(t, "good request", "reply", string(reply), "HELLO")
+
+	_, err = requester.Request(ctx, "/upper", nil)
+	testhelper.CheckError(t, "failing request", err, true,
+		[]string{`the request on "/upper" failed: nothing to convert`})
+
+	shortCtx, shortCancel := context.WithTimeout(t.Context(), testShortWait)
+	defer shortCancel()
+
+	_, err = requester.Request(shortCtx, "/no-responder", []byte("hello"))
+	testhelper.CheckError(t, "no responder", err, true,
+		[]string{`no reply to the request on "/no-responder"`,
+			context.DeadlineExceeded.Error()})
+}
+
+func TestBrokerPingLiveness(t *testing.T) {
+	b := NewBroker(t)
+
+	connLost := make(chan error, 1)
+
+	info := b.ConnInfo(nil)
+	info.PingInterval = 10 * time.Millisecond
+	info.MaxMissedPings = 2
+	info.PingTimeout = testShortWait
+	info.ConnLost = func(cause error) { connLost <- cause }
+
+	b.NewClientWithConnInfo(testNamespace, info)
+
+	select {
+	case cause := <-connLost:
+		t.Fatal("the live connection was declared lost:", cause)
+	case <-time.After(2 * testShortWait):
+	}
+
+	b.Close()
+
+	select {
+	case cause := <-connLost:
+		if cause == nil {
+			t.Error("the cause of the lost connection should not be nil")
+		}
+	case <-time.After(testWait):
+		t.Error("the lost connection was not reported")
+	}
+}
+
+func TestBrokerStateChanges(t *testing.T) {
+	b := NewBroker(t)
+
+	observed := make(chan pusuclt.StateChange, 10)
+
+	info := b.ConnInfo(nil)
+	info.Reconnect = pusuclt.NewReconnectPolicy()
+	info.Reconnect.InitialBackoff = 10 * time.Millisecond
+	info.StateObserver = func(sc pusuclt.StateChange) { observed <- sc }
+
+	c := b.NewClientWithConnInfo(testNamespace, info)
+	changes := c.StateChanges(10)
+
+	testhelper.DiffString(t, "state", "after connecting",
+		c.State().String(), pusuclt.StateConnected.String())
+
+	b.Restart()
+
+	expStates := []pusuclt.ConnState{
+		pusuclt.StateConnecting,
+		pusuclt.StateConnected,
+		pusuclt.StateDisconnected,
+		pusuclt.StateReconnecting,
+		pusuclt.StateConnected,
+		pusuclt.StateClosed,
+	}
+
+	for i, exp := range expStates {
+		var sc pusuclt.StateChange
+
+		select {
+		case sc = <-observed:
+		case <-time.After(testWait):
+			t.Fatalf("state change %d (to %s) was not observed", i, exp)
+		}
+
+		testhelper.DiffString(t, fmt.Sprintf("state change %d", i), "to",
+			sc.To.String(), exp.String())
+
+		if exp == pusuclt.StateDisconnected && sc.Cause == nil {
+			t.Error("the cause of the disconnection should not be nil")
+		}
+
+		if exp == pusuclt.StateConnected && i > 1 {
+			if err := c.Disconnect(); err != nil {
+				t.Fatal("couldn't disconnect:", err)
+			}
+		}
+	}
+
+	var fromChan []string
+	for sc := range changes {
+		fromChan = append(fromChan, sc.To.String())
+	}
+
+	testhelper.DiffStringSlice(t, "StateChanges", "states", fromChan,
+		[]string{"Disconnected", "Reconnecting", "Connected", "Closed"})
+}
+
+func TestBrokerClose(t *testing.T) {
+	const msgCount = 20
+
+	testCases := []struct {
+		testhelper.ID
+		testhelper.ExpErr
+		cancelled bool
+	}{
+		{
+			ID: testhelper.MkID("all replies received"),
+		},
+		{
+			ID: testhelper.MkID("context already cancelled"),
+			ExpErr: testhelper.MkExpErr("not all the replies were received",
+				context.Canceled.Error()),
+			cancelled: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			b := NewBroker(t)
+			c := b.NewClient(testNamespace)
+
+			cbErrs := make(chan error, msgCount)
+
+			for i := range msgCount {
+				if err := c.Publish(func(err error) { cbErrs <- err },
+					"/a", fmt.Append(nil, i)); err != nil {
+					t.Fatal("couldn't publish:", err)
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(t.Context(), testWait)
+			if tc.cancelled {
+				cancel()
+			}
+			defer cancel()
+
+			// all the replies may have arrived before Close was called so
+			// a cancelled Close need not fail
+			if err := c.Close(ctx); err != nil || !tc.cancelled {
+				testhelper.CheckExpErr(t, err, tc)
+			}
+
+			for i := range msgCount {
+				select {
+				case err := <-cbErrs:
+					if err != nil && !errors.Is(err, pusuclt.ErrClosed) {
+						t.Log(tc.IDStr())
+						t.Errorf("\t: Callback %d: unexpected error: %s", i, err)
+					}
+
+					if err != nil && !tc.cancelled {
+						t.Log(tc.IDStr())
+						t.Errorf("\t: Callback %d: the reply was not received", i)
+					}
+				case <-time.After(testWait):
+					t.Fatalf("%s: Callback %d was not called", tc.IDStr(), i)
+				}
+			}
+
+			testhelper.DiffString(t, tc.IDStr(), "state",
+				c.State().String(), pusuclt.StateClosed.String())
+
+			err := c.Publish(nil, "/a", []byte("too late"))
+			testhelper.DiffBool(t, tc.IDStr(), "publish after Close fails",
+				errors.Is(err, pusuclt.ErrClosed), true)
+		})
+	}
+}
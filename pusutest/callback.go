@@ -0,0 +1,42 @@
+package pusutest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusuclt"
+)
+
+// callbackTimeout is how long AwaitCallback waits for the Callback
+const callbackTimeout = 5 * time.Second
+
+// AwaitCallback calls f passing it a Callback and then waits for that
+// Callback to be called. This can be used to wait for the pub/sub server to
+// acknowledge a message, for instance:
+//
+//	pusutest.AwaitCallback(t, func(cb pusuclt.Callback) error {
+//		return c.Subscribe(cb, th)
+//	})
+//
+// Any error returned by f or passed to the Callback, or a failure to call
+// the Callback in time, is reported as a fatal error on the test. Note that
+// f must cause a message to be sent to the server, otherwise the Callback
+// will never be called.
+func AwaitCallback(t testing.TB, f func(pusuclt.Callback) error) {
+	t.Helper()
+
+	errChan := make(chan error, 1)
+
+	if err := f(func(err error) { errChan <- err }); err != nil {
+		t.Fatal("the pub/sub request failed:", err)
+	}
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Fatal("the pub/sub server reported an error:", err)
+		}
+	case <-time.After(callbackTimeout):
+		t.Fatal("timed out waiting for the pub/sub server to reply")
+	}
+}
@@ -0,0 +1,111 @@
+package pusutest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// certValidity is how long the generated certificates are valid for
+const certValidity = 24 * time.Hour
+
+// testCerts holds the throwaway certificates used by a Broker and its
+// clients
+type testCerts struct {
+	certPool *x509.CertPool  // holds the CA certificate
+	svrCert  tls.Certificate // the server's certificate
+	cltCert  tls.Certificate // the certificate shared by all the clients
+}
+
+// makeTestCerts generates a CA certificate and certificates for the server
+// and the clients signed by it
+func makeTestCerts() (*testCerts, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't generate the CA key: %w", err)
+	}
+
+	caTmpl := certTemplate(1, "pusutest CA")
+	caTmpl.IsCA = true
+	caTmpl.BasicConstraintsValid = true
+	caTmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+
+	caDER, err := x509.CreateCertificate(
+		rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create the CA certificate: %w", err)
+	}
+
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the CA certificate: %w", err)
+	}
+
+	tc := &testCerts{certPool: x509.NewCertPool()}
+	tc.certPool.AddCert(caCert)
+
+	svrTmpl := certTemplate(2, "pusutest server")
+	svrTmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	svrTmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	svrTmpl.DNSNames = []string{"localhost"}
+
+	if tc.svrCert, err = signedCert(svrTmpl, caCert, caKey); err != nil {
+		return nil, err
+	}
+
+	cltTmpl := certTemplate(3, "pusutest client")
+	cltTmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	if tc.cltCert, err = signedCert(cltTmpl, caCert, caKey); err != nil {
+		return nil, err
+	}
+
+	return tc, nil
+}
+
+// certTemplate returns a certificate template with the common fields set
+func certTemplate(serial int64, name string) *x509.Certificate {
+	now := time.Now()
+
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+}
+
+// signedCert generates a key and returns a certificate for it made from the
+// template and signed by the CA
+func signedCert(
+	tmpl, caCert *x509.Certificate,
+	caKey *ecdsa.PrivateKey,
+) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{},
+			fmt.Errorf("couldn't generate the key for %q: %w",
+				tmpl.Subject.CommonName, err)
+	}
+
+	der, err := x509.CreateCertificate(
+		rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{},
+			fmt.Errorf("couldn't create the certificate for %q: %w",
+				tmpl.Subject.CommonName, err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
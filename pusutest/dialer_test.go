@@ -0,0 +1,186 @@
+package pusutest
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusuclt"
+	"github.com/nickwells/pusu.mod/pususvr"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+// dialerSetup starts serving connections for the server and returns the
+// address and the Dialer to connect to it
+type dialerSetup func(t *testing.T, svr *pususvr.Server, certs *testCerts,
+) (string, pusuclt.Dialer)
+
+// serveListener serves the connections accepted by the listener
+func serveListener(t *testing.T, svr *pususvr.Server, l net.Listener,
+	err error,
+) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal("couldn't listen:", err)
+	}
+
+	go svr.Serve(l) //nolint:errcheck
+}
+
+func TestDialers(t *testing.T) {
+	testCases := []struct {
+		testhelper.ID
+		setup dialerSetup
+	}{
+		{
+			ID: testhelper.MkID("pipe"),
+			setup: func(_ *testing.T, svr *pususvr.Server, _ *testCerts,
+			) (string, pusuclt.Dialer) {
+				return "pipe", pusuclt.PipeDialer{
+					Serve: func(conn net.Conn) { _ = svr.ServeConn(conn) },
+				}
+			},
+		},
+		{
+			ID: testhelper.MkID("plain TCP"),
+			setup: func(t *testing.T, svr *pususvr.Server, _ *testCerts,
+			) (string, pusuclt.Dialer) {
+				l, err := net.Listen("tcp", localAddress)
+				serveListener(t, svr, l, err)
+
+				return l.Addr().String(), pusuclt.TCPDialer{}
+			},
+		},
+		{
+			ID: testhelper.MkID("TLS over a Unix socket"),
+			setup: func(t *testing.T, svr *pususvr.Server, certs *testCerts,
+			) (string, pusuclt.Dialer) {
+				addr := filepath.Join(t.TempDir(), "pusu.sock")
+
+				l, err := net.Listen("unix", addr)
+				if err == nil {
+					l = tls.NewListener(l, &tls.Config{
+						ClientCAs:    certs.certPool,
+						Certificates: []tls.Certificate{certs.svrCert},
+						ClientAuth:   tls.RequireAndVerifyClientCert,
+						MinVersion:   tls.VersionTLS13,
+					})
+				}
+
+				serveListener(t, svr, l, err)
+
+				return addr, pusuclt.TLSUnixDialer{ServerName: "localhost"}
+			},
+		},
+	}
+
+	certs, err := makeTestCerts()
+	if err != nil {
+		t.Fatal("couldn't make the test certificates:", err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			svr := pususvr.NewServer(slog.New(slog.DiscardHandler),
+				pususvr.NewSvrInfo())
+			t.Cleanup(func() { _ = svr.Close() })
+
+			info := pusuclt.NewConnInfo(nil)
+			info.SvrAddress, info.Dialer = tc.setup(t, svr, certs)
+
+			if info.Dialer.UsesTLS() {
+				info.CertInfo.SetCert(certs.cltCert)
+				info.CertInfo.SetCertPool(certs.certPool)
+			}
+
+			c, err := pusuclt.NewClient(testNamespace, t.Name(),
+				slog.New(slog.DiscardHandler), info)
+			if err != nil {
+				t.Fatal(tc.IDStr(), ": couldn't connect:", err)
+			}
+
+			t.Cleanup(func() { _ = c.Disconnect() })
+
+			rec := NewRecorder()
+
+			AwaitCallback(t, func(cb pusuclt.Callback) error {
+				return c.Subscribe(cb,
+					pusuclt.TopicHandler{Topic: "/a", Handler: rec.Handler()})
+			})
+			AwaitCallback(t, func(cb pusuclt.Callback) error {
+				return c.Publish(cb, "/a", []byte(tc.Name))
+			})
+
+			received := rec.WaitFor(1, testWait)
+			if !testhelper.DiffInt(t, tc.IDStr(), "received",
+				len(received), 1) {
+				testhelper.DiffString(t, tc.IDStr(), "payload",
+					string(received[0].Payload), tc.Name)
+			}
+		})
+	}
+}
+
+func TestFailover(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+
+	var (
+		svrs  []*pususvr.Server
+		addrs []string
+	)
+
+	for range 2 {
+		svr := pususvr.NewServer(logger, pususvr.NewSvrInfo())
+		t.Cleanup(func() { _ = svr.Close() })
+
+		l, err := net.Listen("tcp", localAddress)
+		serveListener(t, svr, l, err)
+
+		svrs = append(svrs, svr)
+		addrs = append(addrs, l.Addr().String())
+	}
+
+	dead, err := net.Listen("tcp", localAddress)
+	if err != nil {
+		t.Fatal("couldn't listen:", err)
+	}
+
+	_ = dead.Close()
+
+	info := pusuclt.NewConnInfo(nil)
+	info.Dialer = pusuclt.TCPDialer{}
+	info.SvrAddresses = []string{dead.Addr().String(), addrs[0], addrs[1]}
+	info.Reconnect = pusuclt.NewReconnectPolicy()
+	info.Reconnect.InitialBackoff = 10 * time.Millisecond
+
+	c, err := pusuclt.NewClient(testNamespace, t.Name(), logger, info)
+	if err != nil {
+		t.Fatal("couldn't connect:", err)
+	}
+
+	t.Cleanup(func() { _ = c.Disconnect() })
+
+	testhelper.DiffString(t, "initial connection", "server address",
+		c.ServerAddress(), addrs[0])
+
+	changes := c.StateChanges(10)
+
+	_ = svrs[0].Close()
+
+	for sc := range changes {
+		if sc.To == pusuclt.StateConnected {
+			break
+		}
+	}
+
+	testhelper.DiffString(t, "after failover", "server address",
+		c.ServerAddress(), addrs[1])
+
+	AwaitCallback(t, func(cb pusuclt.Callback) error {
+		return c.Publish(cb, "/a", []byte("after failover"))
+	})
+}
@@ -0,0 +1,13 @@
+/*
+Package pusutest provides features to help with testing code that uses the
+publish/subscribe client. It provides an in-process pub/sub server (a
+Broker) listening on the local host and clients already connected to
+it. The certificates needed for the connections are generated in memory and
+so no certificate files are needed.
+
+For unit tests of code that only needs a pusuclt.PubSub (or a Publisher
+or Subscriber) it also provides a Mock which records the calls made and
+lets the test deliver messages to the subscribed handlers and control the
+replies to each call.
+*/
+package pusutest
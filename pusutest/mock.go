@@ -0,0 +1,466 @@
+package pusutest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/pusu.mod/pusuclt"
+)
+
+// ErrMockDisconnected is passed to the Callbacks waiting for a reply when
+// the Mock is disconnected and is returned by the Mock's methods until it
+// is reconnected.
+var ErrMockDisconnected = errors.New("the mock client is disconnected")
+
+// Call records a call to one of the Mock's methods. The MT gives the type of
+// message the call would have sent to the pub/sub server: Publish,
+// Subscribe or Unsubscribe.
+type Call struct {
+	ID      int
+	MT      pusu.MsgType
+	Topics  []pusu.Topic
+	Payload []byte
+	Headers map[string]string
+	Retain  bool
+}
+
+// Mock is an implementation of pusuclt.PubSub for use in the tests of code
+// using a pub/sub client. It records the calls made, delivers the messages
+// injected by the test to the subscribed handlers and replies to each call
+// as directed by the test.
+//
+// If AutoReply is set (as it is by NewMock) every call is replied to
+// immediately with a nil error. Otherwise the Callbacks, and the Ctx
+// methods, wait until the test calls Ack or Fail with the ID of the Call or
+// until Disconnect is called.
+type Mock struct {
+	AutoReply bool
+
+	mtx          sync.Mutex
+	calls        []Call
+	handlers     []pusuclt.TopicHandler
+	pending      map[int]pusuclt.Callback
+	decodeErrs   []error
+	disconnected bool
+}
+
+var (
+	_ pusuclt.PubSub              = (*Mock)(nil)
+	_ pusuclt.DecodeErrorReporter = (*Mock)(nil)
+)
+
+// NewMock returns a properly initialised Mock which replies to every call
+// immediately.
+func NewMock() *Mock {
+	return &Mock{
+		AutoReply: true,
+		pending:   make(map[int]pusuclt.Callback),
+	}
+}
+
+// Calls returns a copy of the calls recorded so far
+func (m *Mock) Calls() []Call {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return slices.Clone(m.calls)
+}
+
+// Published returns a copy of the Publish calls recorded so far
+func (m *Mock) Published() []Call {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	var published []Call
+
+	for _, c := range m.calls {
+		if c.MT == pusu.Publish {
+			published = append(published, c)
+		}
+	}
+
+	return published
+}
+
+// Pending returns the IDs of the calls waiting for a reply, in the order
+// they were made
+func (m *Mock) Pending() []int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	ids := make([]int, 0, len(m.pending))
+	for id := range m.pending {
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+
+	return ids
+}
+
+// Ack replies to the call with the given ID with a nil error. It returns
+// false if the call is not waiting for a reply.
+func (m *Mock) Ack(id int) bool {
+	return m.Fail(id, nil)
+}
+
+// Fail replies to the call with the given ID with the error. It returns
+// false if the call is not waiting for a reply.
+func (m *Mock) Fail(id int, err error) bool {
+	m.mtx.Lock()
+	cb, ok := m.pending[id]
+	delete(m.pending, id)
+	m.mtx.Unlock()
+
+	if ok {
+		cb(err)
+	}
+
+	return ok
+}
+
+// Disconnect replies to every call waiting for a reply with
+// ErrMockDisconnected. Later calls will return ErrMockDisconnected until
+// Reconnect is called.
+func (m *Mock) Disconnect() {
+	m.mtx.Lock()
+	m.disconnected = true
+	pending := m.pending
+	m.pending = make(map[int]pusuclt.Callback)
+	m.mtx.Unlock()
+
+	for _, cb := range pending {
+		cb(ErrMockDisconnected)
+	}
+}
+
+// Reconnect allows calls to be made again after Disconnect. The handlers
+// subscribed before the Mock was disconnected are retained.
+func (m *Mock) Reconnect() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.disconnected = false
+}
+
+// DecodeError records the error. It is called for the messages that a
+// handler subscribed with pusuclt.SubscribeTyped could not decode.
+func (m *Mock) DecodeError(_ pusuclt.Delivery, err error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.decodeErrs = append(m.decodeErrs, err)
+}
+
+// DecodeErrors returns a copy of the errors recorded by DecodeError
+func (m *Mock) DecodeErrors() []error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return slices.Clone(m.decodeErrs)
+}
+
+// Deliver passes a message with the topic and payload to the handlers
+// subscribed to the topic or to any wildcard topic matching it. See
+// DeliverDelivery for details.
+func (m *Mock) Deliver(topic pusu.Topic, payload []byte) {
+	m.DeliverDelivery(pusuclt.Delivery{Topic: topic, Payload: payload})
+}
+
+// DeliverDelivery passes the Delivery to the handlers subscribed to its
+// topic or to any wildcard topic matching it, in the order they were
+// subscribed. The handlers are called before it returns.
+func (m *Mock) DeliverDelivery(d pusuclt.Delivery) {
+	m.mtx.Lock()
+
+	var ths []pusuclt.TopicHandler
+
+	for _, th := range m.handlers {
+		if th.Topic == d.Topic || th.Topic.Matches(d.Topic) {
+			ths = append(ths, th)
+		}
+	}
+
+	m.mtx.Unlock()
+
+	for _, th := range ths {
+		if th.Handler != nil {
+			th.Handler(d.Topic, d.Payload)
+		} else {
+			th.DeliveryHandler(d)
+		}
+	}
+}
+
+// record records the call and arranges for the Callback, if any, to be
+// called with the reply. It returns the ID of the call or
+// ErrMockDisconnected if the Mock is disconnected.
+func (m *Mock) record(c Call, cb pusuclt.Callback) (int, error) {
+	m.mtx.Lock()
+
+	if m.disconnected {
+		m.mtx.Unlock()
+
+		return 0, ErrMockDisconnected
+	}
+
+	c.ID = len(m.calls) + 1
+	m.calls = append(m.calls, c)
+
+	autoReply := m.AutoReply
+	if !autoReply && cb != nil {
+		m.pending[c.ID] = cb
+	}
+
+	m.mtx.Unlock()
+
+	if autoReply && cb != nil {
+		cb(nil)
+	}
+
+	return c.ID, nil
+}
+
+// recordCall records the call as for record but returns only the error
+func (m *Mock) recordCall(c Call, cb pusuclt.Callback) error {
+	_, err := m.record(c, cb)
+
+	return err
+}
+
+// await records the call and waits for its reply or for the context to be
+// done
+func (m *Mock) await(ctx context.Context, c Call) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	replyChan := make(chan error, 1)
+
+	id, err := m.record(c, func(err error) { replyChan <- err })
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-replyChan:
+		return err
+	case <-ctx.Done():
+	}
+
+	m.mtx.Lock()
+	delete(m.pending, id)
+	m.mtx.Unlock()
+
+	return ctx.Err()
+}
+
+// publishCall returns the Call for a Publish of the payload on the topic
+// with the PublishOpts applied
+func publishCall(topic pusu.Topic, payload []byte, opts []pusuclt.PublishOpt,
+) (Call, error) {
+	if err := topic.CheckConcrete(); err != nil {
+		return Call{}, err
+	}
+
+	pmp := pusu.PublishMsgPayload{
+		Topic:   string(topic),
+		Payload: payload,
+	}
+
+	for _, o := range opts {
+		if err := o(&pmp); err != nil {
+			return Call{}, err
+		}
+	}
+
+	return Call{
+		MT:      pusu.Publish,
+		Topics:  []pusu.Topic{topic},
+		Payload: slices.Clone(payload),
+		Headers: pmp.Headers,
+		Retain:  pmp.Retain,
+	}, nil
+}
+
+// Publish records the Publish call. See pusuclt.Client.Publish.
+func (m *Mock) Publish(
+	cb pusuclt.Callback,
+	topic pusu.Topic,
+	payload []byte,
+	opts ...pusuclt.PublishOpt,
+) error {
+	c, err := publishCall(topic, payload, opts)
+	if err != nil {
+		return err
+	}
+
+	return m.recordCall(c, cb)
+}
+
+// TryPublish records the Publish call. See pusuclt.Client.TryPublish.
+func (m *Mock) TryPublish(
+	cb pusuclt.Callback,
+	topic pusu.Topic,
+	payload []byte,
+	opts ...pusuclt.PublishOpt,
+) error {
+	return m.Publish(cb, topic, payload, opts...)
+}
+
+// PublishCtx records the Publish call and waits for the reply. See
+// pusuclt.Client.PublishCtx.
+func (m *Mock) PublishCtx(
+	ctx context.Context,
+	topic pusu.Topic,
+	payload []byte,
+	opts ...pusuclt.PublishOpt,
+) error {
+	c, err := publishCall(topic, payload, opts)
+	if err != nil {
+		return err
+	}
+
+	return m.await(ctx, c)
+}
+
+// checkHandlers returns a non-nil error if any of the TopicHandlers has a
+// bad topic, does not have exactly one handler or has the same handler and
+// topic as one already subscribed or earlier in the list. These are the
+// checks made by the pusuclt.Client.
+func checkHandlers(subscribed []pusuclt.TopicHandler,
+	handlers []pusuclt.TopicHandler,
+) error {
+	for i, th := range handlers {
+		if err := th.Topic.Check(); err != nil {
+			return fmt.Errorf("bad TopicHandler (%d): %w", i, err)
+		}
+
+		if th.Handler == nil && th.DeliveryHandler == nil {
+			return fmt.Errorf("the MsgHandler for Topic %q (%d) is nil",
+				th.Topic, i)
+		}
+
+		if th.Handler != nil && th.DeliveryHandler != nil {
+			return fmt.Errorf("both the MsgHandler and the DeliveryHandler"+
+				" for Topic %q (%d) are set",
+				th.Topic, i)
+		}
+
+		if slices.ContainsFunc(subscribed, th.Same) ||
+			slices.ContainsFunc(handlers[:i], th.Same) {
+			return fmt.Errorf(
+				"the handler for Topic %q (%d) has already been added",
+				th.Topic, i)
+		}
+	}
+
+	return nil
+}
+
+// subscriptionCall returns the Call for a (un)subscription of the handlers
+func subscriptionCall(mt pusu.MsgType, handlers []pusuclt.TopicHandler,
+) Call {
+	c := Call{MT: mt}
+
+	for _, th := range handlers {
+		c.Topics = append(c.Topics, th.Topic)
+	}
+
+	return c
+}
+
+// addHandlers checks the handlers and adds them. None are added if any of
+// them fails the checks.
+func (m *Mock) addHandlers(handlers []pusuclt.TopicHandler) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.disconnected {
+		return ErrMockDisconnected
+	}
+
+	if err := checkHandlers(m.handlers, handlers); err != nil {
+		return err
+	}
+
+	m.handlers = append(m.handlers, handlers...)
+
+	return nil
+}
+
+// removeHandlers removes the handlers, it returns an error if any of them
+// is not subscribed
+func (m *Mock) removeHandlers(handlers []pusuclt.TopicHandler) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.disconnected {
+		return ErrMockDisconnected
+	}
+
+	for i, th := range handlers {
+		idx := slices.IndexFunc(m.handlers, th.Same)
+		if idx < 0 {
+			return fmt.Errorf(
+				"there is no existing subscription for Topic %q (%d)",
+				th.Topic, i)
+		}
+
+		m.handlers = slices.Delete(m.handlers, idx, idx+1)
+	}
+
+	return nil
+}
+
+// Subscribe adds the handlers and records the Subscribe call. See
+// pusuclt.Client.Subscribe.
+func (m *Mock) Subscribe(cb pusuclt.Callback,
+	handlers ...pusuclt.TopicHandler,
+) error {
+	if err := m.addHandlers(handlers); err != nil {
+		return err
+	}
+
+	return m.recordCall(subscriptionCall(pusu.Subscribe, handlers), cb)
+}
+
+// SubscribeCtx adds the handlers, records the Subscribe call and waits for
+// the reply. See pusuclt.Client.SubscribeCtx.
+func (m *Mock) SubscribeCtx(ctx context.Context,
+	handlers ...pusuclt.TopicHandler,
+) error {
+	if err := m.addHandlers(handlers); err != nil {
+		return err
+	}
+
+	return m.await(ctx, subscriptionCall(pusu.Subscribe, handlers))
+}
+
+// Unsubscribe removes the handlers and records the Unsubscribe call. See
+// pusuclt.Client.Unsubscribe.
+func (m *Mock) Unsubscribe(cb pusuclt.Callback,
+	handlers ...pusuclt.TopicHandler,
+) error {
+	if err := m.removeHandlers(handlers); err != nil {
+		return err
+	}
+
+	return m.recordCall(subscriptionCall(pusu.Unsubscribe, handlers), cb)
+}
+
+// UnsubscribeCtx removes the handlers, records the Unsubscribe call and
+// waits for the reply. See pusuclt.Client.UnsubscribeCtx.
+func (m *Mock) UnsubscribeCtx(ctx context.Context,
+	handlers ...pusuclt.TopicHandler,
+) error {
+	if err := m.removeHandlers(handlers); err != nil {
+		return err
+	}
+
+	return m.await(ctx, subscriptionCall(pusu.Unsubscribe, handlers))
+}
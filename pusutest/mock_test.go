@@ -0,0 +1,240 @@
+package pusutest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/pusu.mod/pusuclt"
+	"github.com/nickwells/testhelper.mod/v2/testhelper"
+)
+
+func TestMockPublish(t *testing.T) {
+	m := NewMock()
+
+	var pub pusuclt.Publisher = m
+
+	err := pub.Publish(nil, "/a", []byte("data"), pusuclt.WithRetain(),
+		pusuclt.WithHeaders(map[string]string{"h": "v"}))
+	testhelper.CheckError(t, "Publish", err, false, nil)
+
+	err = pub.PublishCtx(t.Context(), "/b", []byte("more"))
+	testhelper.CheckError(t, "PublishCtx", err, false, nil)
+
+	err = pub.Publish(nil, "/a/*", nil)
+	testhelper.CheckError(t, "Publish - wildcard", err, true,
+		[]string{"wildcard"})
+
+	published := m.Published()
+	if testhelper.DiffInt(t, "published", "count", len(published), 2) {
+		return
+	}
+
+	p := published[0]
+	testhelper.DiffInt(t, "first Publish", "ID", p.ID, 1)
+	testhelper.DiffString(t, "first Publish", "topic", p.Topics[0], "/a")
+	testhelper.DiffString(t, "first Publish", "payload",
+		string(p.Payload), "data")
+	testhelper.DiffString(t, "first Publish", "header", p.Headers["h"], "v")
+	testhelper.DiffBool(t, "first Publish", "retain", p.Retain, true)
+	testhelper.DiffString(t, "second Publish", "topic",
+		published[1].Topics[0], "/b")
+}
+
+func TestMockDeliver(t *testing.T) {
+	m := NewMock()
+
+	var sub pusuclt.Subscriber = m
+
+	rec := NewRecorder()
+	wildRec := NewRecorder()
+
+	var deliveries []pusuclt.Delivery
+
+	err := sub.SubscribeCtx(t.Context(),
+		pusuclt.TopicHandler{Topic: "/a", Handler: rec.Handler()},
+		pusuclt.TopicHandler{Topic: "/*", Handler: wildRec.Handler()},
+		pusuclt.TopicHandler{
+			Topic: "/b",
+			DeliveryHandler: func(d pusuclt.Delivery) {
+				deliveries = append(deliveries, d)
+			},
+		})
+	testhelper.CheckError(t, "SubscribeCtx", err, false, nil)
+
+	m.Deliver("/a", []byte("1"))
+	m.DeliverDelivery(pusuclt.Delivery{
+		Topic:   "/b",
+		Payload: []byte("2"),
+		Headers: map[string]string{"h": "v"},
+	})
+
+	err = sub.Unsubscribe(nil,
+		pusuclt.TopicHandler{Topic: "/c", Handler: rec.Handler()})
+	testhelper.CheckError(t, "Unsubscribe - not subscribed", err, true,
+		[]string{`there is no existing subscription for Topic "/c"`})
+
+	err = sub.Unsubscribe(nil,
+		pusuclt.TopicHandler{Topic: "/a", Handler: rec.Handler()})
+	testhelper.CheckError(t, "Unsubscribe", err, false, nil)
+
+	m.Deliver("/a", []byte("3"))
+
+	testhelper.DiffInt(t, "recorder", "count", len(rec.Received()), 1)
+	testhelper.DiffInt(t, "wildcard recorder", "count",
+		len(wildRec.Received()), 3)
+
+	if !testhelper.DiffInt(t, "deliveries", "count", len(deliveries), 1) {
+		testhelper.DiffString(t, "delivery", "header",
+			deliveries[0].Headers["h"], "v")
+	}
+
+	mts := []pusu.MsgType{}
+	for _, c := range m.Calls() {
+		mts = append(mts, c.MT)
+	}
+
+	if !slices.Equal(mts,
+		[]pusu.MsgType{pusu.Subscribe, pusu.Unsubscribe}) {
+		t.Errorf("unexpected calls: %v", mts)
+	}
+}
+
+func TestMockSubscribeChecks(t *testing.T) {
+	m := NewMock()
+	rec := NewRecorder()
+
+	err := m.Subscribe(nil, pusuclt.TopicHandler{
+		Topic:           "/a",
+		Handler:         rec.Handler(),
+		DeliveryHandler: func(pusuclt.Delivery) {},
+	})
+	testhelper.CheckError(t, "Subscribe - both handlers", err, true,
+		[]string{"both the MsgHandler and the DeliveryHandler",
+			`for Topic "/a" (0) are set`})
+
+	err = m.Subscribe(nil,
+		pusuclt.TopicHandler{Topic: "/a", Handler: rec.Handler()},
+		pusuclt.TopicHandler{Topic: "/a", Handler: rec.Handler()})
+	testhelper.CheckError(t, "Subscribe - duplicate in the call", err, true,
+		[]string{`the handler for Topic "/a" (1) has already been added`})
+
+	err = m.Subscribe(nil,
+		pusuclt.TopicHandler{Topic: "/a", Handler: rec.Handler()})
+	testhelper.CheckError(t, "Subscribe", err, false, nil)
+
+	err = m.Subscribe(nil,
+		pusuclt.TopicHandler{Topic: "/b", Handler: rec.Handler()},
+		pusuclt.TopicHandler{Topic: "/a", Handler: rec.Handler()})
+	testhelper.CheckError(t, "Subscribe - already subscribed", err, true,
+		[]string{`the handler for Topic "/a" (1) has already been added`})
+
+	m.Deliver("/b", []byte("b"))
+	testhelper.DiffInt(t, "recorder", "count", len(rec.Received()), 0)
+
+	err = m.Subscribe(nil,
+		pusuclt.TopicHandler{Topic: "/a", Handler: NewRecorder().Handler()})
+	testhelper.CheckError(t, "Subscribe - another handler", err, false, nil)
+
+	testhelper.DiffInt(t, "calls", "count", len(m.Calls()), 2)
+}
+
+func TestMockReplies(t *testing.T) {
+	testErr := errors.New("test error")
+
+	m := NewMock()
+	m.AutoReply = false
+
+	replies := map[string]error{}
+	reply := func(name string) pusuclt.Callback {
+		return func(err error) { replies[name] = err }
+	}
+
+	_ = m.Publish(reply("acked"), "/a", nil)
+	_ = m.Publish(reply("failed"), "/a", nil)
+	_ = m.Publish(reply("disconnected"), "/a", nil)
+
+	if !slices.Equal(m.Pending(), []int{1, 2, 3}) {
+		t.Errorf("unexpected pending calls: %v", m.Pending())
+	}
+
+	testhelper.DiffBool(t, "Ack", "pending", m.Ack(1), true)
+	testhelper.DiffBool(t, "Ack again", "pending", m.Ack(1), false)
+	testhelper.DiffBool(t, "Fail", "pending", m.Fail(2, testErr), true)
+
+	m.Disconnect()
+
+	testhelper.CheckError(t, "acked", replies["acked"], false, nil)
+
+	if !errors.Is(replies["failed"], testErr) {
+		t.Errorf("failed: unexpected error: %v", replies["failed"])
+	}
+
+	if !errors.Is(replies["disconnected"], ErrMockDisconnected) {
+		t.Errorf("disconnected: unexpected error: %v",
+			replies["disconnected"])
+	}
+
+	if err := m.Publish(nil, "/a", nil); !errors.Is(err,
+		ErrMockDisconnected) {
+		t.Errorf("Publish while disconnected: unexpected error: %v", err)
+	}
+
+	m.Reconnect()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	err := m.PublishCtx(ctx, "/a", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("PublishCtx without a reply: unexpected error: %v", err)
+	}
+
+	testhelper.DiffInt(t, "pending", "count", len(m.Pending()), 0)
+}
+
+func TestMockTyped(t *testing.T) {
+	const topic = pusu.Topic("/typed")
+
+	type val struct {
+		Name string
+	}
+
+	m := NewMock()
+	codec := pusuclt.JSONCodec[val]{}
+
+	var got []val
+
+	th, err := pusuclt.SubscribeTyped(m, nil, codec, topic,
+		func(_ pusu.Topic, v val) { got = append(got, v) })
+	testhelper.CheckError(t, "SubscribeTyped", err, false, nil)
+
+	err = pusuclt.PublishTyped(m, nil, codec, topic, val{Name: "a"})
+	testhelper.CheckError(t, "PublishTyped", err, false, nil)
+
+	published := m.Published()
+	if testhelper.DiffInt(t, "published", "count", len(published), 1) {
+		return
+	}
+
+	m.Deliver(topic, published[0].Payload)
+	m.Deliver(topic, []byte("not JSON"))
+
+	if testhelper.DiffInt(t, "handler calls", "count", len(got), 1) {
+		return
+	}
+
+	testhelper.DiffString(t, "handler value", "name", got[0].Name, "a")
+	testhelper.DiffInt(t, "decode errors", "count",
+		len(m.DecodeErrors()), 1)
+
+	err = m.Unsubscribe(nil, th)
+	testhelper.CheckError(t, "Unsubscribe", err, false, nil)
+
+	m.Deliver(topic, published[0].Payload)
+	testhelper.DiffInt(t, "handler calls after Unsubscribe", "count",
+		len(got), 1)
+}
@@ -0,0 +1,85 @@
+package pusutest
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nickwells/pusu.mod/pusu"
+	"github.com/nickwells/pusu.mod/pusuclt"
+)
+
+// Received records a single message passed to a Recorder's MsgHandler
+type Received struct {
+	Topic   pusu.Topic
+	Payload []byte
+}
+
+// Recorder records the messages passed to its MsgHandler so that tests can
+// check what was received.
+type Recorder struct {
+	mtx      sync.Mutex
+	received []Received
+	notify   chan struct{} // signalled whenever a message is recorded
+	handler  pusuclt.MsgHandler
+}
+
+// NewRecorder returns a properly initialised Recorder
+func NewRecorder() *Recorder {
+	r := &Recorder{
+		notify: make(chan struct{}, 1),
+	}
+	r.handler = r.record
+
+	return r
+}
+
+// Handler returns a MsgHandler that records each message it is passed. The
+// same function value is returned each time so that it can be passed to
+// Unsubscribe.
+func (r *Recorder) Handler() pusuclt.MsgHandler {
+	return r.handler
+}
+
+// record records the message
+func (r *Recorder) record(topic pusu.Topic, payload []byte) {
+	r.mtx.Lock()
+	r.received = append(r.received, Received{
+		Topic:   topic,
+		Payload: slices.Clone(payload),
+	})
+	r.mtx.Unlock()
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Received returns a copy of the messages recorded so far
+func (r *Recorder) Received() []Received {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return slices.Clone(r.received)
+}
+
+// WaitFor waits until at least n messages have been recorded or until the
+// timeout expires, whichever comes first. It returns a copy of the messages
+// recorded so far; the caller should check that enough were received.
+func (r *Recorder) WaitFor(n int, timeout time.Duration) []Received {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		if received := r.Received(); len(received) >= n {
+			return received
+		}
+
+		select {
+		case <-r.notify:
+		case <-deadline.C:
+			return r.Received()
+		}
+	}
+}